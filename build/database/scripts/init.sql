@@ -6,9 +6,14 @@ CREATE TABLE users (
     password_hash VARCHAR(128) NOT NULL,
     email VARCHAR(100) NOT NULL,
     is_disabled BOOLEAN,
+    is_verified BOOLEAN NOT NULL DEFAULT false,
+    is_admin BOOLEAN NOT NULL DEFAULT false,
     PRIMARY KEY(id)
 );
 
+-- Emails are compared case-insensitively, so 'Foo@x.com' and 'foo@x.com' are the same email
+CREATE UNIQUE INDEX users_email_lower_key ON users (lower(email));
+
 CREATE TABLE user_sessions
 (
     session_key TEXT,
@@ -21,6 +26,101 @@ CREATE TABLE user_sessions
             REFERENCES users(id)
 );
 
+CREATE TABLE password_reset_tokens
+(
+    token_hash CHAR(64),
+    user_id INT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    PRIMARY KEY (token_hash),
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
+
+CREATE TABLE user_totp
+(
+    user_id INT,
+    secret VARCHAR(64) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id),
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
+
+CREATE TABLE user_recovery_codes
+(
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    PRIMARY KEY (user_id, code_hash),
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
+
+CREATE TABLE api_keys
+(
+    id int GENERATED ALWAYS AS IDENTITY,
+    user_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL UNIQUE,
+    key_hash CHAR(64) NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
+
+CREATE TABLE refresh_token_families
+(
+    id VARCHAR(32),
+    user_id INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
+
+CREATE TABLE refresh_tokens
+(
+    token_hash CHAR(64),
+    family_id VARCHAR(32) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    PRIMARY KEY (token_hash),
+    CONSTRAINT fk_family
+        FOREIGN KEY (family_id)
+            REFERENCES refresh_token_families(id)
+            ON DELETE CASCADE
+);
+
+CREATE TABLE user_identities
+(
+    issuer VARCHAR(255),
+    subject VARCHAR(255),
+    user_id INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (issuer, subject),
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
+
 CREATE TABLE data_table
 (
     id SERIAL PRIMARY KEY,
@@ -34,5 +134,5 @@ INSERT INTO data_table (string) VALUES ('data4');
 INSERT INTO data_table (string) VALUES ('data5');
 
 /* username=test password=password */
-INSERT INTO users (username, fullname, password_salt, password_hash, email, is_disabled) 
-VALUES ('test', 'Test User', 'TESTSALT', '7fc909bc1888eb3b5c717dfcca83a2b1b031ecb40ed6ad4278399d78d29ea0212805336b86df2c7254e9d206eee53b5300edeaeee6f35bb96b8f0890c693d24f', 'test@email.com', false);
+INSERT INTO users (username, fullname, password_salt, password_hash, email, is_disabled, is_verified) 
+VALUES ('test', 'Test User', 'TESTSALT', '7fc909bc1888eb3b5c717dfcca83a2b1b031ecb40ed6ad4278399d78d29ea0212805336b86df2c7254e9d206eee53b5300edeaeee6f35bb96b8f0890c693d24f', 'test@email.com', false, true);
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sergeikus/go-rest-template/pkg/conf"
+)
+
+// configCommand validates or prints configuration or its JSON Schema without starting the server
+func configCommand(args []string) {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("config "+args[0], flag.ExitOnError)
+	configuration := fs.String("config", defaultConfigPath, "Path to server configuration, "+
+		"if empty configuration is read from environment variables only")
+	effective := fs.Bool("effective", false, "Print configuration with default values of the fields which are not set")
+
+	switch args[0] {
+	case "validate":
+		fs.Parse(args[1:])
+		c, err := readConf(*configuration, ".")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := c.Validate(); err != nil {
+			var problems conf.ValidationErrors
+			if !errors.As(err, &problems) {
+				problems = conf.ValidationErrors{{Message: err.Error()}}
+			}
+			fmt.Fprintf(os.Stderr, "Configuration is invalid, %d problem(s) found:\n", len(problems))
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "  %s\n", p)
+			}
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid")
+	case "print":
+		fs.Parse(args[1:])
+		c, err := readConf(*configuration, ".")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *effective {
+			c = c.Effective()
+		}
+		// Secrets are redacted
+		fmt.Print(c)
+	case "schema":
+		fs.Parse(args[1:])
+		schema, err := conf.Schema()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Print(string(schema))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown config command: '%s'\n", args[0])
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/sergeikus/go-rest-template/pkg/conf"
+	"github.com/sergeikus/go-rest-template/pkg/handler"
+)
+
+// errListenerClosed is returned by Accept of a server which is replaced or stopped
+var errListenerClosed = errors.New("listener is closed")
+
+// servers runs a server on each listener. Server settings (timeouts, header
+// size limit) are changed by starting new servers on the same listeners: new
+// connections are accepted by the new servers, the previous ones finish active
+// requests and stop.
+type servers struct {
+	listeners []*sharedListener
+	httpsPort int
+	errs      chan error
+
+	mutex    sync.Mutex
+	template *http.Server
+	running  []*http.Server
+}
+
+// sharedListener accepts connections of a listener socket and hands them
+// over to the server which currently runs on it
+type sharedListener struct {
+	conf     conf.Listener
+	addr     net.Addr
+	accepted chan accepted
+}
+
+type accepted struct {
+	conn net.Conn
+	err  error
+}
+
+// startServers opens listeners and starts a server on each of them with settings of the template server
+func startServers(c *conf.Conf, template *http.Server) (*servers, error) {
+	listeners := c.ServerListeners()
+	httpsPort, _ := c.HTTPSPort()
+	s := &servers{template: template, httpsPort: httpsPort, errs: make(chan error, len(listeners))}
+	for _, l := range listeners {
+		ln, err := listen(l)
+		if err != nil {
+			return nil, err
+		}
+		sl := &sharedListener{conf: l, addr: ln.Addr(), accepted: make(chan accepted)}
+		go sl.acceptLoop(ln)
+		s.listeners = append(s.listeners, sl)
+
+		switch {
+		case l.RedirectToHTTPS:
+			log.Printf("Starting HTTPS redirect on %s %s", l.GetNetwork(), l.Address)
+		case l.TLS:
+			log.Printf("Starting HTTPS server on %s %s", l.GetNetwork(), l.Address)
+		default:
+			log.Printf("Starting HTTP server on %s %s", l.GetNetwork(), l.Address)
+		}
+	}
+	s.running = s.start(s.template)
+	return s, nil
+}
+
+// wait returns the first error a server stops with
+func (s *servers) wait() error {
+	return <-s.errs
+}
+
+// SetSettings replaces the running servers by ones with new timeouts and header size limit,
+// the previous servers stop accepting connections and stop once active requests are finished
+func (s *servers) SetSettings(cs conf.Server) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	template := &http.Server{
+		Handler:      s.template.Handler,
+		TLSConfig:    s.template.TLSConfig,
+		TLSNextProto: s.template.TLSNextProto,
+	}
+	cs.Apply(template)
+	if template.ReadHeaderTimeout == s.template.ReadHeaderTimeout && template.ReadTimeout == s.template.ReadTimeout &&
+		template.WriteTimeout == s.template.WriteTimeout && template.IdleTimeout == s.template.IdleTimeout &&
+		template.MaxHeaderBytes == s.template.MaxHeaderBytes {
+		return
+	}
+	previous := s.running
+	s.template = template
+	s.running = s.start(s.template)
+	for _, srv := range previous {
+		go srv.Shutdown(context.Background())
+	}
+}
+
+// start starts a server on each listener
+func (s *servers) start(template *http.Server) []*http.Server {
+	running := make([]*http.Server, 0, len(s.listeners))
+	for _, sl := range s.listeners {
+		srv := &http.Server{
+			Handler:           template.Handler,
+			ReadHeaderTimeout: template.ReadHeaderTimeout,
+			ReadTimeout:       template.ReadTimeout,
+			WriteTimeout:      template.WriteTimeout,
+			IdleTimeout:       template.IdleTimeout,
+			MaxHeaderBytes:    template.MaxHeaderBytes,
+			// Server modifies TLS configuration on start, so it must not be shared
+			TLSConfig:    template.TLSConfig.Clone(),
+			TLSNextProto: template.TLSNextProto,
+		}
+		if sl.conf.RedirectToHTTPS {
+			srv.Handler = handler.RedirectToHTTPS(s.httpsPort)
+		}
+		running = append(running, srv)
+
+		ln := &serverListener{shared: sl, done: make(chan struct{})}
+		go func(l conf.Listener, srv *http.Server) {
+			var err error
+			if l.TLS && !l.RedirectToHTTPS {
+				// Certificate is served by the reloader
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.Serve(ln)
+			}
+			if err == http.ErrServerClosed {
+				return
+			}
+			select {
+			case s.errs <- err:
+			default:
+			}
+		}(sl.conf, srv)
+	}
+	return running
+}
+
+// acceptLoop accepts connections until the listener fails
+func (sl *sharedListener) acceptLoop(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		sl.accepted <- accepted{conn: conn, err: err}
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+	}
+}
+
+// serverListener is a listener of a single server, closing it doesn't close the shared listener
+type serverListener struct {
+	shared *sharedListener
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (sl *serverListener) Accept() (net.Conn, error) {
+	select {
+	case a := <-sl.shared.accepted:
+		return a.conn, a.err
+	case <-sl.done:
+		return nil, errListenerClosed
+	}
+}
+
+func (sl *serverListener) Close() error {
+	sl.once.Do(func() { close(sl.done) })
+	return nil
+}
+
+func (sl *serverListener) Addr() net.Addr {
+	return sl.shared.addr
+}
+
+// listen opens listener socket, stale Unix socket file left by a previous run is removed
+func listen(l conf.Listener) (net.Listener, error) {
+	if l.GetNetwork() != conf.NetworkUnix {
+		ln, err := net.Listen(l.GetNetwork(), l.Address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on '%s': %v", l.Address, err)
+		}
+		return ln, nil
+	}
+
+	if info, err := os.Lstat(l.Address); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("'%s' exists and is not a socket", l.Address)
+		}
+		if err := os.Remove(l.Address); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket '%s': %v", l.Address, err)
+		}
+	}
+	ln, err := net.Listen(conf.NetworkUnix, l.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on '%s': %v", l.Address, err)
+	}
+	if err := os.Chmod(l.Address, l.GetSocketMode()); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket '%s' permissions: %v", l.Address, err)
+	}
+	return ln, nil
+}
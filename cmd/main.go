@@ -1,84 +1,192 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/sergeikus/go-rest-template/pkg/auth"
-	"github.com/sergeikus/go-rest-template/pkg/conf"
+	"github.com/sergeikus/go-rest-template/pkg/certs"
 	"github.com/sergeikus/go-rest-template/pkg/handler"
+	"github.com/sergeikus/go-rest-template/pkg/logging"
 	"github.com/sergeikus/go-rest-template/pkg/storage"
 )
 
+// defaultConfigPath is used if '--config' flag is not set
+const defaultConfigPath = "../configs/config.yaml"
+
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage:", os.Args[0], `[--config <path>]
+		fmt.Fprintln(os.Stderr, "usage:", os.Args[0], `[--config <path>]`)
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], `gen-cert [--cert <path>] [--key <path>] [--hosts <hosts>] [--days <days>] [--force]`)
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], `config validate [--config <path>]`)
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], `config print [--config <path>] [--effective]`)
+		fmt.Fprintln(os.Stderr, "      ", os.Args[0], `config schema
 		`)
 		flag.PrintDefaults()
 	}
-	configuration := flag.String("config", "../configs/config.yaml", "Path to server configuration (supported format is YAML)")
+	if len(os.Args) > 1 && os.Args[1] == "gen-cert" {
+		genCert(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		configCommand(os.Args[2:])
+		return
+	}
+	configuration := flag.String("config", defaultConfigPath, "Path to server configuration (supported formats are YAML, JSON and TOML), "+
+		"if empty configuration is read from environment variables only")
 	flag.Parse()
 
-	c, err := conf.ReadConf(*configuration)
+	// Environment variables are resolved relatively to this directory on reloads as well
+	currentDir, err := os.Getwd()
 	if err != nil {
-		log.Fatalf("failed to read config: %v", err)
+		log.Fatalf("Error: failed to get current working directory: %v", err)
 	}
-
-	if err := c.Validate(); err != nil {
-		log.Fatalf("configuration validation failed: %v", err)
+	c, err := loadConf(*configuration, currentDir)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
 	}
-
-	// Change working directory to specify files relativly to the configuration file location
-	currentDir, err := os.Getwd()
+	// Messages below the level are dropped, so fatal messages are marked as errors
+	logFilter, err := logging.DefineFilter(os.Stderr, c.LogLevel)
 	if err != nil {
-		log.Fatalf("failed to get current working directory: %v", err)
+		log.Fatalf("Error: %v", err)
+	}
+	log.SetOutput(logFilter)
+	if c.Dev {
+		// Secrets are redacted
+		log.Printf("Configuration:\n%s", c)
 	}
 
-	if err := os.Chdir(filepath.Dir(filepath.Join(currentDir, *configuration))); err != nil {
-		log.Fatalf("failed to change working directory: %v", err)
+	reloader := &configReloader{current: c, envDir: currentDir, logFilter: logFilter}
+	// Change working directory to specify files relativly to the configuration file location
+	if len(*configuration) != 0 {
+		reloader.path = filepath.Join(currentDir, *configuration)
+		if info, err := os.Stat(reloader.path); err == nil {
+			reloader.modTime = info.ModTime()
+		}
+		if err := os.Chdir(filepath.Dir(reloader.path)); err != nil {
+			log.Fatalf("Error: failed to change working directory: %v", err)
+		}
 	}
 
-	httpServer := &http.Server{
-		Addr: fmt.Sprintf(":%s", strconv.Itoa(c.Port)),
+	// Template for the servers started on each listener
+	httpServer := &http.Server{}
+	c.Server.Apply(httpServer)
+	if c.TLS {
+		httpServer.TLSConfig, err = c.TLSOptions.Config()
+		if err != nil {
+			log.Fatalf("Error: failed to initialize TLS configuration: %v", err)
+		}
+		// Go HTTP server adds HTTP/2 unless it is explicitly disabled
+		if !contains(httpServer.TLSConfig.NextProtos, "h2") {
+			httpServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+		if ag := c.TLSAutoGenerate; ag.Enabled {
+			generated, err := certs.EnsureSelfSigned(c.TLSCertPath, c.TLSKeyPath, ag.Hosts, ag.ValidFor())
+			if err != nil {
+				log.Fatalf("Error: failed to generate TLS certificate: %v", err)
+			}
+			if generated {
+				log.Printf("Warning: generated self-signed TLS certificate '%s', do not use it in production", c.TLSCertPath)
+			}
+		}
+		reloader.certs, err = certs.DefineReloader(c.TLSCertPath, c.TLSKeyPath)
+		if err != nil {
+			log.Fatalf("Error: failed to load TLS certificate: %v", err)
+		}
+		httpServer.TLSConfig.GetCertificate = reloader.certs.GetCertificate
+		if c.TLSOptions.ReloadInterval != 0 {
+			stopWatch := reloader.certs.Watch(time.Duration(c.TLSOptions.ReloadInterval) * time.Second)
+			defer stopWatch()
+		}
 	}
 
 	log.Printf("Initializing storage...")
-	// This environmental variable will override configuration
-	inMemoryOverride := os.Getenv("DB_TYPE_INMEMORY")
-	if strings.ToLower(inMemoryOverride) == "true" {
-		c.Database.Type = "in-memory"
-	}
 	log.Printf("Database type is: %s", c.Database.Type)
 	var api handler.API
 	switch c.Database.Type {
 	case storage.DatabaseTypeInMemory:
 		api.DB = &storage.InMemoryStorage{}
 	case storage.DatabaseTypePostgre:
-		api.DB = storage.DefinePostgresStorage(
-			c.Database.Username, c.Database.Password, c.Database.Name, c.Database.Host, c.Database.Port,
-		)
+		pc := c.Database.PostgresConfig()
+		log.Printf("Database address is: %s", pc.Address())
+		api.DB = storage.DefinePostgresStorage(pc)
 	default:
-		log.Fatalf("Unsupported database type: '%s'", c.Database.Type)
+		log.Fatalf("Error: unsupported database type: '%s'", c.Database.Type)
 	}
 
 	log.Printf("Initializing authorization...")
+	if c.Dev {
+		log.Printf("Warning: development mode is enabled, do not use it in production")
+	}
+	api.Cookie = c.Authorization.Cookie.Config()
 	log.Printf("Authorization type is: %s", c.Authorization.Type)
 	switch c.Authorization.Type {
 	case auth.SSMType:
-		api.Auth = auth.DefineSSM(c.Authorization.SessionDuration, c.Authorization.PBKDF2Iterations, c.Authorization.PBKDF2KeyLenght)
+		if !c.TLS && c.Authorization.Cookie.IsSecure() {
+			log.Printf("Warning: TLS is disabled, but session cookie is Secure, it will be sent only behind an HTTPS proxy")
+		}
+		ssm := auth.DefineSSM(c.Authorization.SessionDuration, c.Authorization.MaxSessionLifetime, c.Authorization.PBKDF2Iterations, c.Authorization.PBKDF2KeyLenght, api.Cookie)
+		stopJanitor := ssm.StartJanitor(c.Authorization.SweepInterval())
+		defer stopJanitor()
+		api.Auth = ssm
+		reloader.ssm = ssm
+	case auth.TokenType:
+		ta := auth.DefineTokenAuth(c.Authorization.AccessTokenDuration, c.Authorization.RefreshTokenDuration, c.Authorization.PBKDF2Iterations, c.Authorization.PBKDF2KeyLenght, api.DB)
+		stopJanitor := ta.StartJanitor(c.Authorization.SweepInterval())
+		defer stopJanitor()
+		api.Auth = ta
+		api.TokenAuth = ta
+		reloader.tokenAuth = ta
 	default:
-		log.Fatalf("Unsupported authorization type: '%s'", c.Authorization.Type)
+		log.Fatalf("Error: unsupported authorization type: '%s'", c.Authorization.Type)
+	}
+	if c.TLSClientAuth.Enabled() {
+		log.Printf("TLS client authentication is enabled, mode is: %s", c.TLSClientAuth.Mode)
+		if err := c.TLSClientAuth.Apply(httpServer.TLSConfig); err != nil {
+			log.Fatalf("Error: failed to initialize TLS client authentication: %v", err)
+		}
+		api.Auth = auth.DefineClientCertAuth(api.Auth, api.DB, c.TLSClientAuth.Mapping, c.TLSClientAuth.Scopes, c.Authorization.EmailVerification.Enabled)
+	}
+	if c.Authorization.APIKeys {
+		log.Printf("API key authorization is enabled")
+		api.Auth = auth.DefineAPIKeyAuth(api.Auth, api.DB)
+	}
+	api.PasswordPolicy, err = c.Authorization.PasswordPolicy.Policy()
+	if err != nil {
+		log.Fatalf("Error: failed to initialize password policy: %v", err)
+	}
+	api.PasswordResetTokenDuration = time.Duration(c.Authorization.PasswordResetTokenDuration) * time.Second
+	api.PasswordResetURL = c.Authorization.PasswordResetURL
+	if ev := c.Authorization.EmailVerification; ev.Enabled {
+		log.Printf("Email verification is enabled")
+		api.EmailVerifier = auth.DefineEmailVerifier(ev.Key, time.Duration(ev.TokenDuration)*time.Second)
+		api.EmailVerificationURL = ev.URL
+	}
+	api.TOTPIssuer = c.Authorization.TwoFactor.Issuer
+	if len(api.TOTPIssuer) == 0 {
+		api.TOTPIssuer = "go-rest-template"
+	}
+	api.RequireTOTPForAdmins = c.Authorization.TwoFactor.RequireForAdmins
+	if o := c.Authorization.OIDC; o.Enabled {
+		log.Printf("OpenID Connect log in is enabled, issuer is: %s", o.Issuer)
+		api.OIDC = auth.DefineOIDCProvider(o.Issuer, o.ClientID, o.ClientSecret, o.RedirectURL, o.Scopes)
 	}
 
+	log.Printf("Notifier type is: %s", c.Notifier.Type)
+	api.Notifier = c.Notifier.Notifier()
+
 	log.Printf("Performing connection to database...")
 	if err := api.DB.Connect(); err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
+		log.Fatalf("Error: failed to connect to database: %v", err)
 	}
 	log.Printf("Successfully connected to database")
 	// Close connection when application shuts down
@@ -92,17 +200,96 @@ func main() {
 	http.HandleFunc("/api/login", api.LogIn)
 	http.HandleFunc("/api/logout", api.Logout)
 	http.HandleFunc("/api/login/status", api.LogInStatus)
+	http.HandleFunc("/api/token/refresh", api.RefreshToken)
+	http.HandleFunc("/api/oidc/login", api.OIDCLogIn)
+	http.HandleFunc("/api/oidc/callback", api.OIDCCallback)
+	http.HandleFunc("/api/login/totp", api.LogInTOTP)
+	http.HandleFunc("/api/user/totp/enroll", api.EnrollTOTP)
+	http.HandleFunc("/api/user/totp/confirm", api.ConfirmTOTP)
+	http.HandleFunc("/api/user/sessions", api.ListSessions)
+	http.HandleFunc("/api/user/sessions/revoke", api.RevokeSession)
+	http.HandleFunc("/api/user/sessions/revoke/all", api.LogoutEverywhere)
+	http.HandleFunc("/api/admin/sessions/revoke", api.AdminRevokeSessions)
+	http.HandleFunc("/api/user/apikeys", api.ListAPIKeys)
+	http.HandleFunc("/api/user/apikeys/create", api.CreateAPIKey)
+	http.HandleFunc("/api/user/apikeys/revoke", api.RevokeAPIKey)
 	http.HandleFunc("/api/register/user", api.RegisterUser)
+	http.HandleFunc("/api/register/verify", api.VerifyEmail)
+	http.HandleFunc("/api/register/verify/resend", api.ResendVerification)
+	http.HandleFunc("/api/user/password/change", api.ChangePassword)
+	http.HandleFunc("/api/password/reset/request", api.RequestPasswordReset)
+	http.HandleFunc("/api/password/reset", api.ResetPassword)
 
-	if c.TLS {
-		log.Printf("Starting HTTPS server")
-		if err := httpServer.ListenAndServeTLS(c.TLSCertPath, c.TLSKeyPath); err != nil {
-			log.Fatalf("failed to initialize TLS server: %v", err)
+	api.SetBodyLimits(c.Server.GetMaxBodyBytes(), c.Server.RouteMaxBodyBytes)
+	api.SetRateLimits(c.Authorization.RateLimits.Config())
+	httpServer.Handler = api.LimitBody(http.DefaultServeMux)
+	if c.Authorization.CSRF {
+		log.Printf("CSRF protection is enabled")
+		httpServer.Handler = api.CSRF(httpServer.Handler)
+	}
+
+	srvs, err := startServers(&c, httpServer)
+	if err != nil {
+		log.Fatalf("Error: failed to start servers: %v", err)
+	}
+
+	// SIGHUP reloads configuration and TLS certificate
+	reloader.api = &api
+	reloader.servers = srvs
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloader.reload()
 		}
-	} else {
-		log.Printf("Starting HTTP server")
-		if err := httpServer.ListenAndServe(); err != nil {
-			log.Fatalf("failed to initialize server: %v", err)
+	}()
+	if c.ReloadInterval != 0 && len(reloader.path) != 0 {
+		stopWatch := reloader.watch(time.Duration(c.ReloadInterval) * time.Second)
+		defer stopWatch()
+	}
+
+	if err := srvs.wait(); err != nil {
+		log.Fatalf("Error: failed to serve: %v", err)
+	}
+}
+
+// genCert writes a self-signed development certificate
+func genCert(args []string) {
+	fs := flag.NewFlagSet("gen-cert", flag.ExitOnError)
+	certPath := fs.String("cert", "tls.crt", "Path to write certificate to")
+	keyPath := fs.String("key", "tls.key", "Path to write key to")
+	hosts := fs.String("hosts", strings.Join(certs.DefaultHosts, ","), "Comma-separated DNS names and IP addresses of the certificate")
+	days := fs.Int("days", 365, "Certificate validity in days")
+	force := fs.Bool("force", false, "Overwrite existing certificate and key")
+	fs.Parse(args)
+
+	if *days <= 0 {
+		log.Fatalf("certificate validity must be a positive number of days")
+	}
+	if !*force {
+		for _, path := range []string{*certPath, *keyPath} {
+			if _, err := os.Stat(path); err == nil {
+				log.Fatalf("'%s' already exists, use --force to overwrite it", path)
+			}
+		}
+	}
+	var sans []string
+	for _, h := range strings.Split(*hosts, ",") {
+		if h = strings.TrimSpace(h); len(h) != 0 {
+			sans = append(sans, h)
+		}
+	}
+	if err := certs.WriteSelfSigned(*certPath, *keyPath, sans, time.Duration(*days)*24*time.Hour); err != nil {
+		log.Fatalf("failed to generate certificate: %v", err)
+	}
+	log.Printf("Self-signed certificate is written to '%s' and key to '%s'", *certPath, *keyPath)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
 	}
+	return false
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/certs"
+	"github.com/sergeikus/go-rest-template/pkg/conf"
+	"github.com/sergeikus/go-rest-template/pkg/handler"
+	"github.com/sergeikus/go-rest-template/pkg/logging"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+)
+
+// loadConf reads configuration and validates it
+func loadConf(path, envDir string) (conf.Conf, error) {
+	c, err := readConf(path, envDir)
+	if err != nil {
+		return c, err
+	}
+	if err := c.Validate(); err != nil {
+		return c, fmt.Errorf("configuration validation failed: %v", err)
+	}
+	return c, nil
+}
+
+// readConf reads configuration file (if path is not empty) and applies environment variables,
+// relative file references of the variables are resolved relatively to envDir
+func readConf(path, envDir string) (conf.Conf, error) {
+	c := conf.Default()
+	var err error
+	if len(path) != 0 {
+		c, err = conf.ReadConf(path)
+		if err != nil {
+			return c, fmt.Errorf("failed to read config: %v", err)
+		}
+	}
+	// Environment variables override configuration file, e.g. 'APP_DATABASE_PASSWORD'
+	if err := c.ApplyEnv(os.LookupEnv, envDir); err != nil {
+		return c, fmt.Errorf("failed to apply environment variables: %v", err)
+	}
+	// Deprecated: use 'APP_DATABASE_TYPE=in-memory' instead
+	if strings.ToLower(os.Getenv("DB_TYPE_INMEMORY")) == "true" {
+		c.Database.Type = storage.DatabaseTypeInMemory
+	}
+	return c, nil
+}
+
+// configReloader re-reads configuration and applies the settings which
+// can be changed without restart
+type configReloader struct {
+	// Absolute path to configuration file, empty if configuration is read from environment only
+	path string
+	// Directory the server was started in, working directory is changed to the configuration one
+	envDir  string
+	current conf.Conf
+	modTime time.Time
+
+	ssm       *auth.SSM
+	tokenAuth *auth.TokenAuth
+	api       *handler.API
+	certs     *certs.Reloader
+	servers   *servers
+	logFilter *logging.Filter
+
+	mutex sync.Mutex
+}
+
+// reload reads configuration, if it is invalid the current one is kept
+func (cr *configReloader) reload() {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	if len(cr.path) != 0 {
+		if info, err := os.Stat(cr.path); err == nil {
+			cr.modTime = info.ModTime()
+		}
+	}
+	c, err := loadConf(cr.path, cr.envDir)
+	if err != nil {
+		log.Printf("[Config] Error: reload failed, current configuration is kept: %v", err)
+		return
+	}
+
+	live, restart := conf.Diff(cr.current, c)
+	a := c.Authorization
+	if cr.ssm != nil {
+		cr.ssm.SetSessionDuration(a.SessionDuration, a.MaxSessionLifetime)
+	}
+	if cr.tokenAuth != nil {
+		cr.tokenAuth.SetTokenDurations(a.AccessTokenDuration, a.RefreshTokenDuration)
+	}
+	cr.api.SetBodyLimits(c.Server.GetMaxBodyBytes(), c.Server.RouteMaxBodyBytes)
+	cr.api.SetRateLimits(a.RateLimits.Config())
+	if cr.servers != nil {
+		cr.servers.SetSettings(c.Server)
+	}
+	if err := cr.logFilter.SetLevel(c.LogLevel); err != nil {
+		log.Printf("[Config] Error: failed to set log level: %v", err)
+	}
+	if len(live) != 0 {
+		log.Printf("[Config] Applied changes: %s", strings.Join(live, ", "))
+	}
+	if len(restart) != 0 {
+		log.Printf("[Config] Warning: changes require restart and are not applied: %s", strings.Join(restart, ", "))
+	}
+	if len(live) == 0 && len(restart) == 0 {
+		log.Printf("[Config] Configuration is reloaded, no changes")
+	}
+	cr.current = c
+
+	// Certificate files may be replaced without configuration changes
+	if cr.certs != nil {
+		if err := cr.certs.Reload(); err != nil {
+			log.Printf("[Config] Error: failed to reload TLS certificate: %v", err)
+		}
+	}
+}
+
+// watch reloads configuration when the file is modified, returned function stops watching
+func (cr *configReloader) watch(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if cr.changed() {
+					cr.reload()
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// changed reports whether configuration file was modified since the last reload
+func (cr *configReloader) changed() bool {
+	info, err := os.Stat(cr.path)
+	if err != nil {
+		log.Printf("[Config] Error: failed to stat configuration: %v", err)
+		return false
+	}
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	return !info.ModTime().Equal(cr.modTime)
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/types"
+)
+
+const (
+	// APIKeyHeader is a header which carries an API key
+	APIKeyHeader = "X-API-Key"
+	// apiKeyPrefix marks API keys so they can be recognized in 'Authorization' header
+	apiKeyPrefix = "grt_"
+	// apiKeyIDLength is a length of a public part of an API key
+	apiKeyIDLength = 8
+	// apiKeySecretLength is a length of a secret part of an API key
+	apiKeySecretLength = 32
+)
+
+const (
+	// ScopeDataRead allows reading data
+	ScopeDataRead = "data:read"
+	// ScopeDataWrite allows storing data
+	ScopeDataWrite = "data:write"
+	// ScopeAccount allows account management, it can't be granted to an API key
+	ScopeAccount = "account"
+)
+
+// APIKeyScopes lists scopes which can be granted to an API key
+var APIKeyScopes = []string{ScopeDataRead, ScopeDataWrite}
+
+// APIKeyStore represents a storage of API keys
+type APIKeyStore interface {
+	GetAPIKeyByPrefix(prefix string) (types.APIKey, error)
+	UpdateAPIKeyLastUsed(keyID int, t time.Time) error
+}
+
+// GenerateAPIKey generates an API key in 'grt_<prefix>_<secret>' format
+// and returns the key together with its prefix
+func GenerateAPIKey() (key, prefix string, err error) {
+	prefix, err = GenerateRandomString(apiKeyIDLength)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate API key prefix: %v", err)
+	}
+	secret, err := GenerateRandomString(apiKeySecretLength)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate API key secret: %v", err)
+	}
+	return apiKeyPrefix + prefix + "_" + secret, prefix, nil
+}
+
+// parseAPIKey returns prefix of an API key
+func parseAPIKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if len(parts) != 2 || len(parts[0]) != apiKeyIDLength || len(parts[1]) != apiKeySecretLength {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// APIKeyFromRequest returns API key from 'X-API-Key' header
+// or from 'Authorization: Bearer' header
+func APIKeyFromRequest(r *http.Request) (string, bool) {
+	if key := r.Header.Get(APIKeyHeader); len(key) != 0 {
+		return key, true
+	}
+	if key, exist := bearerToken(r); exist && strings.HasPrefix(key, apiKeyPrefix) {
+		return key, true
+	}
+	return "", false
+}
+
+// DefineAPIKeyAuth wraps an Auth, so requests carrying an API key are
+// authenticated with the key and the rest is handled by the wrapped Auth
+func DefineAPIKeyAuth(next Auth, store APIKeyStore) *APIKeyAuth {
+	return &APIKeyAuth{
+		Auth:  next,
+		store: store,
+	}
+}
+
+// APIKeyAuth authenticates requests with API keys as an alternative
+// to the wrapped Auth (e.g. SSM)
+type APIKeyAuth struct {
+	Auth
+	store APIKeyStore
+}
+
+// CheckSession authenticates request with an API key if it is present,
+// otherwise check is delegated to the wrapped Auth
+func (aka *APIKeyAuth) CheckSession(w http.ResponseWriter, r *http.Request) (Session, error) {
+	if r == nil {
+		return Session{}, fmt.Errorf("request is nil")
+	}
+	key, exist := APIKeyFromRequest(r)
+	if !exist {
+		return aka.Auth.CheckSession(w, r)
+	}
+
+	prefix, ok := parseAPIKey(key)
+	if !ok {
+		return Session{}, fmt.Errorf("malformed API key")
+	}
+	k, err := aka.store.GetAPIKeyByPrefix(prefix)
+	if err != nil {
+		return Session{}, fmt.Errorf("API key with '%s' prefix is invalid: %v", prefix, err)
+	}
+	if subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(HashToken(key))) != 1 {
+		return Session{}, fmt.Errorf("API key with '%s' prefix is invalid", prefix)
+	}
+	if k.Revoked {
+		return Session{}, fmt.Errorf("API key with '%s' prefix is revoked", prefix)
+	}
+	now := time.Now()
+	if k.ExpiresAt != nil && now.After(*k.ExpiresAt) {
+		return Session{}, fmt.Errorf("API key with '%s' prefix has expired", prefix)
+	}
+	if err := aka.store.UpdateAPIKeyLastUsed(k.ID, now); err != nil {
+		log.Printf("[APIKeyAuth] Error: %v", err)
+	}
+
+	return Session{
+		ID:           apiKeyPrefix + prefix,
+		UserID:       k.UserID,
+		Username:     k.Username,
+		LastActivity: now,
+		APIKeyID:     k.ID,
+		Scopes:       k.Scopes,
+	}, nil
+}
@@ -2,10 +2,15 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/sha512"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/sergeikus/go-rest-template/pkg/types"
 	"golang.org/x/crypto/pbkdf2"
 )
 
@@ -14,12 +19,81 @@ import (
 //     * Client-Side Session Management (JWT - JSON Web Token)
 //     * Server-Side Session Management (Session ID in cookie)
 type Auth interface {
-	CreateSession(w http.ResponseWriter) (string, error)
-	CheckSession(w http.ResponseWriter, r *http.Request) error
+	CreateSession(w http.ResponseWriter, r *http.Request, user types.User) (string, error)
+	CheckSession(w http.ResponseWriter, r *http.Request) (Session, error)
+	// Two-step log in: pending session is created after password is verified
+	// and is promoted to a regular session after the second factor
+	CreatePendingSession(w http.ResponseWriter, r *http.Request, user types.User) (string, error)
+	CheckPendingSession(w http.ResponseWriter, r *http.Request) (Session, error)
+	CompletePendingSession(w http.ResponseWriter, r *http.Request) (Session, error)
+	// Session management
+	ListSessions(userID int) []Session
+	RevokeSession(userID int, handle string) error
+	RevokeUserSessions(userID int) int
+	// Revokes every session of the user except the current one, e.g. after password change
+	RevokeOtherSessions(userID int, current Session) int
 	Logout(r *http.Request) error
 	PBKDF2HashPassword(password string, salt string) string
 }
 
+// Session describes an authenticated client
+type Session struct {
+	ID           string    `json:"id"`
+	UserID       int       `json:"userId"`
+	Username     string    `json:"username"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastActivity time.Time `json:"lastActivity"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"userAgent"`
+	// Set when the second authentication factor is not provided yet
+	Pending bool `json:"pending"`
+	// Set when request is authenticated with an API key
+	APIKeyID int `json:"apiKeyId,omitempty"`
+	// Set when request is authenticated with a client certificate
+	ClientCert bool     `json:"clientCert,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
+	// Refresh token family the access token was issued for
+	FamilyID string `json:"-"`
+}
+
+// Handle returns a public session identifier which can be shown to
+// the client without disclosing the session ID itself
+func (s Session) Handle() string {
+	return HashToken(s.ID)[:16]
+}
+
+// clientIP returns IP address of the request sender
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// bearerToken returns token from 'Authorization: Bearer' header
+func bearerToken(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(authorization[len("Bearer "):]), true
+	}
+	return "", false
+}
+
+// HasScope reports whether session is allowed to perform actions of the scope,
+// sessions which are not API key or client certificate based are allowed everything
+func (s Session) HasScope(scope string) bool {
+	if s.APIKeyID == 0 && !s.ClientCert {
+		return true
+	}
+	for _, sc := range s.Scopes {
+		if sc == scope {
+			return true
+		}
+	}
+	return false
+}
+
 func pbkdf2HashPassword(password string, salt string, iterations int, keyLenght int) string {
 	b := pbkdf2.Key([]byte(password), []byte(salt), iterations, keyLenght, sha512.New)
 	return fmt.Sprintf("%x", b)
@@ -43,6 +117,12 @@ func GenerateRandomString(lenght int) (string, error) {
 	return string(bs), nil
 }
 
+// HashToken returns a SHA-256 hex digest of a secret token,
+// tokens are stored only in hashed form
+func HashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
 func randomBytes(n int) ([]byte, error) {
 	b := make([]byte, n)
 	_, err := rand.Read(b)
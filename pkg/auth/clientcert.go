@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+)
+
+const (
+	// ClientCertMappingSubject maps certificate subject common name to username
+	ClientCertMappingSubject = "subject"
+	// ClientCertMappingEmail maps certificate email SAN to user email
+	ClientCertMappingEmail = "email"
+	// ClientCertMappingDNS maps certificate DNS SAN to username
+	ClientCertMappingDNS = "dns"
+	// clientCertSessionPrefix marks sessions of certificate authenticated requests
+	clientCertSessionPrefix = "cert_"
+)
+
+// ClientCertMappings lists supported ways to map a client certificate to a user
+var ClientCertMappings = []string{ClientCertMappingSubject, ClientCertMappingEmail, ClientCertMappingDNS}
+
+// ClientCertScopes lists scopes which can be granted to certificate sessions,
+// account management requires a log in
+var ClientCertScopes = []string{ScopeDataRead, ScopeDataWrite}
+
+// ClientCertStore represents a storage of users certificates are mapped to
+type ClientCertStore interface {
+	GetUserByUsername(username string) (types.User, error)
+	GetUserByEmail(email string) (types.User, error)
+}
+
+// DefineClientCertAuth wraps an Auth, so requests with a verified client
+// certificate are authenticated with the certificate and the rest is
+// handled by the wrapped Auth. Certificate sessions are allowed only the
+// scopes, users with unverified email are rejected if requireVerified is set.
+func DefineClientCertAuth(next Auth, store ClientCertStore, mapping string, scopes []string, requireVerified bool) *ClientCertAuth {
+	return &ClientCertAuth{
+		Auth:            next,
+		store:           store,
+		mapping:         mapping,
+		scopes:          append([]string(nil), scopes...),
+		requireVerified: requireVerified,
+	}
+}
+
+// ClientCertAuth authenticates requests with TLS client certificates
+// as an alternative to the wrapped Auth (e.g. SSM)
+type ClientCertAuth struct {
+	Auth
+	store           ClientCertStore
+	mapping         string
+	scopes          []string
+	requireVerified bool
+}
+
+// CheckSession authenticates request with a client certificate if it was
+// verified during TLS handshake, otherwise check is delegated to the wrapped Auth
+func (cca *ClientCertAuth) CheckSession(w http.ResponseWriter, r *http.Request) (Session, error) {
+	if r == nil {
+		return Session{}, fmt.Errorf("request is nil")
+	}
+	// Only verified chains are trusted, peer certificates alone are not checked against CA
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return cca.Auth.CheckSession(w, r)
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	user, err := cca.user(cert)
+	if err != nil {
+		return Session{}, fmt.Errorf("client certificate '%s' is not mapped to a user: %v", cert.Subject, err)
+	}
+	if user.IsDisabled {
+		return Session{}, fmt.Errorf("user with '%s' username is disabled", user.Username)
+	}
+	if cca.requireVerified && !user.IsVerified {
+		return Session{}, fmt.Errorf("email address of user with '%s' username is not verified", user.Username)
+	}
+
+	now := time.Now()
+	return Session{
+		ID:           clientCertSessionPrefix + HashToken(string(cert.Raw))[:16],
+		UserID:       user.ID,
+		Username:     user.Username,
+		CreatedAt:    now,
+		LastActivity: now,
+		IP:           clientIP(r),
+		UserAgent:    r.UserAgent(),
+		ClientCert:   true,
+		Scopes:       append([]string(nil), cca.scopes...),
+	}, nil
+}
+
+// user returns user the certificate is mapped to, SANs are tried in order
+func (cca *ClientCertAuth) user(cert *x509.Certificate) (types.User, error) {
+	var names []string
+	lookup := cca.store.GetUserByUsername
+	switch cca.mapping {
+	case ClientCertMappingSubject:
+		names = []string{cert.Subject.CommonName}
+	case ClientCertMappingEmail:
+		names = cert.EmailAddresses
+		lookup = cca.store.GetUserByEmail
+	case ClientCertMappingDNS:
+		names = cert.DNSNames
+	default:
+		return types.User{}, fmt.Errorf("unsupported mapping: '%s'", cca.mapping)
+	}
+
+	for _, name := range names {
+		if len(name) == 0 {
+			continue
+		}
+		user, err := lookup(name)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			return user, err
+		}
+	}
+	return types.User{}, storage.ErrUserNotFound
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func testCertRequest(cert *x509.Certificate, verified bool) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if verified {
+		req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return req
+}
+
+func Test_ClientCertAuth(t *testing.T) {
+	db := &storage.InMemoryStorage{}
+	require.NoError(t, db.Connect())
+	id, err := db.RegisterUser(types.User{Username: "service", Email: "service@example.com"})
+	require.NoError(t, err, "failed to register user: %v", err)
+	_, err = db.RegisterUser(types.User{Username: "disabled", Email: "disabled@example.com", IsDisabled: true})
+	require.NoError(t, err, "failed to register user: %v", err)
+	_, err = db.RegisterUser(types.User{Username: "admin", Email: "admin@example.com", IsAdmin: true, IsVerified: true})
+	require.NoError(t, err, "failed to register user: %v", err)
+
+	ssm := DefineSSM(60, 0, 1, 16, DefaultCookieConfig())
+	tt := []struct {
+		name            string
+		mapping         string
+		cert            *x509.Certificate
+		verified        bool
+		requireVerified bool
+		fail            bool
+	}{
+		{name: "Subject", mapping: ClientCertMappingSubject, cert: &x509.Certificate{Subject: pkix.Name{CommonName: "service"}}, verified: true},
+		{name: "Email SAN", mapping: ClientCertMappingEmail, cert: &x509.Certificate{EmailAddresses: []string{"other@example.com", "service@example.com"}}, verified: true},
+		{name: "DNS SAN", mapping: ClientCertMappingDNS, cert: &x509.Certificate{DNSNames: []string{"service"}}, verified: true},
+		{name: "Unknown user", mapping: ClientCertMappingSubject, cert: &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}}, verified: true, fail: true},
+		{name: "Disabled user", mapping: ClientCertMappingSubject, cert: &x509.Certificate{Subject: pkix.Name{CommonName: "disabled"}}, verified: true, fail: true},
+		{name: "Unverified email", mapping: ClientCertMappingSubject, cert: &x509.Certificate{Subject: pkix.Name{CommonName: "service"}}, verified: true, requireVerified: true, fail: true},
+		{name: "No SAN", mapping: ClientCertMappingEmail, cert: &x509.Certificate{Subject: pkix.Name{CommonName: "service"}}, verified: true, fail: true},
+		// Unverified certificate is ignored and the wrapped Auth finds no session
+		{name: "Unverified certificate", mapping: ClientCertMappingSubject, cert: &x509.Certificate{Subject: pkix.Name{CommonName: "service"}}, verified: false, fail: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cca := DefineClientCertAuth(ssm, db, tc.mapping, []string{ScopeDataRead}, tc.requireVerified)
+			session, err := cca.CheckSession(httptest.NewRecorder(), testCertRequest(tc.cert, tc.verified))
+			if tc.fail {
+				require.Error(t, err, "expected to see an error, but got nil")
+				return
+			}
+			require.NoError(t, err, "expected to get no error, but got: %v", err)
+			require.Equal(t, id, session.UserID)
+			require.Equal(t, "service", session.Username)
+			require.True(t, session.HasScope(ScopeDataRead))
+			require.False(t, session.HasScope(ScopeDataWrite), "certificate session must be limited by configured scopes")
+			require.False(t, session.HasScope(ScopeAccount), "certificate session must not manage the account")
+		})
+	}
+
+	// Admin authenticated with a certificate can't perform admin actions, which require account scope
+	cca := DefineClientCertAuth(ssm, db, ClientCertMappingSubject, ClientCertScopes, true)
+	session, err := cca.CheckSession(httptest.NewRecorder(), testCertRequest(&x509.Certificate{Subject: pkix.Name{CommonName: "admin"}}, true))
+	require.NoError(t, err)
+	require.False(t, session.HasScope(ScopeAccount))
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// CSRFCookieName defines cookie which carries CSRF token
+	CSRFCookieName = "CSRF-TOKEN"
+	// CSRFHeader is a request header which must repeat CSRF cookie value
+	CSRFHeader = "X-CSRF-Token"
+	// csrfTokenLength is a length of a CSRF token
+	csrfTokenLength = 32
+)
+
+// GenerateCSRFToken generates a new CSRF token
+func GenerateCSRFToken() (string, error) {
+	token, err := GenerateRandomString(csrfTokenLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate CSRF token: %v", err)
+	}
+	return token, nil
+}
+
+// SetCSRFCookie sets CSRF token cookie with domain, path and Secure flag of
+// the session cookie, it is readable by scripts so the client can repeat it
+// in 'X-CSRF-Token' header
+func SetCSRFCookie(w http.ResponseWriter, token string, session CookieConfig) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		Domain:   session.Domain,
+		Path:     session.Path,
+		Secure:   session.Secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// CSRFExempt reports whether request does not need CSRF protection: safe
+// methods do not change state and requests carrying bearer token or API key
+// are not authenticated by browser cookies (SSM refuses such requests)
+func CSRFExempt(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return hasHeaderCredentials(r)
+}
+
+// hasHeaderCredentials reports whether request carries a bearer token or an API key
+func hasHeaderCredentials(r *http.Request) bool {
+	if _, exist := bearerToken(r); exist {
+		return true
+	}
+	return len(r.Header.Get(APIKeyHeader)) != 0
+}
+
+// CheckCSRF performs double-submit check: 'X-CSRF-Token' header
+// must be equal to CSRF cookie value
+func CheckCSRF(r *http.Request) error {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || len(cookie.Value) == 0 {
+		return fmt.Errorf("CSRF cookie is missing")
+	}
+	header := r.Header.Get(CSRFHeader)
+	if len(header) == 0 {
+		return fmt.Errorf("'%s' header is missing", CSRFHeader)
+	}
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+		return fmt.Errorf("'%s' header does not match CSRF cookie", CSRFHeader)
+	}
+	return nil
+}
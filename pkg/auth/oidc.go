@@ -0,0 +1,495 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// OIDCStateCookieName defines cookie which binds OpenID Connect log in to the browser
+	OIDCStateCookieName = "OIDC-STATE"
+	// OIDCLogInDuration is a time given to the user to authenticate at the provider
+	OIDCLogInDuration = 10 * time.Minute
+	// oidcMaxPendingLogIns limits number of log ins waiting for the provider callback
+	oidcMaxPendingLogIns = 10000
+	// jwksCacheDuration is a time provider signing keys are cached for
+	jwksCacheDuration = time.Hour
+	// jwksMinRefreshInterval limits key refetching when token is signed with an unknown key
+	jwksMinRefreshInterval = time.Minute
+	// oidcClockSkew is a tolerated clock difference with the provider
+	oidcClockSkew = time.Minute
+	// oidcMaxResponseSize limits size of provider responses
+	oidcMaxResponseSize = 1 << 20
+)
+
+// OIDCClaims are ID token claims used for user identification
+type OIDCClaims struct {
+	Issuer            string       `json:"iss"`
+	Subject           string       `json:"sub"`
+	Audience          OIDCAudience `json:"aud"`
+	AuthorizedParty   string       `json:"azp"`
+	ExpiresAt         int64        `json:"exp"`
+	IssuedAt          int64        `json:"iat"`
+	Nonce             string       `json:"nonce"`
+	Email             string       `json:"email"`
+	EmailVerified     bool         `json:"email_verified"`
+	Name              string       `json:"name"`
+	PreferredUsername string       `json:"preferred_username"`
+}
+
+// OIDCAudience is an 'aud' claim which is either a string or a list of strings
+type OIDCAudience []string
+
+// UnmarshalJSON accepts both forms of 'aud' claim
+func (a *OIDCAudience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = OIDCAudience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("'aud' claim must be a string or a list of strings")
+	}
+	*a = list
+	return nil
+}
+
+// oidcDiscovery is a subset of provider discovery document
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLogIn is a log in waiting for the provider callback
+type oidcLogIn struct {
+	nonce        string
+	codeVerifier string
+	expiresAt    time.Time
+}
+
+// DefineOIDCProvider performs OpenID Connect relying party struct declaration,
+// 'openid' scope is always requested
+func DefineOIDCProvider(issuer, clientID, clientSecret, redirectURL string, scopes []string) *OIDCProvider {
+	requested := []string{"openid"}
+	for _, s := range scopes {
+		if s != "openid" {
+			requested = append(requested, s)
+		}
+	}
+	return &OIDCProvider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       requested,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		pending:      make(map[string]oidcLogIn),
+		now:          time.Now,
+	}
+}
+
+// OIDCProvider performs OpenID Connect authorization code flow with PKCE
+// against an external identity provider. Discovery document and signing
+// keys are fetched lazily and cached.
+type OIDCProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	client       *http.Client
+	// Guards discovery document and signing keys
+	mutex         sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+	// Guards log ins waiting for the provider callback
+	pendingMutex sync.Mutex
+	pending      map[string]oidcLogIn
+	// Returns current time, replaced in tests
+	now func() time.Time
+}
+
+// Issuer returns provider issuer identifier
+func (p *OIDCProvider) Issuer() string {
+	return p.issuer
+}
+
+// Begin starts a log in and returns provider authorization URL together
+// with the state, which must be bound to the browser (e.g. with a cookie)
+func (p *OIDCProvider) Begin() (authURL, state string, err error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", "", err
+	}
+	state, err = GenerateRandomString(32)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate state: %v", err)
+	}
+	nonce, err := GenerateRandomString(32)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	// RFC 7636 verifier must be 43-128 characters long
+	codeVerifier, err := GenerateRandomString(64)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate PKCE code verifier: %v", err)
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse authorization endpoint: %v", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.clientID)
+	q.Set("redirect_uri", p.redirectURL)
+	q.Set("scope", strings.Join(p.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", PKCEChallenge(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	now := p.now()
+	p.pendingMutex.Lock()
+	defer p.pendingMutex.Unlock()
+	for s, l := range p.pending {
+		if now.After(l.expiresAt) {
+			delete(p.pending, s)
+		}
+	}
+	if len(p.pending) >= oidcMaxPendingLogIns {
+		return "", "", fmt.Errorf("too many pending log ins")
+	}
+	p.pending[state] = oidcLogIn{nonce: nonce, codeVerifier: codeVerifier, expiresAt: now.Add(OIDCLogInDuration)}
+	return u.String(), state, nil
+}
+
+// Finish completes a log in started with the state: authorization code is
+// exchanged for an ID token and the token is validated, state can be used only once
+func (p *OIDCProvider) Finish(state, code string) (OIDCClaims, error) {
+	p.pendingMutex.Lock()
+	login, exist := p.pending[state]
+	delete(p.pending, state)
+	p.pendingMutex.Unlock()
+	if !exist || p.now().After(login.expiresAt) {
+		return OIDCClaims{}, fmt.Errorf("log in state is invalid or expired")
+	}
+
+	rawIDToken, err := p.exchange(code, login.codeVerifier)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	return p.VerifyIDToken(rawIDToken, login.nonce)
+}
+
+// PKCEChallenge returns S256 code challenge of a PKCE code verifier
+func PKCEChallenge(codeVerifier string) string {
+	h := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// exchange exchanges authorization code for an ID token
+func (p *OIDCProvider) exchange(code, codeVerifier string) (string, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("client_id", p.clientID)
+	form.Set("code_verifier", codeVerifier)
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(p.clientSecret) != 0 {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	var tr struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.do(req, &tr)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %v", err)
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("token request failed with '%d' status: %s %s", status, tr.Error, tr.ErrorDescription)
+	}
+	if len(tr.IDToken) == 0 {
+		return "", fmt.Errorf("token response does not contain ID token")
+	}
+	return tr.IDToken, nil
+}
+
+// VerifyIDToken verifies ID token signature and claims
+func (p *OIDCProvider) VerifyIDToken(rawIDToken, nonce string) (OIDCClaims, error) {
+	var claims OIDCClaims
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("ID token is malformed")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return claims, fmt.Errorf("failed to decode ID token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, fmt.Errorf("failed to decode ID token signature: %v", err)
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return claims, err
+	}
+
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return claims, fmt.Errorf("failed to decode ID token claims: %v", err)
+	}
+	if claims.Issuer != p.issuer {
+		return claims, fmt.Errorf("ID token issuer '%s' is not expected", claims.Issuer)
+	}
+	if len(claims.Subject) == 0 {
+		return claims, fmt.Errorf("ID token subject is empty")
+	}
+	audience := false
+	for _, aud := range claims.Audience {
+		if aud == p.clientID {
+			audience = true
+		}
+	}
+	if !audience {
+		return claims, fmt.Errorf("ID token is not issued for this client")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.clientID {
+		return claims, fmt.Errorf("ID token authorized party '%s' is not expected", claims.AuthorizedParty)
+	}
+	now := p.now()
+	if !now.Before(time.Unix(claims.ExpiresAt, 0).Add(oidcClockSkew)) {
+		return claims, fmt.Errorf("ID token has expired")
+	}
+	if time.Unix(claims.IssuedAt, 0).After(now.Add(oidcClockSkew)) {
+		return claims, fmt.Errorf("ID token is issued in the future")
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return claims, fmt.Errorf("ID token nonce does not match")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifyJWTSignature verifies RS256 or ES256 signature
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	h := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("signing key is not an RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], signature); err != nil {
+			return fmt.Errorf("ID token signature is invalid")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("signing key is not an EC key")
+		}
+		if len(signature) != 64 {
+			return fmt.Errorf("ID token signature is invalid")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, h[:], r, s) {
+			return fmt.Errorf("ID token signature is invalid")
+		}
+	default:
+		return fmt.Errorf("unsupported ID token signing algorithm: '%s'", alg)
+	}
+	return nil
+}
+
+// getDiscovery returns provider discovery document, it is fetched once
+func (p *OIDCProvider) getDiscovery() (oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.discovery != nil {
+		return *p.discovery, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return oidcDiscovery{}, fmt.Errorf("failed to create discovery request: %v", err)
+	}
+	var d oidcDiscovery
+	status, err := p.do(req, &d)
+	if err != nil {
+		return d, fmt.Errorf("failed to get discovery document: %v", err)
+	}
+	if status != http.StatusOK {
+		return d, fmt.Errorf("failed to get discovery document: '%d' status", status)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.issuer {
+		return d, fmt.Errorf("discovery document issuer '%s' does not match '%s'", d.Issuer, p.issuer)
+	}
+	if len(d.AuthorizationEndpoint) == 0 || len(d.TokenEndpoint) == 0 || len(d.JWKSURI) == 0 {
+		return d, fmt.Errorf("discovery document must contain authorization, token and JWKS endpoints")
+	}
+	p.discovery = &d
+	return d, nil
+}
+
+// key returns provider signing key, keys are refetched when cache expires
+// or when the token is signed with an unknown key (e.g. after key rotation)
+func (p *OIDCProvider) key(kid string) (crypto.PublicKey, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := p.now()
+	if p.keys == nil || now.Sub(p.keysFetchedAt) > jwksCacheDuration {
+		if err := p.fetchKeys(d.JWKSURI); err != nil {
+			return nil, err
+		}
+	}
+	key, exist := p.lookupKey(kid)
+	if !exist && now.Sub(p.keysFetchedAt) >= jwksMinRefreshInterval {
+		if err := p.fetchKeys(d.JWKSURI); err != nil {
+			return nil, err
+		}
+		key, exist = p.lookupKey(kid)
+	}
+	if !exist {
+		return nil, fmt.Errorf("signing key '%s' is unknown", kid)
+	}
+	return key, nil
+}
+
+// lookupKey returns key by ID, if ID is empty the only key is returned
+func (p *OIDCProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if len(kid) == 0 && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	key, exist := p.keys[kid]
+	return key, exist
+}
+
+// fetchKeys fetches provider signing keys, must be called with mutex locked
+func (p *OIDCProvider) fetchKeys(jwksURI string) error {
+	req, err := http.NewRequest(http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create JWKS request: %v", err)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	status, err := p.do(req, &set)
+	if err != nil {
+		return fmt.Errorf("failed to get signing keys: %v", err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("failed to get signing keys: '%d' status", status)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if len(k.Use) != 0 && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				return fmt.Errorf("signing key '%s' is malformed", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				return fmt.Errorf("signing key '%s' is malformed", k.Kid)
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				return fmt.Errorf("signing key '%s' is malformed", k.Kid)
+			}
+			keys[k.Kid] = pub
+		}
+	}
+	p.keys = keys
+	p.keysFetchedAt = p.now()
+	return nil
+}
+
+// do performs request and decodes JSON response
+func (p *OIDCProvider) do(req *http.Request, v interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseSize))
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to read response: %v", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return resp.StatusCode, nil
+}
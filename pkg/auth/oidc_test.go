@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth/oidctest"
+	"github.com/stretchr/testify/require"
+)
+
+func testOIDC(t *testing.T) (*OIDCProvider, *oidctest.Provider) {
+	mock := oidctest.NewProvider("client", "secret")
+	t.Cleanup(mock.Close)
+	return DefineOIDCProvider(mock.Issuer(), "client", "secret", "https://localhost/api/oidc/callback", []string{"email"}), mock
+}
+
+// testOIDCCallback authenticates at the provider and returns callback query
+func testOIDCCallback(t *testing.T, p *OIDCProvider, mock *oidctest.Provider) (url.Values, string) {
+	authURL, state, err := p.Begin()
+	require.NoError(t, err, "failed to begin log in: %v", err)
+	callback, err := mock.Authorize(authURL)
+	require.NoError(t, err, "failed to authorize: %v", err)
+	u, err := url.Parse(callback)
+	require.NoError(t, err)
+	return u.Query(), state
+}
+
+func Test_OIDC_AuthorizationCodeFlow(t *testing.T) {
+	p, mock := testOIDC(t)
+	mock.Claims = map[string]interface{}{"sub": "user-1", "email": "user@example.com", "email_verified": true}
+
+	authURL, _, err := p.Begin()
+	require.NoError(t, err)
+	q, err := url.Parse(authURL)
+	require.NoError(t, err)
+	require.Equal(t, "openid email", q.Query().Get("scope"))
+	require.Equal(t, "S256", q.Query().Get("code_challenge_method"))
+	require.NotEmpty(t, q.Query().Get("nonce"))
+
+	query, state := testOIDCCallback(t, p, mock)
+	require.Equal(t, state, query.Get("state"))
+	claims, err := p.Finish(state, query.Get("code"))
+	require.NoError(t, err, "failed to finish log in: %v", err)
+	require.Equal(t, "user-1", claims.Subject)
+	require.Equal(t, "user@example.com", claims.Email)
+	require.True(t, claims.EmailVerified)
+
+	_, err = p.Finish(state, query.Get("code"))
+	require.Error(t, err, "state must be single-use")
+	_, err = p.Finish("unknown", query.Get("code"))
+	require.Error(t, err, "unknown state must be rejected")
+}
+
+func Test_OIDC_ExpiredLogIn(t *testing.T) {
+	p, mock := testOIDC(t)
+	clock := &testClock{t: time.Now()}
+	p.now = clock.now
+	query, state := testOIDCCallback(t, p, mock)
+
+	clock.t = clock.t.Add(OIDCLogInDuration + time.Second)
+	_, err := p.Finish(state, query.Get("code"))
+	require.Error(t, err, "expired log in must be rejected")
+}
+
+func Test_OIDC_PKCE(t *testing.T) {
+	p, mock := testOIDC(t)
+	query, state := testOIDCCallback(t, p, mock)
+
+	// Tamper code verifier as if the code was intercepted
+	p.pendingMutex.Lock()
+	login := p.pending[state]
+	login.codeVerifier = strings.Repeat("a", 64)
+	p.pending[state] = login
+	p.pendingMutex.Unlock()
+
+	_, err := p.Finish(state, query.Get("code"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "PKCE verification failed")
+}
+
+func Test_OIDC_VerifyIDToken(t *testing.T) {
+	p, mock := testOIDC(t)
+	now := time.Now()
+
+	tt := []struct {
+		name     string
+		claims   map[string]interface{}
+		nonce    string
+		expected string
+	}{
+		{name: "Valid token", claims: map[string]interface{}{"sub": "user", "nonce": "nonce"}, nonce: "nonce"},
+		{name: "Audience list", claims: map[string]interface{}{"sub": "user", "nonce": "nonce", "aud": []string{"client", "other"}, "azp": "client"}, nonce: "nonce"},
+		{name: "Wrong issuer", claims: map[string]interface{}{"sub": "user", "nonce": "nonce", "iss": "https://evil.example.com"}, nonce: "nonce", expected: "issuer"},
+		{name: "Wrong audience", claims: map[string]interface{}{"sub": "user", "nonce": "nonce", "aud": "other"}, nonce: "nonce", expected: "not issued for this client"},
+		{name: "Wrong authorized party", claims: map[string]interface{}{"sub": "user", "nonce": "nonce", "aud": []string{"client", "other"}, "azp": "other"}, nonce: "nonce", expected: "authorized party"},
+		{name: "Expired token", claims: map[string]interface{}{"sub": "user", "nonce": "nonce", "exp": now.Add(-2 * time.Minute).Unix()}, nonce: "nonce", expected: "has expired"},
+		{name: "Issued in the future", claims: map[string]interface{}{"sub": "user", "nonce": "nonce", "iat": now.Add(time.Hour).Unix()}, nonce: "nonce", expected: "in the future"},
+		{name: "Wrong nonce", claims: map[string]interface{}{"sub": "user", "nonce": "other"}, nonce: "nonce", expected: "nonce does not match"},
+		{name: "No subject", claims: map[string]interface{}{"nonce": "nonce"}, nonce: "nonce", expected: "subject is empty"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := p.VerifyIDToken(mock.SignIDToken(tc.claims), tc.nonce)
+			if len(tc.expected) == 0 {
+				require.NoError(t, err, "expected to get no error, but got: %v", err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expected)
+			}
+		})
+	}
+
+	token := mock.SignIDToken(map[string]interface{}{"sub": "user", "nonce": "nonce"})
+	parts := strings.Split(token, ".")
+	_, err := p.VerifyIDToken(parts[0]+"."+parts[1]+"."+strings.Repeat("A", len(parts[2])), "nonce")
+	require.Error(t, err, "token with invalid signature must be rejected")
+	_, err = p.VerifyIDToken("eyJhbGciOiJub25lIn0."+parts[1]+".", "nonce")
+	require.Error(t, err, "unsigned token must be rejected")
+}
+
+func Test_OIDC_KeyCaching(t *testing.T) {
+	p, mock := testOIDC(t)
+	clock := &testClock{t: time.Now()}
+	p.now = clock.now
+
+	claims := map[string]interface{}{"sub": "user", "nonce": "nonce"}
+	for i := 0; i < 3; i++ {
+		_, err := p.VerifyIDToken(mock.SignIDToken(claims), "nonce")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, mock.JWKSRequests(), "signing keys must be cached")
+
+	// Token signed with a new key right after fetch does not cause refetch
+	mock.RotateKey()
+	_, err := p.VerifyIDToken(mock.SignIDToken(claims), "nonce")
+	require.Error(t, err)
+	require.Equal(t, 1, mock.JWKSRequests(), "keys must not be refetched too often")
+
+	// Unknown key causes refetch once refresh interval is over
+	clock.t = clock.t.Add(jwksMinRefreshInterval)
+	_, err = p.VerifyIDToken(mock.SignIDToken(claims), "nonce")
+	require.NoError(t, err, "rotated key must be fetched: %v", err)
+	require.Equal(t, 2, mock.JWKSRequests())
+}
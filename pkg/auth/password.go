@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy describes requirements that user password must satisfy
+type PasswordPolicy struct {
+	// Minimal password length (in characters)
+	MinLength int
+	// Maximal password length (in characters), 0 means no limit
+	MaxLength int
+	// Require at least one uppercase letter
+	RequireUppercase bool
+	// Require at least one lowercase letter
+	RequireLowercase bool
+	// Require at least one digit
+	RequireDigit bool
+	// Require at least one character which is not a letter or a digit
+	RequireSpecial bool
+	// Set of known breached passwords (stored in lowercase)
+	breached map[string]struct{}
+}
+
+// LoadBreachedPasswords reads a list of breached passwords from a file.
+// File must contain one password per line, empty lines and lines
+// starting with '#' are ignored. Comparison is case-insensitive.
+func (pp *PasswordPolicy) LoadBreachedPasswords(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open breached passwords file: %v", err)
+	}
+	defer f.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read breached passwords file: %v", err)
+	}
+	pp.breached = breached
+	return nil
+}
+
+// Check verifies password against the policy and returns
+// every requirement that is not satisfied
+func (pp *PasswordPolicy) Check(password string) []error {
+	var errs []error
+	if len(password) == 0 {
+		return append(errs, fmt.Errorf("password must be provided"))
+	}
+
+	length := len([]rune(password))
+	if length < pp.MinLength {
+		errs = append(errs, fmt.Errorf("password must be at least %d characters long", pp.MinLength))
+	}
+	if pp.MaxLength > 0 && length > pp.MaxLength {
+		errs = append(errs, fmt.Errorf("password must be at most %d characters long", pp.MaxLength))
+	}
+
+	var upper, lower, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			special = true
+		}
+	}
+	if pp.RequireUppercase && !upper {
+		errs = append(errs, fmt.Errorf("password must contain an uppercase letter"))
+	}
+	if pp.RequireLowercase && !lower {
+		errs = append(errs, fmt.Errorf("password must contain a lowercase letter"))
+	}
+	if pp.RequireDigit && !digit {
+		errs = append(errs, fmt.Errorf("password must contain a digit"))
+	}
+	if pp.RequireSpecial && !special {
+		errs = append(errs, fmt.Errorf("password must contain a special character"))
+	}
+
+	if _, exist := pp.breached[strings.ToLower(password)]; exist {
+		errs = append(errs, fmt.Errorf("password is present in a list of breached passwords"))
+	}
+	return errs
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PasswordPolicy_Check(t *testing.T) {
+	pp := PasswordPolicy{
+		MinLength:        8,
+		MaxLength:        16,
+		RequireUppercase: true,
+		RequireLowercase: true,
+		RequireDigit:     true,
+		RequireSpecial:   true,
+	}
+	require.NoError(t, pp.LoadBreachedPasswords("../../test/passwords/breached.txt"), "failed to load breached passwords")
+
+	tt := []struct {
+		name     string
+		password string
+		expected []string
+	}{
+		{
+			name:     "Empty password",
+			password: "",
+			expected: []string{"password must be provided"},
+		},
+		{
+			name:     "Too short, only lowercase",
+			password: "abc",
+			expected: []string{
+				"password must be at least 8 characters long",
+				"password must contain an uppercase letter",
+				"password must contain a digit",
+				"password must contain a special character",
+			},
+		},
+		{
+			name:     "Too long",
+			password: "Abcdefgh1!abcdefgh",
+			expected: []string{"password must be at most 16 characters long"},
+		},
+		{
+			name:     "Breached password (case-insensitive)",
+			password: "PASSWORD1!",
+			expected: []string{
+				"password must contain a lowercase letter",
+				"password is present in a list of breached passwords",
+			},
+		},
+		{
+			name:     "Valid password",
+			password: "S0me-Passw0rd",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := pp.Check(tc.password)
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			require.Equal(t, tc.expected, got, "unexpected policy violations")
+		})
+	}
+}
+
+func Test_PasswordPolicy_LoadBreachedPasswords(t *testing.T) {
+	var pp PasswordPolicy
+	err := pp.LoadBreachedPasswords("test")
+	require.NotNil(t, err, "expected to see an error, but got nil")
+	require.Contains(t, err.Error(), "failed to open breached passwords file:")
+}
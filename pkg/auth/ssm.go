@@ -3,25 +3,58 @@ package auth
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/types"
 )
 
 const (
 	// SSMType defines server-side session management (session stored in server memory)
 	SSMType = "session"
-	// SSMCookieName defines cookie name
+	// SSMCookieName defines default cookie name
 	SSMCookieName = "SESSIONID"
 )
 
-// DefineSSM performs Server-Side Session Management struct declaration
-func DefineSSM(sessionDuration, pbkdf2Iterations, pbkdf2KeyLenght int) *SSM {
+// errHeaderCredentials is returned when a request carries both session cookie
+// and a bearer token or an API key which did not authenticate it
+var errHeaderCredentials = fmt.Errorf("session cookie is not accepted together with 'Authorization' or '%s' header", APIKeyHeader)
+
+// DefineSSM performs Server-Side Session Management struct declaration,
+// maxSessionLifetime of 0 means that session lifetime is limited only by inactivity
+func DefineSSM(sessionDuration, maxSessionLifetime, pbkdf2Iterations, pbkdf2KeyLenght int, cookie CookieConfig) *SSM {
 	return &SSM{
-		sessionDuration:  sessionDuration,
-		additionMutex:    sync.Mutex{},
-		sessions:         make(map[string]Session),
-		pbkdf2Iterations: pbkdf2Iterations,
-		pbkdf2KeyLenght:  pbkdf2KeyLenght,
+		sessionDuration:    sessionDuration,
+		maxSessionLifetime: maxSessionLifetime,
+		cookie:             cookie,
+		sessions:           newSessionStore(),
+		pbkdf2Iterations:   pbkdf2Iterations,
+		pbkdf2KeyLenght:    pbkdf2KeyLenght,
+		now:                time.Now,
+	}
+}
+
+// CookieConfig describes session cookie attributes
+type CookieConfig struct {
+	Name     string
+	Domain   string
+	Path     string
+	SameSite http.SameSite
+	Secure   bool
+	// Persistent cookie expires together with the session on server,
+	// otherwise it is deleted when browser is closed
+	Persistent bool
+}
+
+// DefaultCookieConfig returns attributes of a secure browser session cookie
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		Name:     SSMCookieName,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Secure:   true,
 	}
 }
 
@@ -29,77 +62,166 @@ func DefineSSM(sessionDuration, pbkdf2Iterations, pbkdf2KeyLenght int) *SSM {
 // It will create a new session in server memory and puts
 // session ID as a cookie
 type SSM struct {
+	// Guards durations which can be changed on configuration reload
+	durationMutex sync.RWMutex
 	// Sets session duration in seconds
 	sessionDuration int
-	// Session addition mutex
-	additionMutex sync.Mutex
-	// Map holds sessions
-	sessions map[string]Session
+	// Sets absolute session lifetime in seconds regardless of activity
+	maxSessionLifetime int
+	// Session cookie attributes
+	cookie CookieConfig
+	// Holds sessions, safe for concurrent use
+	sessions *sessionStore
 	// Number of iterations that will be given to a
 	// PBKDF2 hashing algorithm
 	pbkdf2Iterations int
 	// Resulted length of PBKDF2 key
 	pbkdf2KeyLenght int
+	// Returns current time, replaced in tests
+	now func() time.Time
 }
 
-// CreateSession creates session and returns a session ID
-func (ssm *SSM) CreateSession(w http.ResponseWriter) (string, error) {
-	ssm.additionMutex.Lock()
-	defer ssm.additionMutex.Unlock()
-	sessionID, err := GenerateRandomString(16)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate session ID: %v", err)
+// CreateSession creates session for a user and returns a session ID
+func (ssm *SSM) CreateSession(w http.ResponseWriter, r *http.Request, user types.User) (string, error) {
+	return ssm.createSession(w, newSession(r, user))
+}
+
+// CreatePendingSession creates session which is not authenticated until
+// the second factor is provided
+func (ssm *SSM) CreatePendingSession(w http.ResponseWriter, r *http.Request, user types.User) (string, error) {
+	session := newSession(r, user)
+	session.Pending = true
+	return ssm.createSession(w, session)
+}
+
+func newSession(r *http.Request, user types.User) Session {
+	session := Session{UserID: user.ID, Username: user.Username}
+	if r != nil {
+		session.IP = clientIP(r)
+		session.UserAgent = r.UserAgent()
 	}
+	return session
+}
+
+// createSession generates session ID, stores the session and sets session cookie
+func (ssm *SSM) createSession(w http.ResponseWriter, session Session) (string, error) {
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = ssm.now()
+	}
+	session.LastActivity = ssm.now()
 	for {
-		if _, exist := ssm.sessions[sessionID]; exist {
-			sessionID, err = GenerateRandomString(16)
-			if err != nil {
-				return "", fmt.Errorf("failed to generate session ID: %v", err)
-			}
-		} else {
+		sessionID, err := GenerateRandomString(16)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate session ID: %v", err)
+		}
+		session.ID = sessionID
+		if ssm.sessions.insert(session) {
 			break
 		}
 	}
+	ssm.setCookie(w, session)
 
+	return session.ID, nil
+}
+
+// setCookie sets session cookie, persistent cookie
+// expires together with the session on server
+func (ssm *SSM) setCookie(w http.ResponseWriter, session Session) {
 	cookie := http.Cookie{
-		Name:     SSMCookieName,
-		Value:    sessionID,
-		MaxAge:   0,
+		Name:     ssm.cookie.Name,
+		Value:    session.ID,
+		Domain:   ssm.cookie.Domain,
+		Path:     ssm.cookie.Path,
 		HttpOnly: true,
-		Secure:   true,
+		Secure:   ssm.cookie.Secure,
+		SameSite: ssm.cookie.SameSite,
 	}
+	if ssm.cookie.Persistent {
+		cookie.Expires = ssm.expiresAt(session)
+		cookie.MaxAge = int(cookie.Expires.Sub(ssm.now()).Seconds())
+	}
+	// Session cookie might be already set during the same request
+	// (e.g. session is checked and then regenerated), keep only the last one
+	var headers []string
+	for _, h := range w.Header()["Set-Cookie"] {
+		if !strings.HasPrefix(h, ssm.cookie.Name+"=") {
+			headers = append(headers, h)
+		}
+	}
+	w.Header()["Set-Cookie"] = headers
 	http.SetCookie(w, &cookie)
-	ssm.sessions[sessionID] = Session{ID: sessionID, LastActivity: time.Now()}
-
-	return sessionID, nil
 }
 
 // CheckSession checks if session is active or valid
-// performs check on a cookie
-func (ssm *SSM) CheckSession(w http.ResponseWriter, r *http.Request) error {
-	if r == nil {
-		return fmt.Errorf("request is nil")
+// performs check on a cookie and returns the session
+func (ssm *SSM) CheckSession(w http.ResponseWriter, r *http.Request) (Session, error) {
+	session, err := ssm.checkSession(w, r)
+	if err != nil {
+		return Session{}, err
+	}
+	if session.Pending {
+		return Session{}, fmt.Errorf("session with '%s' ID requires second authentication factor", session.ID)
 	}
+	return session, nil
+}
 
-	cookie, err := r.Cookie(SSMCookieName)
+// CheckPendingSession checks session which waits for the second factor
+func (ssm *SSM) CheckPendingSession(w http.ResponseWriter, r *http.Request) (Session, error) {
+	session, err := ssm.checkSession(w, r)
 	if err != nil {
-		return fmt.Errorf("failed to get '%s' cookie: %v", SSMCookieName, err)
+		return Session{}, err
 	}
-	session, exist := ssm.sessions[cookie.Value]
-	if !exist {
-		return fmt.Errorf("session with '%s' ID does not exist", cookie.Value)
+	if !session.Pending {
+		return Session{}, fmt.Errorf("session with '%s' ID is not pending", session.ID)
 	}
+	return session, nil
+}
 
-	duration := time.Since(session.LastActivity)
-	if duration.Seconds() > float64(ssm.sessionDuration) {
-		delete(ssm.sessions, cookie.Value)
-		return fmt.Errorf("session with '%s' ID has expired", cookie.Value)
+// CompletePendingSession replaces pending session with a regular one,
+// session ID is regenerated to prevent session fixation
+func (ssm *SSM) CompletePendingSession(w http.ResponseWriter, r *http.Request) (Session, error) {
+	session, err := ssm.CheckPendingSession(w, r)
+	if err != nil {
+		return Session{}, err
 	}
-	// Update session last activity
-	session.LastActivity = time.Now()
-	ssm.sessions[cookie.Value] = session
 
-	return nil
+	// Session might be revoked after it was checked
+	if _, exist := ssm.sessions.delete(session.ID); !exist {
+		return Session{}, fmt.Errorf("session with '%s' ID does not exist", session.ID)
+	}
+	session.Pending = false
+	if session.ID, err = ssm.createSession(w, session); err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func (ssm *SSM) checkSession(w http.ResponseWriter, r *http.Request) (Session, error) {
+	if r == nil {
+		return Session{}, fmt.Errorf("request is nil")
+	}
+	// Such requests are exempt from CSRF check, so the cookie must not authenticate them
+	if hasHeaderCredentials(r) {
+		return Session{}, errHeaderCredentials
+	}
+
+	cookie, err := r.Cookie(ssm.cookie.Name)
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to get '%s' cookie: %v", ssm.cookie.Name, err)
+	}
+	session, err := ssm.sessions.touch(cookie.Value, ssm.expired, func(s *Session) {
+		// Update session last activity
+		s.LastActivity = ssm.now()
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	// Extend cookie lifetime
+	if w != nil && ssm.cookie.Persistent {
+		ssm.setCookie(w, session)
+	}
+
+	return session, nil
 }
 
 // Logout marks user session as ended
@@ -107,23 +229,87 @@ func (ssm *SSM) Logout(r *http.Request) error {
 	if r == nil {
 		return fmt.Errorf("request is nil")
 	}
+	if hasHeaderCredentials(r) {
+		return errHeaderCredentials
+	}
 
-	cookie, err := r.Cookie(SSMCookieName)
+	cookie, err := r.Cookie(ssm.cookie.Name)
 	if err != nil {
-		return fmt.Errorf("failed to get '%s' cookie: %v", SSMCookieName, err)
+		return fmt.Errorf("failed to get '%s' cookie: %v", ssm.cookie.Name, err)
 	}
 
-	delete(ssm.sessions, cookie.Value)
+	ssm.sessions.delete(cookie.Value)
+	return nil
+}
+
+// ListSessions returns active sessions of the user
+func (ssm *SSM) ListSessions(userID int) []Session {
+	sessions := ssm.sessions.filter(func(s Session) bool {
+		return s.UserID == userID && !ssm.expired(s)
+	})
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions
+}
+
+// RevokeSession deletes session of the user by its public handle
+func (ssm *SSM) RevokeSession(userID int, handle string) error {
+	if ssm.sessions.deleteIf(func(s Session) bool { return s.UserID == userID && s.Handle() == handle }) == 0 {
+		return fmt.Errorf("session with '%s' handle does not exist", handle)
+	}
 	return nil
 }
 
+// RevokeUserSessions deletes every session of the user
+// and returns number of deleted sessions
+func (ssm *SSM) RevokeUserSessions(userID int) int {
+	return ssm.sessions.deleteIf(func(s Session) bool { return s.UserID == userID })
+}
+
+// RevokeOtherSessions deletes every session of the user except the
+// current one and returns number of deleted sessions
+func (ssm *SSM) RevokeOtherSessions(userID int, current Session) int {
+	return ssm.sessions.deleteIf(func(s Session) bool { return s.UserID == userID && s.ID != current.ID })
+}
+
+// Sweep deletes expired sessions and returns number of deleted sessions
+func (ssm *SSM) Sweep() int {
+	return ssm.sessions.deleteIf(ssm.expired)
+}
+
+// StartJanitor starts a goroutine which sweeps expired sessions
+// every interval, returned function stops the goroutine
+func (ssm *SSM) StartJanitor(interval time.Duration) (stop func()) {
+	return startJanitor("SSM", interval, ssm.Sweep)
+}
+
+// SetSessionDuration changes session duration and absolute lifetime,
+// they apply to existing sessions as well
+func (ssm *SSM) SetSessionDuration(sessionDuration, maxSessionLifetime int) {
+	ssm.durationMutex.Lock()
+	defer ssm.durationMutex.Unlock()
+	ssm.sessionDuration = sessionDuration
+	ssm.maxSessionLifetime = maxSessionLifetime
+}
+
+// expiresAt returns time when session expires if it stays inactive
+func (ssm *SSM) expiresAt(s Session) time.Time {
+	ssm.durationMutex.RLock()
+	sessionDuration, maxSessionLifetime := ssm.sessionDuration, ssm.maxSessionLifetime
+	ssm.durationMutex.RUnlock()
+	expires := s.LastActivity.Add(time.Duration(sessionDuration) * time.Second)
+	if maxSessionLifetime > 0 {
+		if absolute := s.CreatedAt.Add(time.Duration(maxSessionLifetime) * time.Second); absolute.Before(expires) {
+			return absolute
+		}
+	}
+	return expires
+}
+
+func (ssm *SSM) expired(s Session) bool {
+	return !ssm.now().Before(ssm.expiresAt(s))
+}
+
 // PBKDF2HashPassword performs password hashing
 func (ssm *SSM) PBKDF2HashPassword(password string, salt string) string {
 	return pbkdf2HashPassword(password, salt, ssm.pbkdf2Iterations, ssm.pbkdf2KeyLenght)
 }
-
-// Session that stored in server memory
-type Session struct {
-	ID           string    `json:"id"`
-	LastActivity time.Time `json:"lastActivity"`
-}
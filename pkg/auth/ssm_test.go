@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock is a manually advanced clock
+type testClock struct {
+	t time.Time
+}
+
+func (tc *testClock) now() time.Time {
+	return tc.t
+}
+
+func testSSM(sessionDuration, maxSessionLifetime int) (*SSM, *testClock) {
+	cookie := DefaultCookieConfig()
+	cookie.Persistent = true
+	ssm := DefineSSM(sessionDuration, maxSessionLifetime, 1, 16, cookie)
+	clock := &testClock{t: time.Now()}
+	ssm.now = clock.now
+	return ssm, clock
+}
+
+func testCreateSession(t *testing.T, ssm *SSM) *http.Cookie {
+	rec := httptest.NewRecorder()
+	_, err := ssm.CreateSession(rec, httptest.NewRequest(http.MethodPost, "/", nil), types.User{ID: 1, Username: "test"})
+	require.NoError(t, err, "failed to create session: %v", err)
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1, "expected session cookie to be set")
+	return cookies[0]
+}
+
+func testCheckSession(ssm *SSM, cookie *http.Cookie) (*httptest.ResponseRecorder, error) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(cookie)
+	_, err := ssm.CheckSession(rec, req)
+	return rec, err
+}
+
+func Test_SSM_IdleExpiry(t *testing.T) {
+	ssm, clock := testSSM(10, 0)
+	cookie := testCreateSession(t, ssm)
+	require.Equal(t, 10, cookie.MaxAge, "cookie must expire together with the session")
+	require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	clock.t = clock.t.Add(9 * time.Second)
+	rec, err := testCheckSession(ssm, cookie)
+	require.NoError(t, err, "session must be active")
+	refreshed := rec.Result().Cookies()
+	require.Len(t, refreshed, 1, "cookie must be refreshed on activity")
+	require.Equal(t, 10, refreshed[0].MaxAge)
+
+	clock.t = clock.t.Add(10 * time.Second)
+	_, err = testCheckSession(ssm, cookie)
+	require.NotNil(t, err, "expected to see an error, but got nil")
+	require.Contains(t, err.Error(), "has expired")
+}
+
+func Test_SSM_AbsoluteLifetime(t *testing.T) {
+	ssm, clock := testSSM(10, 25)
+	cookie := testCreateSession(t, ssm)
+
+	for i := 0; i < 2; i++ {
+		clock.t = clock.t.Add(9 * time.Second)
+		_, err := testCheckSession(ssm, cookie)
+		require.NoError(t, err, "session must be active")
+	}
+	// 18 seconds passed, idle expiry would be at 28, absolute at 25
+	clock.t = clock.t.Add(5 * time.Second)
+	rec, err := testCheckSession(ssm, cookie)
+	require.NoError(t, err, "session must be active")
+	require.Equal(t, 2, rec.Result().Cookies()[0].MaxAge, "cookie must not outlive absolute session lifetime")
+
+	clock.t = clock.t.Add(2 * time.Second)
+	_, err = testCheckSession(ssm, cookie)
+	require.NotNil(t, err, "session must expire after absolute lifetime")
+}
+
+func Test_SSM_SetSessionDuration(t *testing.T) {
+	ssm, clock := testSSM(10, 0)
+	cookie := testCreateSession(t, ssm)
+
+	// Longer duration applies to the existing session
+	ssm.SetSessionDuration(60, 0)
+	clock.t = clock.t.Add(30 * time.Second)
+	_, err := testCheckSession(ssm, cookie)
+	require.NoError(t, err, "session must be active after duration is extended")
+
+	ssm.SetSessionDuration(60, 20)
+	_, err = testCheckSession(ssm, cookie)
+	require.Error(t, err, "session must expire after lifetime is shortened")
+}
+
+func Test_SSM_Sweep(t *testing.T) {
+	ssm, clock := testSSM(10, 0)
+	testCreateSession(t, ssm)
+	testCreateSession(t, ssm)
+	clock.t = clock.t.Add(5 * time.Second)
+	active := testCreateSession(t, ssm)
+
+	clock.t = clock.t.Add(6 * time.Second)
+	require.Equal(t, 2, ssm.Sweep(), "expected expired sessions to be swept")
+	require.Equal(t, 1, ssm.sessions.len())
+	_, err := testCheckSession(ssm, active)
+	require.NoError(t, err, "active session must be kept")
+}
+
+func Test_SSM_StartJanitor(t *testing.T) {
+	ssm, clock := testSSM(10, 0)
+	testCreateSession(t, ssm)
+	clock.t = clock.t.Add(time.Minute)
+
+	stop := ssm.StartJanitor(time.Millisecond)
+	defer stop()
+	require.Eventually(t, func() bool {
+		return ssm.sessions.len() == 0
+	}, time.Second, time.Millisecond, "janitor must sweep expired sessions")
+	stop()
+	stop()
+}
+
+func Test_SSM_CookieAttributes(t *testing.T) {
+	ssm := DefineSSM(10, 0, 1, 16, CookieConfig{
+		Name:     "__Host-SID",
+		Path:     "/api",
+		Domain:   "example.com",
+		SameSite: http.SameSiteStrictMode,
+		Secure:   true,
+	})
+	rec := httptest.NewRecorder()
+	_, err := ssm.CreateSession(rec, nil, types.User{ID: 1})
+	require.NoError(t, err)
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1, "expected session cookie to be set")
+	cookie := cookies[0]
+	require.Equal(t, "__Host-SID", cookie.Name)
+	require.Equal(t, "/api", cookie.Path)
+	require.Equal(t, "example.com", cookie.Domain)
+	require.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+	require.True(t, cookie.Secure)
+	require.True(t, cookie.HttpOnly)
+	require.Zero(t, cookie.MaxAge, "non-persistent cookie must not have Max-Age")
+	require.True(t, cookie.Expires.IsZero(), "non-persistent cookie must not have Expires")
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(cookie)
+	_, err = ssm.CheckSession(rec, req)
+	require.NoError(t, err, "session must be found by configured cookie name")
+	require.Empty(t, rec.Result().Cookies(), "non-persistent cookie must not be refreshed")
+}
+
+// Test_SSM_Concurrency hammers session creation, checks and log outs in
+// parallel, it is meant to be run with the race detector (go test -race)
+func Test_SSM_Concurrency(t *testing.T) {
+	ssm := DefineSSM(60, 0, 1, 16, DefaultCookieConfig())
+	const workers = 16
+	const iterations = 200
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				rec := httptest.NewRecorder()
+				if _, err := ssm.CreateSession(rec, httptest.NewRequest(http.MethodPost, "/", nil), types.User{ID: userID}); err != nil {
+					errs <- err
+					return
+				}
+				cookie := rec.Result().Cookies()[0]
+				req := httptest.NewRequest(http.MethodPost, "/", nil)
+				req.AddCookie(cookie)
+				if _, err := ssm.CheckSession(httptest.NewRecorder(), req); err != nil {
+					errs <- err
+					return
+				}
+				ssm.ListSessions(userID)
+				if j%10 == 0 {
+					ssm.Sweep()
+				}
+				if err := ssm.Logout(req); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := ssm.CheckSession(httptest.NewRecorder(), req); err == nil {
+					errs <- fmt.Errorf("session must not exist after log out")
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, 0, ssm.sessions.len(), "every session must be logged out")
+}
+
+func Test_SSM_RevokeUserSessions_Concurrency(t *testing.T) {
+	ssm := DefineSSM(60, 0, 1, 16, DefaultCookieConfig())
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ssm.CreateSession(httptest.NewRecorder(), nil, types.User{ID: 1})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ssm.RevokeUserSessions(1)
+			}
+		}()
+	}
+	wg.Wait()
+	ssm.RevokeUserSessions(1)
+	require.Empty(t, ssm.ListSessions(1))
+}
+
+func Test_SSM_HeaderCredentials(t *testing.T) {
+	ssm, _ := testSSM(10, 0)
+	cookie := testCreateSession(t, ssm)
+
+	tt := []struct {
+		name    string
+		headers map[string]string
+		fail    bool
+	}{
+		{name: "Cookie only", fail: false},
+		{name: "Cookie with bearer token", headers: map[string]string{"Authorization": "Bearer token"}, fail: true},
+		{name: "Cookie with API key", headers: map[string]string{APIKeyHeader: "key"}, fail: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.AddCookie(cookie)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			_, err := ssm.CheckSession(httptest.NewRecorder(), req)
+			if tc.fail {
+				require.Equal(t, errHeaderCredentials, err, "cookie must not authenticate a CSRF exempt request")
+				require.True(t, CSRFExempt(req))
+				require.Equal(t, errHeaderCredentials, ssm.Logout(req))
+			} else {
+				require.NoError(t, err, "expected session to be active")
+			}
+		})
+	}
+}
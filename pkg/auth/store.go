@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+// sessionShardsCount is a number of independently locked session map shards
+const sessionShardsCount = 32
+
+// sessionShard is a part of session store guarded by its own lock
+type sessionShard struct {
+	mutex    sync.RWMutex
+	sessions map[string]Session
+}
+
+// sessionStore keeps sessions in shards selected by session ID hash,
+// so concurrent requests of different sessions rarely contend for a lock
+type sessionStore struct {
+	shards [sessionShardsCount]*sessionShard
+}
+
+func newSessionStore() *sessionStore {
+	st := &sessionStore{}
+	for i := range st.shards {
+		st.shards[i] = &sessionShard{sessions: make(map[string]Session)}
+	}
+	return st
+}
+
+func (st *sessionStore) shard(id string) *sessionShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return st.shards[h.Sum32()%sessionShardsCount]
+}
+
+// insert stores session, false is returned if session ID is taken
+func (st *sessionStore) insert(s Session) bool {
+	sh := st.shard(s.ID)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	if _, exist := sh.sessions[s.ID]; exist {
+		return false
+	}
+	sh.sessions[s.ID] = s
+	return true
+}
+
+// touch atomically checks session and updates it with fn,
+// expired sessions are deleted
+func (st *sessionStore) touch(id string, expired func(Session) bool, fn func(*Session)) (Session, error) {
+	sh := st.shard(id)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	s, exist := sh.sessions[id]
+	if !exist {
+		return Session{}, fmt.Errorf("session with '%s' ID does not exist", id)
+	}
+	if expired(s) {
+		delete(sh.sessions, id)
+		return Session{}, fmt.Errorf("session with '%s' ID has expired", id)
+	}
+	fn(&s)
+	sh.sessions[id] = s
+	return s, nil
+}
+
+// delete removes and returns session, false is returned if session does not exist
+func (st *sessionStore) delete(id string) (Session, bool) {
+	sh := st.shard(id)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	s, exist := sh.sessions[id]
+	if !exist {
+		return Session{}, false
+	}
+	delete(sh.sessions, id)
+	return s, true
+}
+
+// deleteIf removes every session matching fn and returns number of removed sessions
+func (st *sessionStore) deleteIf(fn func(Session) bool) int {
+	count := 0
+	for _, sh := range st.shards {
+		sh.mutex.Lock()
+		for id, s := range sh.sessions {
+			if fn(s) {
+				delete(sh.sessions, id)
+				count++
+			}
+		}
+		sh.mutex.Unlock()
+	}
+	return count
+}
+
+// filter returns every session matching fn
+func (st *sessionStore) filter(fn func(Session) bool) []Session {
+	var sessions []Session
+	for _, sh := range st.shards {
+		sh.mutex.RLock()
+		for _, s := range sh.sessions {
+			if fn(s) {
+				sessions = append(sessions, s)
+			}
+		}
+		sh.mutex.RUnlock()
+	}
+	return sessions
+}
+
+// len returns number of stored sessions
+func (st *sessionStore) len() int {
+	count := 0
+	for _, sh := range st.shards {
+		sh.mutex.RLock()
+		count += len(sh.sessions)
+		sh.mutex.RUnlock()
+	}
+	return count
+}
+
+// startJanitor starts a goroutine which calls sweep every interval,
+// returned function stops the goroutine
+func startJanitor(tag string, interval time.Duration, sweep func() int) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if count := sweep(); count != 0 {
+					log.Printf("[%s] Swept %d expired sessions", tag, count)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
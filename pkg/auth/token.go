@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+)
+
+const (
+	// TokenType defines token-based authorization, short-lived access token is
+	// sent in 'Authorization: Bearer' header and renewed with a refresh token
+	TokenType = "token"
+	// AccessTokenHeader is a response header which carries an issued access token
+	AccessTokenHeader = "X-Access-Token"
+	// AccessTokenExpiresInHeader is a response header with access token lifetime in seconds
+	AccessTokenExpiresInHeader = "X-Access-Token-Expires-In"
+	// RefreshTokenHeader is a response header which carries an issued refresh token
+	RefreshTokenHeader = "X-Refresh-Token"
+)
+
+const (
+	accessTokenLength   = 32
+	refreshTokenLength  = 48
+	tokenFamilyIDLength = 16
+)
+
+// RefreshTokenStore represents a storage of refresh token families
+type RefreshTokenStore interface {
+	GetUserByID(userID int) (types.User, error)
+	StoreRefreshToken(token types.RefreshToken) error
+	UseRefreshToken(tokenHash string) (types.RefreshToken, error)
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int, keepFamilyID string) error
+}
+
+// DefineTokenAuth performs token-based authorization struct declaration,
+// durations are in seconds
+func DefineTokenAuth(accessTokenDuration, refreshTokenDuration, pbkdf2Iterations, pbkdf2KeyLenght int, store RefreshTokenStore) *TokenAuth {
+	return &TokenAuth{
+		accessTokenDuration:  accessTokenDuration,
+		refreshTokenDuration: refreshTokenDuration,
+		sessions:             newSessionStore(),
+		store:                store,
+		pbkdf2Iterations:     pbkdf2Iterations,
+		pbkdf2KeyLenght:      pbkdf2KeyLenght,
+		now:                  time.Now,
+	}
+}
+
+// TokenAuth issues short-lived access tokens kept in server memory and
+// single-use refresh tokens kept in storage. Every refresh rotates the
+// refresh token, replay of a used refresh token revokes its whole family.
+type TokenAuth struct {
+	// Guards durations which can be changed on configuration reload
+	durationMutex sync.RWMutex
+	// Access token lifetime in seconds
+	accessTokenDuration int
+	// Refresh token lifetime in seconds
+	refreshTokenDuration int
+	// Holds access token sessions, safe for concurrent use
+	sessions *sessionStore
+	store    RefreshTokenStore
+	// Number of iterations that will be given to a
+	// PBKDF2 hashing algorithm
+	pbkdf2Iterations int
+	// Resulted length of PBKDF2 key
+	pbkdf2KeyLenght int
+	// Returns current time, replaced in tests
+	now func() time.Time
+}
+
+// CreateSession issues access and refresh tokens of a new token family
+func (ta *TokenAuth) CreateSession(w http.ResponseWriter, r *http.Request, user types.User) (string, error) {
+	session, err := ta.issue(w, newSession(r, user))
+	return session.ID, err
+}
+
+// CreatePendingSession issues an access token which is not authenticated until
+// the second factor is provided, refresh token is not issued
+func (ta *TokenAuth) CreatePendingSession(w http.ResponseWriter, r *http.Request, user types.User) (string, error) {
+	session := newSession(r, user)
+	session.Pending = true
+	session, err := ta.issue(w, session)
+	return session.ID, err
+}
+
+// issue generates access token (and refresh token for a non-pending session),
+// stores them and sets response headers
+func (ta *TokenAuth) issue(w http.ResponseWriter, session Session) (Session, error) {
+	now := ta.now()
+	session.CreatedAt = now
+	session.LastActivity = now
+
+	accessTokenDuration, refreshTokenDuration := ta.durations()
+	var refreshToken string
+	if !session.Pending {
+		if len(session.FamilyID) == 0 {
+			familyID, err := GenerateRandomString(tokenFamilyIDLength)
+			if err != nil {
+				return Session{}, fmt.Errorf("failed to generate token family ID: %v", err)
+			}
+			session.FamilyID = familyID
+		}
+		var err error
+		refreshToken, err = GenerateRandomString(refreshTokenLength)
+		if err != nil {
+			return Session{}, fmt.Errorf("failed to generate refresh token: %v", err)
+		}
+		if err := ta.store.StoreRefreshToken(types.RefreshToken{
+			TokenHash: HashToken(refreshToken),
+			FamilyID:  session.FamilyID,
+			UserID:    session.UserID,
+			ExpiresAt: now.Add(time.Duration(refreshTokenDuration) * time.Second),
+		}); err != nil {
+			return Session{}, err
+		}
+	}
+
+	for {
+		accessToken, err := GenerateRandomString(accessTokenLength)
+		if err != nil {
+			return Session{}, fmt.Errorf("failed to generate access token: %v", err)
+		}
+		session.ID = accessToken
+		if ta.sessions.insert(session) {
+			break
+		}
+	}
+
+	if w != nil {
+		w.Header().Set(AccessTokenHeader, session.ID)
+		w.Header().Set(AccessTokenExpiresInHeader, strconv.Itoa(accessTokenDuration))
+		if len(refreshToken) != 0 {
+			w.Header().Set(RefreshTokenHeader, refreshToken)
+		}
+	}
+	return session, nil
+}
+
+// Refresh exchanges refresh token for a new pair of access and refresh tokens,
+// replay of an already used refresh token revokes every token of its family
+func (ta *TokenAuth) Refresh(w http.ResponseWriter, r *http.Request, refreshToken string) (Session, error) {
+	token, err := ta.store.UseRefreshToken(HashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenReused) {
+			log.Printf("[TokenAuth] Refresh token reuse detected, revoking token family of user with '%d' ID", token.UserID)
+			if revokeErr := ta.revokeFamily(token.FamilyID); revokeErr != nil {
+				log.Printf("[TokenAuth] Error: %v", revokeErr)
+			}
+		}
+		return Session{}, fmt.Errorf("refresh token is invalid: %w", err)
+	}
+
+	user, err := ta.store.GetUserByID(token.UserID)
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to get user of refresh token: %v", err)
+	}
+	if user.IsDisabled {
+		return Session{}, fmt.Errorf("user with '%s' username is disabled", user.Username)
+	}
+
+	session := newSession(r, user)
+	session.FamilyID = token.FamilyID
+	return ta.issue(w, session)
+}
+
+// revokeFamily deletes access tokens of the family and revokes its refresh tokens
+func (ta *TokenAuth) revokeFamily(familyID string) error {
+	ta.sessions.deleteIf(func(s Session) bool { return s.FamilyID == familyID })
+	return ta.store.RevokeRefreshTokenFamily(familyID)
+}
+
+// CheckSession checks access token from 'Authorization: Bearer' header
+func (ta *TokenAuth) CheckSession(w http.ResponseWriter, r *http.Request) (Session, error) {
+	session, err := ta.checkSession(r)
+	if err != nil {
+		return Session{}, err
+	}
+	if session.Pending {
+		return Session{}, fmt.Errorf("access token requires second authentication factor")
+	}
+	return session, nil
+}
+
+// CheckPendingSession checks access token which waits for the second factor
+func (ta *TokenAuth) CheckPendingSession(w http.ResponseWriter, r *http.Request) (Session, error) {
+	session, err := ta.checkSession(r)
+	if err != nil {
+		return Session{}, err
+	}
+	if !session.Pending {
+		return Session{}, fmt.Errorf("access token is not pending")
+	}
+	return session, nil
+}
+
+// CompletePendingSession replaces pending access token with
+// a regular one and issues a refresh token
+func (ta *TokenAuth) CompletePendingSession(w http.ResponseWriter, r *http.Request) (Session, error) {
+	session, err := ta.CheckPendingSession(w, r)
+	if err != nil {
+		return Session{}, err
+	}
+
+	// Session might be revoked after it was checked
+	if _, exist := ta.sessions.delete(session.ID); !exist {
+		return Session{}, fmt.Errorf("access token does not exist")
+	}
+	session.Pending = false
+	return ta.issue(w, session)
+}
+
+func (ta *TokenAuth) checkSession(r *http.Request) (Session, error) {
+	if r == nil {
+		return Session{}, fmt.Errorf("request is nil")
+	}
+
+	token, exist := bearerToken(r)
+	if !exist {
+		return Session{}, fmt.Errorf("access token is not provided")
+	}
+	session, err := ta.sessions.touch(token, ta.expired, func(s *Session) {
+		s.LastActivity = ta.now()
+	})
+	if err != nil {
+		// Do not disclose the token in error messages
+		return Session{}, fmt.Errorf("access token is invalid or expired")
+	}
+	return session, nil
+}
+
+// Logout deletes access token and revokes its refresh token family
+func (ta *TokenAuth) Logout(r *http.Request) error {
+	if r == nil {
+		return fmt.Errorf("request is nil")
+	}
+
+	token, exist := bearerToken(r)
+	if !exist {
+		return fmt.Errorf("access token is not provided")
+	}
+	session, exist := ta.sessions.delete(token)
+	if !exist || len(session.FamilyID) == 0 {
+		return nil
+	}
+	return ta.revokeFamily(session.FamilyID)
+}
+
+// ListSessions returns active access tokens of the user
+func (ta *TokenAuth) ListSessions(userID int) []Session {
+	sessions := ta.sessions.filter(func(s Session) bool {
+		return s.UserID == userID && !ta.expired(s)
+	})
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions
+}
+
+// RevokeSession deletes access token of the user by its public handle
+// and revokes its refresh token family
+func (ta *TokenAuth) RevokeSession(userID int, handle string) error {
+	sessions := ta.sessions.filter(func(s Session) bool { return s.UserID == userID && s.Handle() == handle })
+	if len(sessions) == 0 {
+		return fmt.Errorf("session with '%s' handle does not exist", handle)
+	}
+	for _, s := range sessions {
+		ta.sessions.delete(s.ID)
+		if len(s.FamilyID) != 0 {
+			if err := ta.revokeFamily(s.FamilyID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RevokeUserSessions deletes every access token of the user, revokes
+// every refresh token family and returns number of deleted access tokens
+func (ta *TokenAuth) RevokeUserSessions(userID int) int {
+	count := ta.sessions.deleteIf(func(s Session) bool { return s.UserID == userID })
+	if err := ta.store.RevokeUserRefreshTokens(userID, ""); err != nil {
+		log.Printf("[TokenAuth] Error: %v", err)
+	}
+	return count
+}
+
+// RevokeOtherSessions deletes access tokens and revokes refresh token families
+// of the user except the current session and its family, returns number of
+// deleted access tokens
+func (ta *TokenAuth) RevokeOtherSessions(userID int, current Session) int {
+	count := ta.sessions.deleteIf(func(s Session) bool {
+		kept := s.ID == current.ID || (len(current.FamilyID) != 0 && s.FamilyID == current.FamilyID)
+		return s.UserID == userID && !kept
+	})
+	if err := ta.store.RevokeUserRefreshTokens(userID, current.FamilyID); err != nil {
+		log.Printf("[TokenAuth] Error: %v", err)
+	}
+	return count
+}
+
+// Sweep deletes expired access tokens and returns number of deleted tokens
+func (ta *TokenAuth) Sweep() int {
+	return ta.sessions.deleteIf(ta.expired)
+}
+
+// StartJanitor starts a goroutine which sweeps expired access tokens
+// every interval, returned function stops the goroutine
+func (ta *TokenAuth) StartJanitor(interval time.Duration) (stop func()) {
+	return startJanitor("TokenAuth", interval, ta.Sweep)
+}
+
+// SetTokenDurations changes access and refresh token lifetimes, access token
+// lifetime applies to issued tokens as well, refresh token lifetime to new tokens only
+func (ta *TokenAuth) SetTokenDurations(accessTokenDuration, refreshTokenDuration int) {
+	ta.durationMutex.Lock()
+	defer ta.durationMutex.Unlock()
+	ta.accessTokenDuration = accessTokenDuration
+	ta.refreshTokenDuration = refreshTokenDuration
+}
+
+func (ta *TokenAuth) durations() (access, refresh int) {
+	ta.durationMutex.RLock()
+	defer ta.durationMutex.RUnlock()
+	return ta.accessTokenDuration, ta.refreshTokenDuration
+}
+
+// expired reports whether access token lifetime is over, access tokens
+// are not extended on activity
+func (ta *TokenAuth) expired(s Session) bool {
+	accessTokenDuration, _ := ta.durations()
+	return !ta.now().Before(s.CreatedAt.Add(time.Duration(accessTokenDuration) * time.Second))
+}
+
+// PBKDF2HashPassword performs password hashing
+func (ta *TokenAuth) PBKDF2HashPassword(password string, salt string) string {
+	return pbkdf2HashPassword(password, salt, ta.pbkdf2Iterations, ta.pbkdf2KeyLenght)
+}
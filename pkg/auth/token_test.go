@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func testTokenAuth(t *testing.T) (*TokenAuth, *testClock, types.User) {
+	db := &storage.InMemoryStorage{}
+	require.NoError(t, db.Connect())
+	user := types.User{Username: "test", Email: "test@example.com"}
+	id, err := db.RegisterUser(user)
+	require.NoError(t, err, "failed to register user: %v", err)
+	user.ID = id
+
+	ta := DefineTokenAuth(60, 3600, 1, 16, db)
+	clock := &testClock{t: time.Now()}
+	ta.now = clock.now
+	return ta, clock, user
+}
+
+// testTokens returns access and refresh tokens set in response headers
+func testTokens(t *testing.T, rec *httptest.ResponseRecorder) (string, string) {
+	access := rec.Header().Get(AccessTokenHeader)
+	require.NotEmpty(t, access, "expected access token to be issued")
+	require.Equal(t, "60", rec.Header().Get(AccessTokenExpiresInHeader))
+	return access, rec.Header().Get(RefreshTokenHeader)
+}
+
+func testBearerRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func Test_TokenAuth_AccessTokenExpiry(t *testing.T) {
+	ta, clock, user := testTokenAuth(t)
+	rec := httptest.NewRecorder()
+	_, err := ta.CreateSession(rec, httptest.NewRequest(http.MethodPost, "/", nil), user)
+	require.NoError(t, err)
+	access, refresh := testTokens(t, rec)
+	require.NotEmpty(t, refresh, "expected refresh token to be issued")
+
+	_, err = ta.CheckSession(nil, httptest.NewRequest(http.MethodPost, "/", nil))
+	require.Error(t, err, "request without access token must be rejected")
+
+	clock.t = clock.t.Add(59 * time.Second)
+	session, err := ta.CheckSession(nil, testBearerRequest(access))
+	require.NoError(t, err, "access token must be active")
+	require.Equal(t, user.ID, session.UserID)
+
+	// Access token is not extended on activity
+	clock.t = clock.t.Add(time.Second)
+	_, err = ta.CheckSession(nil, testBearerRequest(access))
+	require.Error(t, err, "access token must expire")
+}
+
+func Test_TokenAuth_SetTokenDurations(t *testing.T) {
+	ta, clock, user := testTokenAuth(t)
+	rec := httptest.NewRecorder()
+	_, err := ta.CreateSession(rec, httptest.NewRequest(http.MethodPost, "/", nil), user)
+	require.NoError(t, err)
+	access, _ := testTokens(t, rec)
+
+	ta.SetTokenDurations(120, 7200)
+	clock.t = clock.t.Add(90 * time.Second)
+	_, err = ta.CheckSession(nil, testBearerRequest(access))
+	require.NoError(t, err, "access token must be active after lifetime is extended")
+
+	rec = httptest.NewRecorder()
+	_, err = ta.CreateSession(rec, httptest.NewRequest(http.MethodPost, "/", nil), user)
+	require.NoError(t, err)
+	require.Equal(t, "120", rec.Header().Get(AccessTokenExpiresInHeader))
+}
+
+func Test_TokenAuth_RefreshRotation(t *testing.T) {
+	ta, _, user := testTokenAuth(t)
+	rec := httptest.NewRecorder()
+	_, err := ta.CreateSession(rec, nil, user)
+	require.NoError(t, err)
+	firstAccess, firstRefresh := testTokens(t, rec)
+
+	rec = httptest.NewRecorder()
+	session, err := ta.Refresh(rec, httptest.NewRequest(http.MethodPost, "/", nil), firstRefresh)
+	require.NoError(t, err, "refresh must succeed: %v", err)
+	require.Equal(t, user.Username, session.Username)
+	secondAccess, secondRefresh := testTokens(t, rec)
+	require.NotEqual(t, firstRefresh, secondRefresh, "refresh token must be rotated")
+	_, err = ta.CheckSession(nil, testBearerRequest(secondAccess))
+	require.NoError(t, err, "new access token must be active")
+
+	// Replay of the first refresh token revokes the whole family
+	_, err = ta.Refresh(httptest.NewRecorder(), nil, firstRefresh)
+	require.True(t, errors.Is(err, storage.ErrRefreshTokenReused), "expected to see reuse error, but got: %v", err)
+	_, err = ta.Refresh(httptest.NewRecorder(), nil, secondRefresh)
+	require.Error(t, err, "refresh token of revoked family must be rejected")
+	for _, access := range []string{firstAccess, secondAccess} {
+		_, err = ta.CheckSession(nil, testBearerRequest(access))
+		require.Error(t, err, "access tokens of revoked family must be rejected")
+	}
+
+	_, err = ta.Refresh(httptest.NewRecorder(), nil, "unknown")
+	require.True(t, errors.Is(err, storage.ErrInvalidToken), "expected to see invalid token error, but got: %v", err)
+}
+
+func Test_TokenAuth_Logout(t *testing.T) {
+	ta, _, user := testTokenAuth(t)
+	rec := httptest.NewRecorder()
+	_, err := ta.CreateSession(rec, nil, user)
+	require.NoError(t, err)
+	access, refresh := testTokens(t, rec)
+
+	require.NoError(t, ta.Logout(testBearerRequest(access)))
+	_, err = ta.CheckSession(nil, testBearerRequest(access))
+	require.Error(t, err, "access token must be deleted on log out")
+	_, err = ta.Refresh(httptest.NewRecorder(), nil, refresh)
+	require.Error(t, err, "refresh token must be revoked on log out")
+}
+
+func Test_TokenAuth_PendingSession(t *testing.T) {
+	ta, _, user := testTokenAuth(t)
+	rec := httptest.NewRecorder()
+	_, err := ta.CreatePendingSession(rec, nil, user)
+	require.NoError(t, err)
+	access, refresh := testTokens(t, rec)
+	require.Empty(t, refresh, "refresh token must not be issued before the second factor")
+
+	_, err = ta.CheckSession(nil, testBearerRequest(access))
+	require.Error(t, err, "pending access token must not be authenticated")
+
+	rec = httptest.NewRecorder()
+	_, err = ta.CompletePendingSession(rec, testBearerRequest(access))
+	require.NoError(t, err)
+	completed, refresh := testTokens(t, rec)
+	require.NotEqual(t, access, completed, "access token must be replaced")
+	require.NotEmpty(t, refresh, "refresh token must be issued after the second factor")
+	_, err = ta.CheckSession(nil, testBearerRequest(completed))
+	require.NoError(t, err)
+}
+
+func Test_TokenAuth_RevokeUserSessions(t *testing.T) {
+	ta, _, user := testTokenAuth(t)
+	rec := httptest.NewRecorder()
+	_, err := ta.CreateSession(rec, nil, user)
+	require.NoError(t, err)
+	_, refresh := testTokens(t, rec)
+
+	require.Equal(t, 1, ta.RevokeUserSessions(user.ID))
+	require.Empty(t, ta.ListSessions(user.ID))
+	_, err = ta.Refresh(httptest.NewRecorder(), nil, refresh)
+	require.Error(t, err, "refresh tokens of the user must be revoked")
+}
+
+func Test_TokenAuth_RevokeOtherSessions(t *testing.T) {
+	ta, _, user := testTokenAuth(t)
+	rec := httptest.NewRecorder()
+	_, err := ta.CreateSession(rec, nil, user)
+	require.NoError(t, err)
+	current, currentRefresh := testTokens(t, rec)
+	rec = httptest.NewRecorder()
+	_, err = ta.CreateSession(rec, nil, user)
+	require.NoError(t, err)
+	_, otherRefresh := testTokens(t, rec)
+
+	session, err := ta.CheckSession(nil, testBearerRequest(current))
+	require.NoError(t, err)
+	require.Equal(t, 1, ta.RevokeOtherSessions(user.ID, session))
+	_, err = ta.CheckSession(nil, testBearerRequest(current))
+	require.NoError(t, err, "current access token must be kept")
+	_, err = ta.Refresh(httptest.NewRecorder(), nil, otherRefresh)
+	require.Error(t, err, "refresh tokens of other families must be revoked")
+	_, err = ta.Refresh(httptest.NewRecorder(), nil, currentRefresh)
+	require.NoError(t, err, "refresh token of the current family must be kept")
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPPeriod is a TOTP time step (RFC 6238)
+	TOTPPeriod = 30 * time.Second
+	// TOTPDigits is a number of digits in a TOTP code
+	TOTPDigits = 6
+	// totpSkew is a number of time steps accepted before and after
+	// the current one to tolerate clock drift
+	totpSkew = 1
+	// totpSecretLength is a TOTP secret length in bytes (160 bits as in RFC 4226)
+	totpSecretLength = 20
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generates a random base32 encoded TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	b, err := randomBytes(totpSecretLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %v", err)
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// TOTPURI returns an 'otpauth://' URI which is understood by authenticator apps
+func TOTPURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(TOTPDigits))
+	v.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// TOTPCode returns a TOTP code for a given time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode TOTP secret: %v", err)
+	}
+	return hotp(key, totpStep(t)), nil
+}
+
+// ValidateTOTP checks TOTP code and returns the matched time step.
+// Steps which are not greater than lastStep are rejected to
+// prevent a code from being used twice.
+func ValidateTOTP(secret, code string, t time.Time, lastStep int64) (int64, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode TOTP secret: %v", err)
+	}
+	if len(code) != TOTPDigits {
+		return 0, fmt.Errorf("TOTP code must be %d digits long", TOTPDigits)
+	}
+
+	current := totpStep(t)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			if step <= lastStep {
+				return 0, fmt.Errorf("TOTP code was already used")
+			}
+			return step, nil
+		}
+	}
+	return 0, fmt.Errorf("TOTP code is invalid")
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// hotp computes HOTP value (RFC 4226)
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// GenerateRecoveryCodes generates one-time recovery codes in 'xxxxx-xxxxx' format
+func GenerateRecoveryCodes(count int) ([]string, error) {
+	codes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		s, err := GenerateRandomString(10)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %v", err)
+		}
+		codes = append(codes, strings.ToLower(s[:5]+"-"+s[5:]))
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode brings user input to the form recovery codes are hashed in
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test vectors from RFC 6238 Appendix B (SHA1, 8 digits truncated to 6)
+func Test_TOTPCode_RFC6238(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tt := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1111111111, expected: "050471"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+		{unix: 20000000000, expected: "353130"},
+	}
+
+	for _, tc := range tt {
+		code, err := TOTPCode(secret, time.Unix(tc.unix, 0))
+		require.NoError(t, err, "expected to get no error, but got: %v", err)
+		require.Equal(t, tc.expected, code, "unexpected code for %d", tc.unix)
+	}
+}
+
+func Test_ValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err, "failed to generate secret: %v", err)
+
+	now := time.Now()
+	code, err := TOTPCode(secret, now)
+	require.NoError(t, err)
+	previous, err := TOTPCode(secret, now.Add(-TOTPPeriod))
+	require.NoError(t, err)
+	stale, err := TOTPCode(secret, now.Add(-5*TOTPPeriod))
+	require.NoError(t, err)
+
+	step, err := ValidateTOTP(secret, code, now, 0)
+	require.NoError(t, err, "current code must be accepted")
+
+	_, err = ValidateTOTP(secret, previous, now, 0)
+	require.NoError(t, err, "code of the previous step must be accepted")
+
+	_, err = ValidateTOTP(secret, code, now, step)
+	require.NotNil(t, err, "code must not be accepted twice")
+	require.Contains(t, err.Error(), "TOTP code was already used")
+
+	_, err = ValidateTOTP(secret, stale, now, 0)
+	if stale != code && stale != previous {
+		require.NotNil(t, err, "stale code must be rejected")
+	}
+
+	_, err = ValidateTOTP(secret, "12345", now, 0)
+	require.NotNil(t, err, "expected to see an error, but got nil")
+	require.Contains(t, err.Error(), "TOTP code must be 6 digits long")
+}
+
+func Test_TOTPURI(t *testing.T) {
+	uri := TOTPURI("go-server", "tester", "SECRET")
+	require.True(t, strings.HasPrefix(uri, "otpauth://totp/go-server:tester?"), "unexpected URI: %s", uri)
+	require.Contains(t, uri, "secret=SECRET")
+	require.Contains(t, uri, "issuer=go-server")
+}
+
+func Test_GenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	require.NoError(t, err, "expected to get no error, but got: %v", err)
+	require.Len(t, codes, 10)
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		require.Len(t, c, 11, "unexpected recovery code format: %s", c)
+		require.Equal(t, NormalizeRecoveryCode(c), c)
+		require.False(t, seen[c], "recovery codes must be unique")
+		seen[c] = true
+	}
+}
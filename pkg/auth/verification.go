@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailVerifier issues and checks signed email verification tokens.
+// Token binds user ID, email and expiry time, so it becomes invalid
+// once user email is changed.
+type EmailVerifier struct {
+	key           []byte
+	tokenDuration time.Duration
+}
+
+// DefineEmailVerifier performs EmailVerifier struct declaration
+func DefineEmailVerifier(key string, tokenDuration time.Duration) *EmailVerifier {
+	return &EmailVerifier{
+		key:           []byte(key),
+		tokenDuration: tokenDuration,
+	}
+}
+
+// TokenDuration returns verification token lifetime
+func (ev *EmailVerifier) TokenDuration() time.Duration {
+	return ev.tokenDuration
+}
+
+// Token creates a signed verification token
+func (ev *EmailVerifier) Token(userID int, email string) string {
+	expires := time.Now().Add(ev.tokenDuration).Unix()
+	payload := fmt.Sprintf("%d|%d|%s", userID, expires, email)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(ev.sign(payload))
+}
+
+// Verify checks token signature and expiry and returns user ID and email
+func (ev *EmailVerifier) Verify(token string) (userID int, email string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return userID, email, fmt.Errorf("malformed verification token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return userID, email, fmt.Errorf("malformed verification token payload: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return userID, email, fmt.Errorf("malformed verification token signature: %v", err)
+	}
+	if !hmac.Equal(signature, ev.sign(string(payload))) {
+		return userID, email, fmt.Errorf("invalid verification token signature")
+	}
+
+	fields := strings.SplitN(string(payload), "|", 3)
+	if len(fields) != 3 {
+		return userID, email, fmt.Errorf("malformed verification token payload")
+	}
+	userID, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return userID, email, fmt.Errorf("invalid user ID in verification token: %v", err)
+	}
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return userID, email, fmt.Errorf("invalid expiry in verification token: %v", err)
+	}
+	if time.Now().Unix() > expires {
+		return userID, email, fmt.Errorf("verification token has expired")
+	}
+	return userID, fields[2], nil
+}
+
+func (ev *EmailVerifier) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, ev.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EmailVerifier(t *testing.T) {
+	ev := DefineEmailVerifier("TESTKEY", time.Hour)
+	token := ev.Token(42, "user@example.com")
+
+	userID, email, err := ev.Verify(token)
+	require.NoError(t, err, "expected to get no error, but got: %v", err)
+	require.Equal(t, 42, userID)
+	require.Equal(t, "user@example.com", email)
+
+	expired := DefineEmailVerifier("TESTKEY", -time.Hour).Token(42, "user@example.com")
+
+	tt := []struct {
+		name     string
+		token    string
+		expected string
+	}{
+		{
+			name:     "Malformed token",
+			token:    "test",
+			expected: "malformed verification token",
+		},
+		{
+			name:     "Signed with a different key",
+			token:    DefineEmailVerifier("OTHERKEY", time.Hour).Token(42, "user@example.com"),
+			expected: "invalid verification token signature",
+		},
+		{
+			name:     "Tampered payload",
+			token:    ev.Token(1, "user@example.com")[:4] + token[4:],
+			expected: "invalid verification token signature",
+		},
+		{
+			name:     "Expired token",
+			token:    expired,
+			expected: "verification token has expired",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := ev.Verify(tc.token)
+			require.NotNil(t, err, "expected to see an error, but got nil")
+			require.Contains(t, err.Error(), tc.expected, "expected to see a different error")
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GenerateSelfSigned(t *testing.T) {
+	certPEM, keyPEM, err := GenerateSelfSigned([]string{"example.local", "10.0.0.1"}, 24*time.Hour)
+	require.NoError(t, err, "failed to generate certificate: %v", err)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err, "generated certificate and key must match: %v", err)
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, "example.local", leaf.Subject.CommonName)
+	require.Equal(t, []string{"example.local"}, leaf.DNSNames)
+	require.True(t, leaf.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")))
+	require.Equal(t, elliptic.P384(), leaf.PublicKey.(*ecdsa.PublicKey).Curve)
+	require.NoError(t, leaf.VerifyHostname("example.local"))
+	require.WithinDuration(t, time.Now().Add(24*time.Hour), leaf.NotAfter, time.Minute)
+
+	_, _, err = GenerateSelfSigned(nil, time.Hour)
+	require.NoError(t, err, "default hosts must be used: %v", err)
+}
+
+func Test_WriteSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, WriteSelfSigned(certPath, keyPath, nil, time.Hour))
+
+	info, err := os.Stat(keyPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm(), "key must be readable only by the owner")
+
+	r, err := DefineReloader(certPath, keyPath)
+	require.NoError(t, err, "written certificate must be loadable: %v", err)
+	require.Equal(t, "localhost", testCommonName(t, r))
+}
+
+func Test_EnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	generated, err := EnsureSelfSigned(certPath, keyPath, nil, time.Hour)
+	require.NoError(t, err)
+	require.True(t, generated, "missing certificate must be generated")
+	before, err := ioutil.ReadFile(certPath)
+	require.NoError(t, err)
+
+	generated, err = EnsureSelfSigned(certPath, keyPath, nil, time.Hour)
+	require.NoError(t, err)
+	require.False(t, generated, "existing certificate must be kept")
+	after, err := ioutil.ReadFile(certPath)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	require.NoError(t, os.Remove(keyPath))
+	_, err = EnsureSelfSigned(certPath, keyPath, nil, time.Hour)
+	require.Error(t, err, "certificate without key must not be overwritten")
+}
@@ -0,0 +1,121 @@
+// Package certs provides TLS certificate management
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefineReloader loads certificate and key from disk, they can be
+// reloaded later without server restart
+func DefineReloader(certPath, keyPath string) (*Reloader, error) {
+	r := &Reloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reloader serves a certificate via tls.Config.GetCertificate and
+// replaces it when files on disk change
+type Reloader struct {
+	certPath string
+	keyPath  string
+
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+	// Modification times of the loaded files
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// GetCertificate returns currently loaded certificate, it is meant
+// to be used as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads certificate and key from disk, previous certificate
+// is kept if loading fails
+func (r *Reloader) Reload() error {
+	certModTime, keyModTime, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	cert.Leaf = leaf
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cert = &cert
+	r.certModTime = certModTime
+	r.keyModTime = keyModTime
+	log.Printf("[Reloader] Loaded certificate '%s', it expires at %s", leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// Watch checks files for changes on each interval and reloads the certificate,
+// returned function stops watching
+func (r *Reloader) Watch(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if !r.changed() {
+					continue
+				}
+				if err := r.Reload(); err != nil {
+					log.Printf("[Reloader] Error: %v", err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// changed reports whether certificate or key file was modified since the last load
+func (r *Reloader) changed() bool {
+	certModTime, keyModTime, err := r.modTimes()
+	if err != nil {
+		log.Printf("[Reloader] Error: %v", err)
+		return false
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return !certModTime.Equal(r.certModTime) || !keyModTime.Equal(r.keyModTime)
+}
+
+func (r *Reloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to stat certificate: %v", err)
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to stat key: %v", err)
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
@@ -0,0 +1,65 @@
+package certs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testWriteCert writes a self-signed certificate with the common name
+func testWriteCert(t *testing.T, certPath, keyPath, commonName string) {
+	require.NoError(t, WriteSelfSigned(certPath, keyPath, []string{commonName}, time.Hour))
+}
+
+func testCommonName(t *testing.T, r *Reloader) string {
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	return cert.Leaf.Subject.CommonName
+}
+
+func Test_Reloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	_, err := DefineReloader(certPath, keyPath)
+	require.Error(t, err, "missing certificate must be reported")
+
+	testWriteCert(t, certPath, keyPath, "first")
+	r, err := DefineReloader(certPath, keyPath)
+	require.NoError(t, err, "failed to load certificate: %v", err)
+	require.Equal(t, "first", testCommonName(t, r))
+
+	testWriteCert(t, certPath, keyPath, "second")
+	require.NoError(t, r.Reload())
+	require.Equal(t, "second", testCommonName(t, r))
+
+	// Broken certificate doesn't replace the loaded one
+	require.NoError(t, ioutil.WriteFile(certPath, []byte("broken"), 0600))
+	require.Error(t, r.Reload())
+	require.Equal(t, "second", testCommonName(t, r))
+}
+
+func Test_Reloader_Watch(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	testWriteCert(t, certPath, keyPath, "first")
+	r, err := DefineReloader(certPath, keyPath)
+	require.NoError(t, err)
+
+	stop := r.Watch(10 * time.Millisecond)
+	defer stop()
+	require.False(t, r.changed(), "unchanged files must not be reloaded")
+
+	testWriteCert(t, certPath, keyPath, "rotated")
+	// Make sure modification time differs on file systems with coarse timestamps
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certPath, future, future))
+	require.Eventually(t, func() bool {
+		cert, _ := r.GetCertificate(nil)
+		return cert.Leaf.Subject.CommonName == "rotated"
+	}, 5*time.Second, 10*time.Millisecond, "rotated certificate must be loaded")
+}
@@ -1,13 +1,26 @@
 package conf
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/handler"
+	"github.com/sergeikus/go-rest-template/pkg/logging"
+	"github.com/sergeikus/go-rest-template/pkg/notify"
 	"github.com/sergeikus/go-rest-template/pkg/storage"
-	"gopkg.in/yaml.v2"
 )
 
 // Conf represents server configuration files
@@ -15,102 +28,1057 @@ type Conf struct {
 	TLS           bool          `yaml:"tls"`
 	TLSKeyPath    string        `yaml:"tlsKeyPath,omitempty"`
 	TLSCertPath   string        `yaml:"tlsCertPath,omitempty"`
-	Port          int           `yaml:"port"`
+	TLSClientAuth ClientAuth    `yaml:"tlsClientAuth,omitempty"`
+	TLSOptions    TLSOptions    `yaml:"tlsOptions,omitempty"`
+	Port          int           `yaml:"port" default:"8080"`
 	Database      Database      `yaml:"database"`
 	Authorization Authorization `yaml:"authorization"`
+	Notifier      Notifier      `yaml:"notifier"`
+	// Development mode allows insecure settings (e.g. session cookie over plain HTTP)
+	Dev bool `yaml:"dev"`
+	// Self-signed certificate generation for development
+	TLSAutoGenerate AutoGenerate `yaml:"tlsAutoGenerate,omitempty"`
+	// Server listeners, if empty a single listener on 'port' is used
+	Listeners []Listener `yaml:"listeners,omitempty"`
+	// Server timeouts and request size limits
+	Server Server `yaml:"server,omitempty"`
+	// Interval (in seconds) of configuration file change checks, 0 disables the checks
+	ReloadInterval int `yaml:"reloadInterval,omitempty"`
+	// Minimal level of log messages: 'info', 'warning' or 'error'
+	LogLevel string `yaml:"logLevel,omitempty" enum:"info,warning,error" default:"info"`
+
+	// Positions of fields in the configuration file, nil if it wasn't read from a file
+	source *source
 }
 
-// Validate performs configuration validation
+// Validate performs configuration validation, every problem found is returned in ValidationErrors
 func (c *Conf) Validate() error {
+	v := newValidator()
+	if c.source != nil {
+		*v.errs = append(*v.errs, c.source.problems...)
+	}
 	if c.TLS {
 		if len(c.TLSKeyPath) == 0 {
-			return fmt.Errorf("TLS key path must be provided")
+			v.addf("tlsKeyPath", "TLS key path must be provided")
 		}
 		if len(c.TLSCertPath) == 0 {
-			return fmt.Errorf("TLS certificate path must be provided")
+			v.addf("tlsCertPath", "TLS certificate path must be provided")
+		}
+	}
+	c.TLSOptions.validate(v.at("tlsOptions"))
+	if c.TLSAutoGenerate.Enabled && !c.TLS {
+		v.addf("tlsAutoGenerate.enabled", "TLS certificate generation requires TLS to be enabled")
+	}
+	if c.TLSAutoGenerate.ValidDays < 0 {
+		v.addf("tlsAutoGenerate.validDays", "generated TLS certificate validity can't be negative")
+	}
+	c.TLSClientAuth.validate(v.at("tlsClientAuth"))
+	if c.TLSClientAuth.Enabled() && !c.TLS {
+		v.addf("tlsClientAuth.mode", "TLS client authentication requires TLS to be enabled")
+	}
+	if len(c.Listeners) == 0 && c.Port == 0 {
+		v.addf("port", "port can't be 0 (verify that it's specified in the configuration)")
+	}
+	c.validateListeners(v.at("listeners"))
+	c.Server.validate(v.at("server"))
+	if c.ReloadInterval < 0 {
+		v.addf("reloadInterval", "configuration reload interval can't be negative")
+	}
+	switch c.LogLevel {
+	case "", logging.LevelInfo, logging.LevelWarning, logging.LevelError:
+	default:
+		v.addf("logLevel", "unknown log level: %s", c.LogLevel)
+	}
+	c.Database.validate(v.at("database"))
+	c.Authorization.validate(v.at("authorization"))
+	if !c.Dev && !c.Authorization.Cookie.IsSecure() {
+		v.addf("authorization.cookie.secure", "insecure session cookie is allowed only in 'dev' mode")
+	}
+	if !c.Dev && c.Authorization.OIDC.Enabled && !strings.HasPrefix(c.Authorization.OIDC.Issuer, "https://") {
+		v.addf("authorization.oidc.issuer", "OpenID Connect issuer without HTTPS is allowed only in 'dev' mode")
+	}
+	c.Notifier.validate(v.at("notifier"))
+
+	if c.source != nil {
+		for i := range *v.errs {
+			if e := &(*v.errs)[i]; e.Line == 0 {
+				e.Line = c.source.line(e.Path)
+			}
+		}
+	}
+	return v.err()
+}
+
+// Default server limits, they are used if not configured
+const (
+	defaultReadHeaderTimeout = 10
+	defaultReadTimeout       = 30
+	defaultWriteTimeout      = 30
+	defaultIdleTimeout       = 120
+	defaultMaxHeaderBytes    = 1 << 20
+	defaultMaxBodyBytes      = 1 << 20
+)
+
+// Server represents HTTP server timeouts (in seconds) and request size limits,
+// 0 means a default value
+type Server struct {
+	ReadHeaderTimeout int `yaml:"readHeaderTimeout,omitempty" default:"10"`
+	ReadTimeout       int `yaml:"readTimeout,omitempty" default:"30"`
+	WriteTimeout      int `yaml:"writeTimeout,omitempty" default:"30"`
+	IdleTimeout       int `yaml:"idleTimeout,omitempty" default:"120"`
+	MaxHeaderBytes    int `yaml:"maxHeaderBytes,omitempty" default:"1048576"`
+	// Request body size limit, larger requests are rejected with 413 status
+	MaxBodyBytes int64 `yaml:"maxBodyBytes,omitempty" default:"1048576"`
+	// Body size limits of specific routes (URL paths) overriding 'maxBodyBytes'
+	RouteMaxBodyBytes map[string]int64 `yaml:"routeMaxBodyBytes,omitempty"`
+}
+
+// Validate performs server configuration validation
+func (s *Server) Validate() error {
+	v := newValidator()
+	s.validate(v)
+	return v.err()
+}
+
+func (s *Server) validate(v validator) {
+	timeouts := []struct {
+		name  string
+		value int
+	}{
+		{"readHeaderTimeout", s.ReadHeaderTimeout},
+		{"readTimeout", s.ReadTimeout},
+		{"writeTimeout", s.WriteTimeout},
+		{"idleTimeout", s.IdleTimeout},
+	}
+	for _, t := range timeouts {
+		if t.value < 0 {
+			v.addf(t.name, "timeouts can't be negative")
+		}
+	}
+	if s.MaxHeaderBytes < 0 {
+		v.addf("maxHeaderBytes", "max header bytes can't be negative")
+	}
+	if s.MaxBodyBytes < 0 {
+		v.addf("maxBodyBytes", "max body bytes can't be negative")
+	}
+	for route, limit := range s.RouteMaxBodyBytes {
+		if !strings.HasPrefix(route, "/") {
+			v.addf("routeMaxBodyBytes", "route '%s' must start with '/'", route)
+		}
+		if limit <= 0 {
+			v.addf("routeMaxBodyBytes", "body size limit of '%s' route must be a positive number", route)
+		}
+	}
+}
+
+// Apply sets timeouts and header size limit of the server
+func (s *Server) Apply(srv *http.Server) {
+	srv.ReadHeaderTimeout = time.Duration(orDefault(s.ReadHeaderTimeout, defaultReadHeaderTimeout)) * time.Second
+	srv.ReadTimeout = time.Duration(orDefault(s.ReadTimeout, defaultReadTimeout)) * time.Second
+	srv.WriteTimeout = time.Duration(orDefault(s.WriteTimeout, defaultWriteTimeout)) * time.Second
+	srv.IdleTimeout = time.Duration(orDefault(s.IdleTimeout, defaultIdleTimeout)) * time.Second
+	srv.MaxHeaderBytes = orDefault(s.MaxHeaderBytes, defaultMaxHeaderBytes)
+}
+
+// GetMaxBodyBytes returns default request body size limit
+func (s *Server) GetMaxBodyBytes() int64 {
+	if s.MaxBodyBytes == 0 {
+		return defaultMaxBodyBytes
+	}
+	return s.MaxBodyBytes
+}
+
+func orDefault(value, defaultValue int) int {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+const (
+	// NetworkTCP is a TCP listener network
+	NetworkTCP = "tcp"
+	// NetworkUnix is a Unix domain socket listener network
+	NetworkUnix = "unix"
+	// defaultSocketMode is Unix socket file permissions if not configured
+	defaultSocketMode = 0660
+)
+
+// Listener represents an address the server accepts connections on
+type Listener struct {
+	// 'tcp' (default) or 'unix'
+	Network string `yaml:"network,omitempty" enum:"tcp,unix" default:"tcp"`
+	// Host and port for TCP (e.g. ':8443'), socket file path for Unix
+	Address string `yaml:"address"`
+	// Serve HTTPS, TLS must be configured
+	TLS bool `yaml:"tls,omitempty"`
+	// Redirect every request to the first TCP TLS listener instead of serving API
+	RedirectToHTTPS bool `yaml:"redirectToHTTPS,omitempty"`
+	// Unix socket file permissions in octal notation, default is '0660'
+	SocketMode string `yaml:"socketMode,omitempty" default:"0660"`
+}
+
+// GetNetwork returns listener network, TCP is the default
+func (l *Listener) GetNetwork() string {
+	if len(l.Network) == 0 {
+		return NetworkTCP
+	}
+	return l.Network
+}
+
+// GetSocketMode returns Unix socket file permissions
+func (l *Listener) GetSocketMode() os.FileMode {
+	mode, err := strconv.ParseUint(l.SocketMode, 8, 32)
+	if err != nil {
+		return defaultSocketMode
+	}
+	return os.FileMode(mode)
+}
+
+// Validate performs listener validation
+func (l *Listener) Validate() error {
+	v := newValidator()
+	l.validate(v)
+	return v.err()
+}
+
+func (l *Listener) validate(v validator) {
+	if len(l.Address) == 0 {
+		v.addf("address", "address must be provided")
+	}
+	switch l.GetNetwork() {
+	case NetworkTCP:
+		if _, port, err := net.SplitHostPort(l.Address); len(l.Address) != 0 && (err != nil || len(port) == 0) {
+			v.addf("address", "address '%s' must be in 'host:port' format", l.Address)
+		}
+		if len(l.SocketMode) != 0 {
+			v.addf("socketMode", "socket mode can be set only for '%s' network", NetworkUnix)
+		}
+	case NetworkUnix:
+		if len(l.SocketMode) != 0 {
+			if mode, err := strconv.ParseUint(l.SocketMode, 8, 32); err != nil || mode > 0777 {
+				v.addf("socketMode", "invalid socket mode: '%s'", l.SocketMode)
+			}
+		}
+	default:
+		v.addf("network", "unsupported network: '%s', supported networks are: '%s', '%s'", l.Network, NetworkTCP, NetworkUnix)
+	}
+	if l.RedirectToHTTPS && l.TLS {
+		v.addf("redirectToHTTPS", "listener which redirects to HTTPS can't serve TLS")
+	}
+}
+
+// ServerListeners returns configured listeners, a single listener on 'port'
+// is returned if none are configured
+func (c *Conf) ServerListeners() []Listener {
+	if len(c.Listeners) != 0 {
+		return c.Listeners
+	}
+	return []Listener{{Network: NetworkTCP, Address: ":" + strconv.Itoa(c.Port), TLS: c.TLS}}
+}
+
+// HTTPSPort returns port of the first TCP TLS listener, requests are redirected to it
+func (c *Conf) HTTPSPort() (int, bool) {
+	for _, l := range c.ServerListeners() {
+		if !l.TLS || l.GetNetwork() != NetworkTCP {
+			continue
 		}
+		if _, p, err := net.SplitHostPort(l.Address); err == nil {
+			if port, err := strconv.Atoi(p); err == nil {
+				return port, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (c *Conf) validateListeners(v validator) {
+	addresses := make(map[string]bool)
+	for i, l := range c.Listeners {
+		lv := v.at(fmt.Sprintf("[%d]", i))
+		l.validate(lv)
+		if l.TLS && !c.TLS {
+			lv.addf("tls", "TLS must be enabled to serve HTTPS")
+		}
+		if l.RedirectToHTTPS {
+			if _, exist := c.HTTPSPort(); !exist {
+				lv.addf("redirectToHTTPS", "TCP TLS listener is required to redirect to HTTPS")
+			}
+		}
+		key := l.GetNetwork() + " " + l.Address
+		if len(l.Address) != 0 && addresses[key] {
+			lv.addf("address", "address '%s' is used more than once", l.Address)
+		}
+		addresses[key] = true
+	}
+}
+
+const (
+	// ClientAuthRequire rejects TLS handshake without a valid client certificate
+	ClientAuthRequire = "require"
+	// ClientAuthVerifyIfGiven verifies client certificate only if it is presented,
+	// clients without a certificate are authenticated as usual
+	ClientAuthVerifyIfGiven = "verifyIfGiven"
+)
+
+// ClientAuth represents TLS client certificate authentication configuration
+type ClientAuth struct {
+	// Empty mode disables client certificate authentication
+	Mode string `yaml:"mode,omitempty" enum:"require,verifyIfGiven"`
+	// Path to PEM encoded CA certificates client certificates are verified with
+	CAPath string `yaml:"caPath,omitempty"`
+	// Certificate field mapped to a user: 'subject', 'email' or 'dns'
+	Mapping string `yaml:"mapping,omitempty" enum:"subject,email,dns"`
+	// Scopes allowed to certificate sessions: 'data:read', 'data:write'
+	Scopes []string `yaml:"scopes,omitempty"`
+}
+
+// Enabled reports whether client certificates are requested
+func (ca *ClientAuth) Enabled() bool {
+	return len(ca.Mode) != 0
+}
+
+// Validate performs TLS client authentication configuration validation
+func (ca *ClientAuth) Validate() error {
+	v := newValidator()
+	ca.validate(v)
+	return v.err()
+}
+
+func (ca *ClientAuth) validate(v validator) {
+	if !ca.Enabled() {
+		return
+	}
+	if ca.Mode != ClientAuthRequire && ca.Mode != ClientAuthVerifyIfGiven {
+		v.addf("mode", "unsupported mode: '%s', supported modes are: '%s', '%s'", ca.Mode, ClientAuthRequire, ClientAuthVerifyIfGiven)
+	}
+	if len(ca.CAPath) == 0 {
+		v.addf("caPath", "CA path must be provided")
 	}
-	if c.Port == 0 {
-		return fmt.Errorf("port can't be 0 (verify that it's specified in the configuration)")
+	if !contains(auth.ClientCertMappings, ca.Mapping) {
+		v.addf("mapping", "unsupported mapping: '%s', supported mappings are: %s", ca.Mapping, strings.Join(auth.ClientCertMappings, ", "))
 	}
-	if err := c.Database.Validate(); err != nil {
-		return fmt.Errorf("database configuration validation failed: %v", err)
+	if len(ca.Scopes) == 0 {
+		v.addf("scopes", "scopes of certificate sessions must be provided")
+	}
+	for i, scope := range ca.Scopes {
+		if !contains(auth.ClientCertScopes, scope) {
+			v.addf(fmt.Sprintf("scopes[%d]", i), "unsupported scope: '%s', supported scopes are: %s", scope, strings.Join(auth.ClientCertScopes, ", "))
+		}
+	}
+}
+
+// Apply configures TLS configuration to request and verify client certificates,
+// CA path is read relatively to the current working directory
+func (ca *ClientAuth) Apply(cfg *tls.Config) error {
+	pem, err := ioutil.ReadFile(ca.CAPath)
+	if err != nil {
+		return fmt.Errorf("failed to read CA certificates: %v", err)
 	}
-	if err := c.Authorization.Validate(); err != nil {
-		return fmt.Errorf("authorization configuration validation failed: %v", err)
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no CA certificates found in '%s'", ca.CAPath)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if ca.Mode == ClientAuthRequire {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
 	}
 	return nil
 }
 
+// defaultCertValidDays is a validity of a generated certificate if not configured
+const defaultCertValidDays = 365
+
+// AutoGenerate represents self-signed certificate generation on startup,
+// certificate is generated at TLS certificate and key paths if they don't exist
+type AutoGenerate struct {
+	Enabled bool `yaml:"enabled"`
+	// Certificate SANs (DNS names or IP addresses), default is 'localhost', '127.0.0.1', '::1'
+	Hosts []string `yaml:"hosts,omitempty" default:"localhost,127.0.0.1,::1"`
+	// Certificate validity in days, default is 365
+	ValidDays int `yaml:"validDays,omitempty" default:"365"`
+}
+
+// ValidFor returns validity of a generated certificate
+func (ag *AutoGenerate) ValidFor() time.Duration {
+	days := ag.ValidDays
+	if days == 0 {
+		days = defaultCertValidDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+	tlsCurves = map[string]tls.CurveID{
+		"X25519": tls.X25519,
+		"P256":   tls.CurveP256,
+		"P384":   tls.CurveP384,
+		"P521":   tls.CurveP521,
+	}
+	// ALPN protocols supported by the server
+	tlsProtocols = []string{"h2", "http/1.1"}
+)
+
+// TLSOptions represents TLS handshake parameters and certificate reloading
+type TLSOptions struct {
+	// Minimal accepted TLS version: '1.2' (default) or '1.3'
+	MinVersion string `yaml:"minVersion,omitempty" enum:"1.2,1.3" default:"1.2"`
+	// TLS 1.2 cipher suite names, Go defaults are used if empty
+	CipherSuites []string `yaml:"cipherSuites,omitempty"`
+	// Key exchange curves in preference order, Go defaults are used if empty
+	CurvePreferences []string `yaml:"curvePreferences,omitempty"`
+	// ALPN protocols in preference order, default is 'h2' and 'http/1.1'
+	ALPN []string `yaml:"alpn,omitempty" default:"h2,http/1.1"`
+	// Interval in seconds between checks of certificate files for changes, 0 disables checks
+	ReloadInterval int `yaml:"reloadInterval,omitempty"`
+}
+
+// Validate performs TLS options validation
+func (o *TLSOptions) Validate() error {
+	v := newValidator()
+	o.validate(v)
+	return v.err()
+}
+
+func (o *TLSOptions) validate(v validator) {
+	if o.ReloadInterval < 0 {
+		v.addf("reloadInterval", "reload interval can't be negative")
+	}
+	// Options depend on each other, so only the first problem is reported
+	if _, err := o.Config(); err != nil {
+		v.addf("", "%v", err)
+	}
+}
+
+// Config returns TLS configuration with the options applied, certificates are not set
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(o.MinVersion) != 0 {
+		version, exist := tlsVersions[o.MinVersion]
+		if !exist {
+			return nil, fmt.Errorf("unsupported minimal version: '%s', supported versions are: '1.2', '1.3'", o.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+
+	if len(o.CipherSuites) != 0 && cfg.MinVersion == tls.VersionTLS13 {
+		return nil, fmt.Errorf("cipher suites can't be configured for TLS 1.3")
+	}
+	for _, name := range o.CipherSuites {
+		id, err := cipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+
+	for _, name := range o.CurvePreferences {
+		curve, exist := tlsCurves[name]
+		if !exist {
+			return nil, fmt.Errorf("unsupported curve: '%s', supported curves are: 'X25519', 'P256', 'P384', 'P521'", name)
+		}
+		cfg.CurvePreferences = append(cfg.CurvePreferences, curve)
+	}
+
+	cfg.NextProtos = append([]string(nil), tlsProtocols...)
+	if len(o.ALPN) != 0 {
+		for _, protocol := range o.ALPN {
+			if protocol != tlsProtocols[0] && protocol != tlsProtocols[1] {
+				return nil, fmt.Errorf("unsupported ALPN protocol: '%s', supported protocols are: %s", protocol, strings.Join(tlsProtocols, ", "))
+			}
+		}
+		cfg.NextProtos = o.ALPN
+	}
+
+	// HTTP/2 requires at least one of these cipher suites (RFC 7540, section 9.2.2)
+	if len(cfg.CipherSuites) != 0 && contains(cfg.NextProtos, "h2") &&
+		!containsCipherSuite(cfg.CipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) &&
+		!containsCipherSuite(cfg.CipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		return nil, fmt.Errorf("HTTP/2 requires 'TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256' or 'TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256' cipher suite")
+	}
+	return cfg, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCipherSuite(ids []uint16, id uint16) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// cipherSuite returns ID of a secure cipher suite with the name
+func cipherSuite(name string) (uint16, error) {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == name {
+			return cs.ID, nil
+		}
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		if cs.Name == name {
+			return 0, fmt.Errorf("cipher suite '%s' is insecure", name)
+		}
+	}
+	return 0, fmt.Errorf("unsupported cipher suite: '%s'", name)
+}
+
 // Database represents database configuration
 type Database struct {
-	Type     string `yaml:"type"`
+	Type     string `yaml:"type" enum:"in-memory,postgres"`
 	Host     string `yaml:"host,omitempty"`
-	Port     int    `yaml:"port"`
+	Port     int    `yaml:"port" default:"5432"`
 	Username string `yaml:"username,omitempty"`
-	Password string `yaml:"password,omitempty"`
+	Password string `yaml:"password,omitempty" secret:"true"`
 	Name     string `yaml:"name,omitempty"`
+	// TLS mode of the connection, 'verify-ca' and 'verify-full' verify the server certificate
+	SSLMode string `yaml:"sslMode,omitempty" enum:"disable,allow,prefer,require,verify-ca,verify-full" default:"prefer"`
+	// Path to CA certificates the server certificate is verified with
+	SSLRootCert     string `yaml:"sslRootCert,omitempty"`
+	ApplicationName string `yaml:"applicationName,omitempty" default:"go-rest-template"`
+	// Timeouts in seconds, 0 statement timeout means no timeout
+	ConnectTimeout   int `yaml:"connectTimeout,omitempty" default:"10"`
+	StatementTimeout int `yaml:"statementTimeout,omitempty"`
+	// Connection pool size and connection lifetimes in seconds, 0 means pgx defaults
+	MaxConns        int `yaml:"maxConns,omitempty"`
+	MinConns        int `yaml:"minConns,omitempty"`
+	MaxConnLifetime int `yaml:"maxConnLifetime,omitempty"`
+	MaxConnIdleTime int `yaml:"maxConnIdleTime,omitempty"`
+	// Connection retries on startup, delay in seconds doubles after each retry
+	ConnectRetries       int `yaml:"connectRetries,omitempty" default:"5"`
+	ConnectRetryInterval int `yaml:"connectRetryInterval,omitempty" default:"1"`
+	// Read-only replicas data reads are balanced across, credentials and options are the primary ones
+	Replicas []Replica `yaml:"replicas,omitempty"`
+	// Interval in seconds of replica health checks, unhealthy replicas don't serve reads
+	ReplicaHealthCheckInterval int `yaml:"replicaHealthCheckInterval,omitempty" default:"10"`
+}
+
+// Replica represents a read-only database replica
+type Replica struct {
+	Host string `yaml:"host"`
+	// Primary database port is used if not set
+	Port int `yaml:"port,omitempty"`
 }
 
 // Validate performs validation of database configuration
 func (d *Database) Validate() error {
+	v := newValidator()
+	d.validate(v)
+	return v.err()
+}
+
+func (d *Database) validate(v validator) {
 	if len(d.Type) == 0 {
-		return fmt.Errorf("database type musy be non-empty string")
+		v.addf("type", "database type musy be non-empty string")
+		return
 	}
 	if strings.ToLower(d.Type) != storage.DatabaseTypeInMemory && strings.ToLower(d.Type) != storage.DatabaseTypePostgre {
-		return fmt.Errorf("unsupported database type: %s", d.Type)
+		v.addf("type", "unsupported database type: %s", d.Type)
+		return
 	}
 	if strings.ToLower(d.Type) != storage.DatabaseTypeInMemory {
 		if len(d.Host) == 0 {
-			return fmt.Errorf("host must be non-empty string")
+			v.addf("host", "host must be non-empty string")
 		}
 		if d.Port == 0 {
-			return fmt.Errorf("port must be not 0")
+			v.addf("port", "port must be not 0")
 		}
 		if len(d.Username) == 0 {
-			return fmt.Errorf("username must be non-empty string")
+			v.addf("username", "username must be non-empty string")
 		}
 		if len(d.Password) == 0 {
-			return fmt.Errorf("password must be non-empty string")
+			v.addf("password", "password must be non-empty string")
 		}
 		if len(d.Name) == 0 {
-			return fmt.Errorf("database name must be non-empty string")
+			v.addf("name", "database name must be non-empty string")
+		}
+		if len(d.SSLMode) != 0 && !contains(storage.PostgresSSLModes, d.SSLMode) {
+			v.addf("sslMode", "unsupported SSL mode: '%s', supported modes are: %s", d.SSLMode, strings.Join(storage.PostgresSSLModes, ", "))
+		}
+		if len(d.SSLRootCert) != 0 && d.SSLMode != "verify-ca" && d.SSLMode != "verify-full" {
+			v.addf("sslRootCert", "CA certificates are used only by 'verify-ca' and 'verify-full' SSL modes")
+		}
+		timeouts := []struct {
+			name  string
+			value int
+		}{
+			{"connectTimeout", d.ConnectTimeout},
+			{"statementTimeout", d.StatementTimeout},
+			{"maxConnLifetime", d.MaxConnLifetime},
+			{"maxConnIdleTime", d.MaxConnIdleTime},
+			{"connectRetryInterval", d.ConnectRetryInterval},
+		}
+		for _, t := range timeouts {
+			if t.value < 0 {
+				v.addf(t.name, "value can't be negative")
+			}
+		}
+		if d.MaxConns < 0 || d.MinConns < 0 {
+			v.addf("maxConns", "pool size can't be negative")
 		}
+		if d.MaxConns > 0 && d.MinConns > d.MaxConns {
+			v.addf("minConns", "minimal pool size can't be greater than maximal pool size")
+		}
+		if d.ConnectRetries < 0 {
+			v.addf("connectRetries", "connection retries count can't be negative")
+		}
+		if d.ReplicaHealthCheckInterval < 0 {
+			v.addf("replicaHealthCheckInterval", "value can't be negative")
+		}
+		for i, r := range d.Replicas {
+			if len(r.Host) == 0 {
+				v.addf(fmt.Sprintf("replicas[%d].host", i), "host must be non-empty string")
+			}
+			if r.Port < 0 {
+				v.addf(fmt.Sprintf("replicas[%d].port", i), "port can't be negative")
+			}
+		}
+	} else if len(d.Replicas) != 0 {
+		v.addf("replicas", "replicas are supported only by 'postgres' database")
+	}
+}
+
+// PostgresConfig returns Postgres connection parameters, relative CA path is
+// resolved relatively to the working directory on connect
+func (d *Database) PostgresConfig() storage.PostgresConfig {
+	var replicas []storage.PostgresReplica
+	for _, r := range d.Replicas {
+		replicas = append(replicas, storage.PostgresReplica{Host: r.Host, Port: r.Port})
+	}
+	return storage.PostgresConfig{
+		Host:                 d.Host,
+		Port:                 d.Port,
+		Username:             d.Username,
+		Password:             d.Password,
+		Name:                 d.Name,
+		SSLMode:              d.SSLMode,
+		SSLRootCert:          d.SSLRootCert,
+		ApplicationName:      d.ApplicationName,
+		ConnectTimeout:       time.Duration(d.ConnectTimeout) * time.Second,
+		StatementTimeout:     time.Duration(d.StatementTimeout) * time.Second,
+		MaxConns:             int32(d.MaxConns),
+		MinConns:             int32(d.MinConns),
+		MaxConnLifetime:      time.Duration(d.MaxConnLifetime) * time.Second,
+		MaxConnIdleTime:      time.Duration(d.MaxConnIdleTime) * time.Second,
+		ConnectRetries:       d.ConnectRetries,
+		ConnectRetryInterval: time.Duration(d.ConnectRetryInterval) * time.Second,
+		Replicas:             replicas,
+
+		ReplicaHealthCheckInterval: time.Duration(d.ReplicaHealthCheckInterval) * time.Second,
 	}
-	return nil
 }
 
 // Authorization represents a server authorization parameters
 type Authorization struct {
-	Type             string `yaml:"type,omitempty"`
-	SessionDuration  int    `yaml:"sessionDuration"`
-	PBKDF2Iterations int    `yaml:"pbkdf2Iterations"`
-	PBKDF2KeyLenght  int    `yaml:"pbkdf2KeyLenght"`
+	Type            string `yaml:"type,omitempty" enum:"session,token" default:"session"`
+	SessionDuration int    `yaml:"sessionDuration" default:"900"`
+	// Absolute session lifetime in seconds, 0 means no limit
+	MaxSessionLifetime int `yaml:"maxSessionLifetime"`
+	// Interval in seconds between expired sessions sweeps
+	SessionSweepInterval int `yaml:"sessionSweepInterval" default:"60"`
+	// Access and refresh token lifetimes in seconds used by 'token' type
+	AccessTokenDuration  int            `yaml:"accessTokenDuration,omitempty" default:"300"`
+	RefreshTokenDuration int            `yaml:"refreshTokenDuration,omitempty" default:"1209600"`
+	PBKDF2Iterations     int            `yaml:"pbkdf2Iterations" default:"150000"`
+	PBKDF2KeyLenght      int            `yaml:"pbkdf2KeyLenght" default:"64"`
+	PasswordPolicy       PasswordPolicy `yaml:"passwordPolicy,omitempty"`
+	// Password reset token lifetime in seconds
+	PasswordResetTokenDuration int               `yaml:"passwordResetTokenDuration" default:"3600"`
+	PasswordResetURL           string            `yaml:"passwordResetURL,omitempty"`
+	EmailVerification          EmailVerification `yaml:"emailVerification,omitempty"`
+	TwoFactor                  TwoFactor         `yaml:"twoFactor,omitempty"`
+	// Limits of failed second factor attempts and emails sent
+	RateLimits RateLimits `yaml:"rateLimits,omitempty"`
+	// Allow authentication with API keys in addition to the main type
+	APIKeys bool `yaml:"apiKeys"`
+	// Session cookie attributes used by 'session' type
+	Cookie Cookie `yaml:"cookie,omitempty"`
+	// Log in with an external OpenID Connect provider
+	OIDC OIDC `yaml:"oidc,omitempty"`
+	// Require double-submit CSRF token on state changing requests
+	// which are not authenticated with bearer token or API key
+	CSRF bool `yaml:"csrf"`
+}
+
+// TwoFactor represents TOTP second factor configuration
+type TwoFactor struct {
+	// Issuer name shown in authenticator apps
+	Issuer string `yaml:"issuer,omitempty" default:"go-rest-template"`
+	// Require admins to enroll TOTP before they can use their session
+	RequireForAdmins bool `yaml:"requireForAdmins"`
+}
+
+// RateLimits represents limits of failed second factor attempts and emails sent to an address,
+// 0 means a default value
+type RateLimits struct {
+	// Failed second factor attempts after which a pending session is revoked
+	TOTPSessionFailures int `yaml:"totpSessionFailures,omitempty" default:"5"`
+	// Failed second factor attempts of a user across pending sessions
+	TOTPUserFailures int `yaml:"totpUserFailures,omitempty" default:"20"`
+	// Period (in seconds) failed attempts are counted within
+	TOTPFailureWindow int `yaml:"totpFailureWindow,omitempty" default:"900"`
+	// Minimal interval (in seconds) between verification or password reset links sent to an email
+	EmailInterval int `yaml:"emailInterval,omitempty" default:"60"`
+}
+
+// Config returns rate limits of the handlers
+func (rl *RateLimits) Config() handler.RateLimits {
+	return handler.RateLimits{
+		TOTPSessionFailures: rl.TOTPSessionFailures,
+		TOTPUserFailures:    rl.TOTPUserFailures,
+		TOTPFailureWindow:   time.Duration(rl.TOTPFailureWindow) * time.Second,
+		EmailInterval:       time.Duration(rl.EmailInterval) * time.Second,
+	}
+}
+
+func (rl *RateLimits) validate(v validator) {
+	limits := []struct {
+		name  string
+		value int
+	}{
+		{"totpSessionFailures", rl.TOTPSessionFailures},
+		{"totpUserFailures", rl.TOTPUserFailures},
+		{"totpFailureWindow", rl.TOTPFailureWindow},
+		{"emailInterval", rl.EmailInterval},
+	}
+	for _, l := range limits {
+		if l.value < 0 {
+			v.addf(l.name, "rate limits can't be negative")
+		}
+	}
+}
+
+// DefaultSessionSweepInterval is used when session sweep interval is not set
+const DefaultSessionSweepInterval = 60
+
+// SweepInterval returns interval between expired sessions sweeps
+func (a *Authorization) SweepInterval() time.Duration {
+	if a.SessionSweepInterval == 0 {
+		return DefaultSessionSweepInterval * time.Second
+	}
+	return time.Duration(a.SessionSweepInterval) * time.Second
 }
 
 // Validate performs authorization parameters validation
 func (a *Authorization) Validate() error {
-	if len(a.Type) == 0 {
-		return fmt.Errorf("authorization type must be provided")
-	}
+	v := newValidator()
+	a.validate(v)
+	return v.err()
+}
+
+func (a *Authorization) validate(v validator) {
 	switch strings.ToLower(a.Type) {
+	case "":
+		v.addf("type", "authorization type must be provided")
 	case auth.SSMType:
 		if a.SessionDuration <= 0 {
-			return fmt.Errorf("session duration in 'session' type must be greater than 0")
+			v.addf("sessionDuration", "session duration in 'session' type must be greater than 0")
+		}
+		if a.MaxSessionLifetime < 0 {
+			v.addf("maxSessionLifetime", "maximal session lifetime can't be negative")
+		}
+	case auth.TokenType:
+		if a.AccessTokenDuration <= 0 {
+			v.addf("accessTokenDuration", "access token duration in 'token' type must be greater than 0")
+		}
+		if a.RefreshTokenDuration <= a.AccessTokenDuration {
+			v.addf("refreshTokenDuration", "refresh token duration must be greater than access token duration")
 		}
 	default:
-		return fmt.Errorf("unknown authorization type: %s", a.Type)
+		v.addf("type", "unknown authorization type: %s", a.Type)
+	}
+	if a.SessionSweepInterval < 0 {
+		v.addf("sessionSweepInterval", "session sweep interval can't be negative")
 	}
 	if a.PBKDF2Iterations <= 0 {
-		return fmt.Errorf("PBKDF2 hashing iterations count must be greater than 0")
+		v.addf("pbkdf2Iterations", "PBKDF2 hashing iterations count must be greater than 0")
 	}
 	if a.PBKDF2KeyLenght <= 0 {
-		return fmt.Errorf("PBKDF2 key lenght must be greater than 0")
+		v.addf("pbkdf2KeyLenght", "PBKDF2 key lenght must be greater than 0")
 	}
-	return nil
+	if a.PasswordResetTokenDuration <= 0 {
+		v.addf("passwordResetTokenDuration", "password reset token duration must be greater than 0")
+	}
+	a.Cookie.validate(v.at("cookie"))
+	a.PasswordPolicy.validate(v.at("passwordPolicy"))
+	a.EmailVerification.validate(v.at("emailVerification"))
+	a.OIDC.validate(v.at("oidc"))
+	a.RateLimits.validate(v.at("rateLimits"))
+}
+
+// cookieNameRegexp allows characters of an RFC 6265 cookie name token
+var cookieNameRegexp = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+
+// Cookie represents session cookie attributes
+type Cookie struct {
+	// Cookie name, default is 'SESSIONID'
+	Name   string `yaml:"name,omitempty" default:"SESSIONID"`
+	Domain string `yaml:"domain,omitempty"`
+	// Cookie path, default is '/'
+	Path string `yaml:"path,omitempty" default:"/"`
+	// SameSite attribute: 'lax' (default), 'strict' or 'none'
+	SameSite string `yaml:"sameSite,omitempty" enum:"lax,strict,none" default:"lax"`
+	// Secure attribute, default is true
+	Secure *bool `yaml:"secure,omitempty" default:"true"`
+	// Persistent cookie expires together with the session on server,
+	// otherwise it is deleted when browser is closed
+	Persistent bool `yaml:"persistent"`
+}
+
+// IsSecure reports whether cookie has Secure attribute
+func (c *Cookie) IsSecure() bool {
+	return c.Secure == nil || *c.Secure
+}
+
+// Validate performs cookie attributes validation, insecure
+// cookie is checked on the configuration level
+func (c *Cookie) Validate() error {
+	v := newValidator()
+	c.validate(v)
+	return v.err()
 }
 
-// ReadConf reads configuration
+func (c *Cookie) validate(v validator) {
+	if len(c.Name) != 0 && !cookieNameRegexp.MatchString(c.Name) {
+		v.addf("name", "cookie name '%s' contains forbidden characters", c.Name)
+	}
+	if len(c.Path) != 0 && !strings.HasPrefix(c.Path, "/") {
+		v.addf("path", "cookie path must start with '/'")
+	}
+	switch strings.ToLower(c.SameSite) {
+	case "", "lax", "strict":
+	case "none":
+		if !c.IsSecure() {
+			v.addf("sameSite", "SameSite 'none' requires Secure cookie")
+		}
+	default:
+		v.addf("sameSite", "unsupported SameSite mode: %s", c.SameSite)
+	}
+	if strings.HasPrefix(c.Name, "__Secure-") && !c.IsSecure() {
+		v.addf("name", "cookie with '__Secure-' prefix must be Secure")
+	}
+	if strings.HasPrefix(c.Name, "__Host-") {
+		if !c.IsSecure() || len(c.Domain) != 0 || (len(c.Path) != 0 && c.Path != "/") {
+			v.addf("name", "cookie with '__Host-' prefix must be Secure, have '/' path and no domain")
+		}
+	}
+}
+
+// Config returns session cookie attributes with defaults applied
+func (c *Cookie) Config() auth.CookieConfig {
+	config := auth.DefaultCookieConfig()
+	if len(c.Name) != 0 {
+		config.Name = c.Name
+	}
+	if len(c.Path) != 0 {
+		config.Path = c.Path
+	}
+	config.Domain = c.Domain
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		config.SameSite = http.SameSiteStrictMode
+	case "none":
+		config.SameSite = http.SameSiteNoneMode
+	}
+	config.Secure = c.IsSecure()
+	config.Persistent = c.Persistent
+	return config
+}
+
+// OIDC represents OpenID Connect identity provider configuration
+type OIDC struct {
+	Enabled bool `yaml:"enabled"`
+	// Provider issuer identifier, discovery document is fetched
+	// from '<issuer>/.well-known/openid-configuration'
+	Issuer       string `yaml:"issuer,omitempty"`
+	ClientID     string `yaml:"clientID,omitempty"`
+	ClientSecret string `yaml:"clientSecret,omitempty" secret:"true"`
+	// URL of '/api/oidc/callback' endpoint registered at the provider
+	RedirectURL string `yaml:"redirectURL,omitempty"`
+	// Requested scopes in addition to 'openid'
+	Scopes []string `yaml:"scopes,omitempty"`
+}
+
+// Validate performs OpenID Connect configuration validation,
+// plain HTTP issuer is checked on the configuration level
+func (o *OIDC) Validate() error {
+	v := newValidator()
+	o.validate(v)
+	return v.err()
+}
+
+func (o *OIDC) validate(v validator) {
+	if !o.Enabled {
+		return
+	}
+	if u, err := url.Parse(o.Issuer); err != nil || !u.IsAbs() || len(u.Host) == 0 {
+		v.addf("issuer", "issuer must be an absolute URL")
+	}
+	if len(o.ClientID) == 0 {
+		v.addf("clientID", "client ID must be provided")
+	}
+	if u, err := url.Parse(o.RedirectURL); err != nil || !u.IsAbs() || len(u.Host) == 0 {
+		v.addf("redirectURL", "redirect URL must be an absolute URL")
+	}
+}
+
+// minEmailVerificationKeyLength is a minimal length of a key
+// used for email verification token signing
+const minEmailVerificationKeyLength = 32
+
+// EmailVerification represents configuration of email verification on registration
+type EmailVerification struct {
+	Enabled bool `yaml:"enabled"`
+	// Key used to sign verification tokens
+	Key string `yaml:"key,omitempty" secret:"true"`
+	// Verification token lifetime in seconds
+	TokenDuration int    `yaml:"tokenDuration,omitempty" default:"86400"`
+	URL           string `yaml:"url,omitempty"`
+}
+
+// Validate performs email verification configuration validation
+func (ev *EmailVerification) Validate() error {
+	v := newValidator()
+	ev.validate(v)
+	return v.err()
+}
+
+func (ev *EmailVerification) validate(v validator) {
+	if !ev.Enabled {
+		return
+	}
+	if len(ev.Key) < minEmailVerificationKeyLength {
+		v.addf("key", "signing key must be at least %d characters long", minEmailVerificationKeyLength)
+	}
+	if ev.TokenDuration <= 0 {
+		v.addf("tokenDuration", "token duration must be greater than 0")
+	}
+	if len(ev.URL) == 0 {
+		v.addf("url", "verification URL must be provided")
+	}
+}
+
+// PasswordPolicy represents requirements for user passwords
+type PasswordPolicy struct {
+	MinLength             int    `yaml:"minLength"`
+	MaxLength             int    `yaml:"maxLength,omitempty"`
+	RequireUppercase      bool   `yaml:"requireUppercase"`
+	RequireLowercase      bool   `yaml:"requireLowercase"`
+	RequireDigit          bool   `yaml:"requireDigit"`
+	RequireSpecial        bool   `yaml:"requireSpecial"`
+	BreachedPasswordsPath string `yaml:"breachedPasswordsPath,omitempty"`
+}
+
+// Validate performs password policy validation
+func (pp *PasswordPolicy) Validate() error {
+	v := newValidator()
+	pp.validate(v)
+	return v.err()
+}
+
+func (pp *PasswordPolicy) validate(v validator) {
+	if pp.MinLength < 0 {
+		v.addf("minLength", "minimal password length can't be negative")
+	}
+	if pp.MaxLength < 0 {
+		v.addf("maxLength", "maximal password length can't be negative")
+	}
+	if pp.MaxLength > 0 && pp.MaxLength < pp.MinLength {
+		v.addf("maxLength", "maximal password length must not be less than minimal length")
+	}
+}
+
+// Policy creates an authentication password policy and loads
+// breached passwords list if it is provided
+func (pp *PasswordPolicy) Policy() (*auth.PasswordPolicy, error) {
+	policy := &auth.PasswordPolicy{
+		MinLength:        pp.MinLength,
+		MaxLength:        pp.MaxLength,
+		RequireUppercase: pp.RequireUppercase,
+		RequireLowercase: pp.RequireLowercase,
+		RequireDigit:     pp.RequireDigit,
+		RequireSpecial:   pp.RequireSpecial,
+	}
+	if len(pp.BreachedPasswordsPath) != 0 {
+		if err := policy.LoadBreachedPasswords(pp.BreachedPasswordsPath); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// Notifier represents configuration of user notifications delivery
+type Notifier struct {
+	Type string `yaml:"type" enum:"log,file,smtp" default:"log"`
+	// Used by 'file' type
+	Path string `yaml:"path,omitempty"`
+	// Used by 'smtp' type
+	Host     string `yaml:"host,omitempty"`
+	Port     int    `yaml:"port,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty" secret:"true"`
+	From     string `yaml:"from,omitempty"`
+}
+
+// Validate performs notifier configuration validation
+func (n *Notifier) Validate() error {
+	v := newValidator()
+	n.validate(v)
+	return v.err()
+}
+
+func (n *Notifier) validate(v validator) {
+	switch strings.ToLower(n.Type) {
+	case notify.NotifierTypeLog:
+	case notify.NotifierTypeFile:
+		if len(n.Path) == 0 {
+			v.addf("path", "path must be provided for 'file' notifier")
+		}
+	case notify.NotifierTypeSMTP:
+		if len(n.Host) == 0 {
+			v.addf("host", "host must be non-empty string")
+		}
+		if n.Port == 0 {
+			v.addf("port", "port must be not 0")
+		}
+		if len(n.From) == 0 {
+			v.addf("from", "sender address must be provided")
+		}
+	case "":
+		v.addf("type", "notifier type must be provided")
+	default:
+		v.addf("type", "unsupported notifier type: %s", n.Type)
+	}
+}
+
+// Notifier creates a notifier defined by the configuration
+func (n *Notifier) Notifier() notify.Notifier {
+	switch strings.ToLower(n.Type) {
+	case notify.NotifierTypeFile:
+		return &notify.FileNotifier{Path: n.Path}
+	case notify.NotifierTypeSMTP:
+		return &notify.SMTPNotifier{Host: n.Host, Port: n.Port, Username: n.Username, Password: n.Password, From: n.From}
+	default:
+		return &notify.LogNotifier{}
+	}
+}
+
+// ReadConf reads configuration, format (YAML, JSON or TOML) is detected by the file
+// extension. Fields which are not set in the file have default values.
 func ReadConf(path string) (c Conf, err error) {
 	if len(path) == 0 {
 		return c, fmt.Errorf("configuration path must be non-empty string")
@@ -119,8 +1087,25 @@ func ReadConf(path string) (c Conf, err error) {
 	if err != nil {
 		return c, fmt.Errorf("failed to read file: %v", err)
 	}
-	if err := yaml.Unmarshal(cb, &c); err != nil {
-		return c, fmt.Errorf("failed to unmarshal configuration yaml: %v", err)
+	format, err := Format(path)
+	if err != nil {
+		return c, err
+	}
+	doc, err := toYAML(cb, format)
+	if err != nil {
+		return c, fmt.Errorf("failed to unmarshal configuration %s: %v", format, err)
+	}
+	c = Default()
+	// Unknown fields and values of a wrong type are reported by Validate together with other problems
+	if c.source, err = decodeSource(doc, &c); err != nil {
+		return c, fmt.Errorf("failed to unmarshal configuration %s: %v", format, err)
+	}
+	if format == FormatTOML {
+		// Lines of the converted document don't match the file
+		c.source.withoutLines()
+	}
+	if err := resolveReferences(reflect.ValueOf(&c).Elem(), filepath.Dir(path), ""); err != nil {
+		return c, err
 	}
 	return c, nil
 }
@@ -1,12 +1,18 @@
 package conf
 
 import (
+	"crypto/tls"
+	"net/http"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/sergeikus/go-rest-template/pkg/auth"
 	"github.com/stretchr/testify/require"
 )
 
 func Test_Conf_Validate(t *testing.T) {
+	insecure := false
 	tt := []struct {
 		name     string
 		c        Conf
@@ -33,7 +39,16 @@ func Test_Conf_Validate(t *testing.T) {
 				Port: 8080,
 			},
 			fail:     true,
-			expected: "database configuration validation failed:",
+			expected: "database.type: database type musy be non-empty string",
+		},
+		{
+			name: "Unknown log level",
+			c: Conf{
+				Port:     8080,
+				LogLevel: "debug",
+			},
+			fail:     true,
+			expected: "logLevel: unknown log level: debug",
 		},
 		{
 			name: "Valid conf (tls disabled)",
@@ -42,11 +57,49 @@ func Test_Conf_Validate(t *testing.T) {
 				Port:     8080,
 				Database: Database{Type: "in-memory"},
 				Authorization: Authorization{
-					Type:             "session",
-					SessionDuration:  10,
-					PBKDF2Iterations: 1,
-					PBKDF2KeyLenght:  1,
+					Type:                       "session",
+					SessionDuration:            10,
+					PBKDF2Iterations:           1,
+					PBKDF2KeyLenght:            1,
+					PasswordResetTokenDuration: 3600,
 				},
+				Notifier: Notifier{Type: "log"},
+			},
+			fail: false,
+		},
+		{
+			name: "Insecure cookie (dev mode disabled)",
+			c: Conf{
+				Port:     8080,
+				Database: Database{Type: "in-memory"},
+				Authorization: Authorization{
+					Type:                       "session",
+					SessionDuration:            10,
+					PBKDF2Iterations:           1,
+					PBKDF2KeyLenght:            1,
+					PasswordResetTokenDuration: 3600,
+					Cookie:                     Cookie{Secure: &insecure},
+				},
+				Notifier: Notifier{Type: "log"},
+			},
+			fail:     true,
+			expected: "insecure session cookie is allowed only in 'dev' mode",
+		},
+		{
+			name: "Insecure cookie (dev mode enabled)",
+			c: Conf{
+				Port:     8080,
+				Database: Database{Type: "in-memory"},
+				Authorization: Authorization{
+					Type:                       "session",
+					SessionDuration:            10,
+					PBKDF2Iterations:           1,
+					PBKDF2KeyLenght:            1,
+					PasswordResetTokenDuration: 3600,
+					Cookie:                     Cookie{Secure: &insecure},
+				},
+				Notifier: Notifier{Type: "log"},
+				Dev:      true,
 			},
 			fail: false,
 		},
@@ -67,6 +120,22 @@ func Test_Conf_Validate(t *testing.T) {
 			fail:     true,
 			expected: "TLS certificate path must be provided",
 		},
+		{
+			name: "TLS certificate generation without TLS",
+			c: Conf{
+				TLSAutoGenerate: AutoGenerate{Enabled: true},
+			},
+			fail:     true,
+			expected: "TLS certificate generation requires TLS to be enabled",
+		},
+		{
+			name: "TLS client authentication without TLS",
+			c: Conf{
+				TLSClientAuth: ClientAuth{Mode: ClientAuthRequire, CAPath: "ca.crt", Mapping: auth.ClientCertMappingSubject},
+			},
+			fail:     true,
+			expected: "TLS client authentication requires TLS to be enabled",
+		},
 		{
 			name: "Valid conf (tls enabled)",
 			c: Conf{
@@ -76,11 +145,13 @@ func Test_Conf_Validate(t *testing.T) {
 				Port:        8080,
 				Database:    Database{Type: "in-memory"},
 				Authorization: Authorization{
-					Type:             "session",
-					SessionDuration:  10,
-					PBKDF2Iterations: 1,
-					PBKDF2KeyLenght:  1,
+					Type:                       "session",
+					SessionDuration:            10,
+					PBKDF2Iterations:           1,
+					PBKDF2KeyLenght:            1,
+					PasswordResetTokenDuration: 3600,
 				},
+				Notifier: Notifier{Type: "log"},
 			},
 			fail: false,
 		},
@@ -189,6 +260,51 @@ func Test_Database_Validate(t *testing.T) {
 			},
 			fail: false,
 		},
+		{
+			name:     "Unsupported SSL mode",
+			d:        Database{Type: "postgres", Host: "test", Port: 5432, Username: "test", Password: "test", Name: "test", SSLMode: "on"},
+			fail:     true,
+			expected: "unsupported SSL mode: 'on'",
+		},
+		{
+			name:     "CA without verification",
+			d:        Database{Type: "postgres", Host: "test", Port: 5432, Username: "test", Password: "test", Name: "test", SSLMode: "require", SSLRootCert: "ca.crt"},
+			fail:     true,
+			expected: "CA certificates are used only by 'verify-ca' and 'verify-full' SSL modes",
+		},
+		{
+			name:     "Minimal pool size greater than maximal",
+			d:        Database{Type: "postgres", Host: "test", Port: 5432, Username: "test", Password: "test", Name: "test", MaxConns: 4, MinConns: 8},
+			fail:     true,
+			expected: "minimal pool size can't be greater than maximal pool size",
+		},
+		{
+			name:     "Negative statement timeout",
+			d:        Database{Type: "postgres", Host: "test", Port: 5432, Username: "test", Password: "test", Name: "test", StatementTimeout: -1},
+			fail:     true,
+			expected: "statementTimeout: value can't be negative",
+		},
+		{
+			name:     "Replica without host",
+			d:        Database{Type: "postgres", Host: "test", Port: 5432, Username: "test", Password: "test", Name: "test", Replicas: []Replica{{Host: "replica"}, {Port: 5433}}},
+			fail:     true,
+			expected: "replicas[1].host: host must be non-empty string",
+		},
+		{
+			name:     "Replicas of in-memory database",
+			d:        Database{Type: "in-memory", Replicas: []Replica{{Host: "replica"}}},
+			fail:     true,
+			expected: "replicas: replicas are supported only by 'postgres' database",
+		},
+		{
+			name: "Valid database with TLS and pool options",
+			d: Database{
+				Type: "postgres", Host: "test", Port: 5432, Username: "test", Password: "test", Name: "test",
+				SSLMode: "verify-full", SSLRootCert: "ca.crt", MaxConns: 8, MinConns: 2, StatementTimeout: 30, ConnectRetries: 3,
+				Replicas: []Replica{{Host: "replica-1"}, {Host: "replica-2", Port: 5433}}, ReplicaHealthCheckInterval: 5,
+			},
+			fail: false,
+		},
 	}
 
 	for _, tc := range tt {
@@ -234,6 +350,44 @@ func Test_AuthorizationValidate(t *testing.T) {
 			fail:     true,
 			expected: "session duration in 'session' type must be greater than 0",
 		},
+		{
+			name: "Invalid maximal session lifetime (session)",
+			a: Authorization{
+				Type:               "session",
+				SessionDuration:    10,
+				MaxSessionLifetime: -1,
+			},
+			fail:     true,
+			expected: "maximal session lifetime can't be negative",
+		},
+		{
+			name: "Invalid session sweep interval (session)",
+			a: Authorization{
+				Type:                 "session",
+				SessionDuration:      10,
+				SessionSweepInterval: -1,
+			},
+			fail:     true,
+			expected: "session sweep interval can't be negative",
+		},
+		{
+			name: "Invalid access token duration (token)",
+			a: Authorization{
+				Type: "token",
+			},
+			fail:     true,
+			expected: "access token duration in 'token' type must be greater than 0",
+		},
+		{
+			name: "Invalid refresh token duration (token)",
+			a: Authorization{
+				Type:                 "token",
+				AccessTokenDuration:  300,
+				RefreshTokenDuration: 300,
+			},
+			fail:     true,
+			expected: "refresh token duration must be greater than access token duration",
+		},
 		{
 			name: "Invalid PBKDF2 iterations",
 			a: Authorization{
@@ -254,13 +408,102 @@ func Test_AuthorizationValidate(t *testing.T) {
 			expected: "PBKDF2 key lenght must be greater than 0",
 		},
 		{
-			name: "Valid authorization configuration (session)",
+			name: "Invalid password reset token duration",
 			a: Authorization{
 				Type:             "session",
 				SessionDuration:  10,
 				PBKDF2Iterations: 1,
 				PBKDF2KeyLenght:  1,
 			},
+			fail:     true,
+			expected: "password reset token duration must be greater than 0",
+		},
+		{
+			name: "Invalid SameSite mode",
+			a: Authorization{
+				Type:                       "session",
+				SessionDuration:            10,
+				PBKDF2Iterations:           1,
+				PBKDF2KeyLenght:            1,
+				PasswordResetTokenDuration: 3600,
+				Cookie:                     Cookie{SameSite: "sometimes"},
+			},
+			fail:     true,
+			expected: "cookie.sameSite: unsupported SameSite mode: sometimes",
+		},
+		{
+			name: "Invalid password policy",
+			a: Authorization{
+				Type:                       "session",
+				SessionDuration:            10,
+				PBKDF2Iterations:           1,
+				PBKDF2KeyLenght:            1,
+				PasswordResetTokenDuration: 3600,
+				PasswordPolicy:             PasswordPolicy{MinLength: 10, MaxLength: 8},
+			},
+			fail:     true,
+			expected: "passwordPolicy.maxLength: maximal password length must not be less than minimal length",
+		},
+		{
+			name: "Invalid email verification",
+			a: Authorization{
+				Type:                       "session",
+				SessionDuration:            10,
+				PBKDF2Iterations:           1,
+				PBKDF2KeyLenght:            1,
+				PasswordResetTokenDuration: 3600,
+				EmailVerification:          EmailVerification{Enabled: true, Key: "short"},
+			},
+			fail:     true,
+			expected: "emailVerification.key: signing key must be at least 32 characters long",
+		},
+		{
+			name: "Invalid OpenID Connect",
+			a: Authorization{
+				Type:                       "session",
+				SessionDuration:            10,
+				PBKDF2Iterations:           1,
+				PBKDF2KeyLenght:            1,
+				PasswordResetTokenDuration: 3600,
+				OIDC:                       OIDC{Enabled: true, Issuer: "https://idp.example.com"},
+			},
+			fail:     true,
+			expected: "oidc.clientID: client ID must be provided",
+		},
+		{
+			name: "Valid authorization configuration (session)",
+			a: Authorization{
+				Type:                       "session",
+				SessionDuration:            10,
+				PBKDF2Iterations:           1,
+				PBKDF2KeyLenght:            1,
+				PasswordResetTokenDuration: 3600,
+			},
+			fail: false,
+		},
+		{
+			name: "Negative rate limit",
+			a: Authorization{
+				Type:                       "session",
+				SessionDuration:            600,
+				PBKDF2Iterations:           1,
+				PBKDF2KeyLenght:            1,
+				PasswordResetTokenDuration: 3600,
+				RateLimits:                 RateLimits{EmailInterval: -1},
+			},
+			fail:     true,
+			expected: "rateLimits.emailInterval: rate limits can't be negative",
+		},
+		{
+			name: "Valid authorization configuration (token)",
+			a: Authorization{
+				Type:                       "token",
+				AccessTokenDuration:        300,
+				RefreshTokenDuration:       3600,
+				PBKDF2Iterations:           1,
+				PBKDF2KeyLenght:            1,
+				PasswordResetTokenDuration: 3600,
+			},
 			fail: false,
 		},
 	}
@@ -278,6 +521,301 @@ func Test_AuthorizationValidate(t *testing.T) {
 	}
 }
 
+func Test_OIDC_Validate(t *testing.T) {
+	tt := []struct {
+		name     string
+		o        OIDC
+		fail     bool
+		expected string
+	}{
+		{name: "Disabled", o: OIDC{}, fail: false},
+		{name: "Invalid issuer", o: OIDC{Enabled: true, Issuer: "idp.example.com"}, fail: true, expected: "issuer must be an absolute URL"},
+		{name: "No client ID", o: OIDC{Enabled: true, Issuer: "https://idp.example.com"}, fail: true, expected: "client ID must be provided"},
+		{name: "Invalid redirect URL", o: OIDC{Enabled: true, Issuer: "https://idp.example.com", ClientID: "client", RedirectURL: "/callback"}, fail: true, expected: "redirect URL must be an absolute URL"},
+		{name: "Valid configuration", o: OIDC{Enabled: true, Issuer: "https://idp.example.com", ClientID: "client", RedirectURL: "https://localhost/api/oidc/callback"}, fail: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.o.Validate()
+			if tc.fail {
+				require.NotNil(t, err, "expected to see an error, but got nil")
+				require.Contains(t, err.Error(), tc.expected, "expected to see a different error")
+			} else {
+				require.NoError(t, err, "expected to get no error, but got: %v", err)
+			}
+		})
+	}
+}
+
+func Test_Conf_Listeners(t *testing.T) {
+	https := Listener{Address: ":8443", TLS: true}
+	tt := []struct {
+		name      string
+		tls       bool
+		listeners []Listener
+		fail      bool
+		expected  string
+	}{
+		{name: "No address", listeners: []Listener{{}}, fail: true, expected: "listeners[0].address: address must be provided"},
+		{name: "Unsupported network", listeners: []Listener{{Network: "udp", Address: ":53"}}, fail: true, expected: "unsupported network: 'udp'"},
+		{name: "TCP address without port", listeners: []Listener{{Address: "localhost"}}, fail: true, expected: "must be in 'host:port' format"},
+		{name: "Socket mode for TCP", listeners: []Listener{{Address: ":8080", SocketMode: "0600"}}, fail: true, expected: "socket mode can be set only"},
+		{name: "Invalid socket mode", listeners: []Listener{{Network: "unix", Address: "/tmp/server.sock", SocketMode: "999"}}, fail: true, expected: "invalid socket mode: '999'"},
+		{name: "HTTPS without TLS", listeners: []Listener{https}, fail: true, expected: "TLS must be enabled to serve HTTPS"},
+		{name: "Redirect with TLS", tls: true, listeners: []Listener{{Address: ":8080", TLS: true, RedirectToHTTPS: true}}, fail: true, expected: "can't serve TLS"},
+		{name: "Redirect without HTTPS listener", tls: true, listeners: []Listener{{Address: ":8080", RedirectToHTTPS: true}}, fail: true, expected: "TCP TLS listener is required"},
+		{name: "Duplicate address", tls: true, listeners: []Listener{https, https}, fail: true, expected: "listeners[1].address: address ':8443' is used more than once"},
+		{
+			name:      "Valid listeners",
+			tls:       true,
+			listeners: []Listener{{Address: ":8080", RedirectToHTTPS: true}, https, {Network: "unix", Address: "/tmp/server.sock", SocketMode: "0600"}},
+			fail:      false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Conf{TLS: tc.tls, Listeners: tc.listeners}
+			v := newValidator()
+			c.validateListeners(v.at("listeners"))
+			err := v.err()
+			if tc.fail {
+				require.NotNil(t, err, "expected to see an error, but got nil")
+				require.Contains(t, err.Error(), tc.expected, "expected to see a different error")
+			} else {
+				require.NoError(t, err, "expected to get no error, but got: %v", err)
+			}
+		})
+	}
+
+	// Single listener on 'port' is used if none are configured
+	c := Conf{TLS: true, Port: 8443}
+	require.Equal(t, []Listener{{Network: NetworkTCP, Address: ":8443", TLS: true}}, c.ServerListeners())
+	port, exist := c.HTTPSPort()
+	require.True(t, exist)
+	require.Equal(t, 8443, port)
+
+	l := Listener{Network: "unix", Address: "/tmp/server.sock"}
+	require.Equal(t, os.FileMode(0660), l.GetSocketMode(), "expected default socket mode")
+	l.SocketMode = "0600"
+	require.Equal(t, os.FileMode(0600), l.GetSocketMode())
+}
+
+func Test_Server(t *testing.T) {
+	tt := []struct {
+		name     string
+		s        Server
+		fail     bool
+		expected string
+	}{
+		{name: "Negative timeout", s: Server{WriteTimeout: -1}, fail: true, expected: "timeouts can't be negative"},
+		{name: "Negative max header bytes", s: Server{MaxHeaderBytes: -1}, fail: true, expected: "max header bytes can't be negative"},
+		{name: "Negative max body bytes", s: Server{MaxBodyBytes: -1}, fail: true, expected: "max body bytes can't be negative"},
+		{name: "Invalid route", s: Server{RouteMaxBodyBytes: map[string]int64{"api/login": 1024}}, fail: true, expected: "route 'api/login' must start with '/'"},
+		{name: "Invalid route limit", s: Server{RouteMaxBodyBytes: map[string]int64{"/api/login": 0}}, fail: true, expected: "must be a positive number"},
+		{name: "Defaults", s: Server{}, fail: false},
+		{name: "Valid configuration", s: Server{ReadTimeout: 5, MaxBodyBytes: 4096, RouteMaxBodyBytes: map[string]int64{"/api/data/store": 65536}}, fail: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.s.Validate()
+			if tc.fail {
+				require.NotNil(t, err, "expected to see an error, but got nil")
+				require.Contains(t, err.Error(), tc.expected, "expected to see a different error")
+			} else {
+				require.NoError(t, err, "expected to get no error, but got: %v", err)
+			}
+		})
+	}
+
+	srv := &http.Server{}
+	s := Server{WriteTimeout: 5}
+	s.Apply(srv)
+	require.Equal(t, 5*time.Second, srv.WriteTimeout)
+	require.Equal(t, 30*time.Second, srv.ReadTimeout, "expected default read timeout")
+	require.Equal(t, 1<<20, srv.MaxHeaderBytes, "expected default max header bytes")
+	require.Equal(t, int64(1<<20), s.GetMaxBodyBytes(), "expected default max body bytes")
+}
+
+func Test_ClientAuth_Validate(t *testing.T) {
+	tt := []struct {
+		name     string
+		ca       ClientAuth
+		fail     bool
+		expected string
+	}{
+		{name: "Disabled", ca: ClientAuth{}, fail: false},
+		{name: "Unsupported mode", ca: ClientAuth{Mode: "optional"}, fail: true, expected: "unsupported mode: 'optional'"},
+		{name: "No CA path", ca: ClientAuth{Mode: ClientAuthRequire}, fail: true, expected: "CA path must be provided"},
+		{name: "Unsupported mapping", ca: ClientAuth{Mode: ClientAuthRequire, CAPath: "ca.crt", Mapping: "uri"}, fail: true, expected: "unsupported mapping: 'uri'"},
+		{name: "No scopes", ca: ClientAuth{Mode: ClientAuthRequire, CAPath: "ca.crt", Mapping: auth.ClientCertMappingEmail}, fail: true, expected: "scopes of certificate sessions must be provided"},
+		{name: "Account scope", ca: ClientAuth{Mode: ClientAuthRequire, CAPath: "ca.crt", Mapping: auth.ClientCertMappingEmail, Scopes: []string{auth.ScopeAccount}}, fail: true, expected: "scopes[0]: unsupported scope: 'account'"},
+		{name: "Valid configuration", ca: ClientAuth{Mode: ClientAuthVerifyIfGiven, CAPath: "ca.crt", Mapping: auth.ClientCertMappingEmail, Scopes: []string{auth.ScopeDataRead}}, fail: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.ca.Validate()
+			if tc.fail {
+				require.NotNil(t, err, "expected to see an error, but got nil")
+				require.Contains(t, err.Error(), tc.expected, "expected to see a different error")
+			} else {
+				require.NoError(t, err, "expected to get no error, but got: %v", err)
+			}
+		})
+	}
+}
+
+func Test_TLSOptions_Config(t *testing.T) {
+	tt := []struct {
+		name     string
+		o        TLSOptions
+		fail     bool
+		expected string
+	}{
+		{name: "Unsupported version", o: TLSOptions{MinVersion: "1.1"}, fail: true, expected: "unsupported minimal version: '1.1'"},
+		{name: "Unknown cipher suite", o: TLSOptions{CipherSuites: []string{"TLS_UNKNOWN"}}, fail: true, expected: "unsupported cipher suite: 'TLS_UNKNOWN'"},
+		{name: "Insecure cipher suite", o: TLSOptions{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, fail: true, expected: "is insecure"},
+		{name: "Cipher suites with TLS 1.3", o: TLSOptions{MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}, fail: true, expected: "can't be configured for TLS 1.3"},
+		{name: "HTTP/2 without required cipher suite", o: TLSOptions{CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}}, fail: true, expected: "HTTP/2 requires"},
+		{name: "Cipher suites without HTTP/2", o: TLSOptions{CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}, ALPN: []string{"http/1.1"}}, fail: false},
+		{name: "Unsupported curve", o: TLSOptions{CurvePreferences: []string{"P224"}}, fail: true, expected: "unsupported curve: 'P224'"},
+		{name: "Unsupported ALPN protocol", o: TLSOptions{ALPN: []string{"h3"}}, fail: true, expected: "unsupported ALPN protocol: 'h3'"},
+		{name: "Negative reload interval", o: TLSOptions{ReloadInterval: -1}, fail: true, expected: "reload interval can't be negative"},
+		{name: "Defaults", o: TLSOptions{}, fail: false},
+		{name: "Valid options", o: TLSOptions{MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, CurvePreferences: []string{"X25519", "P256"}, ALPN: []string{"http/1.1"}, ReloadInterval: 60}, fail: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.o.Validate()
+			if tc.fail {
+				require.NotNil(t, err, "expected to see an error, but got nil")
+				require.Contains(t, err.Error(), tc.expected, "expected to see a different error")
+			} else {
+				require.NoError(t, err, "expected to get no error, but got: %v", err)
+			}
+		})
+	}
+
+	cfg, err := (&TLSOptions{MinVersion: "1.3", CurvePreferences: []string{"X25519"}, ALPN: []string{"http/1.1"}}).Config()
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+	require.Equal(t, []tls.CurveID{tls.X25519}, cfg.CurvePreferences)
+	require.Equal(t, []string{"http/1.1"}, cfg.NextProtos)
+
+	cfg, err = (&TLSOptions{}).Config()
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion, "TLS 1.2 must be the default minimal version")
+	require.Equal(t, []string{"h2", "http/1.1"}, cfg.NextProtos)
+}
+
+func Test_Cookie_Validate(t *testing.T) {
+	insecure := false
+	tt := []struct {
+		name     string
+		c        Cookie
+		fail     bool
+		expected string
+	}{
+		{name: "Defaults", c: Cookie{}, fail: false},
+		{name: "Invalid name", c: Cookie{Name: "SESSION ID"}, fail: true, expected: "cookie name 'SESSION ID' contains forbidden characters"},
+		{name: "Invalid path", c: Cookie{Path: "api"}, fail: true, expected: "cookie path must start with '/'"},
+		{name: "Unknown SameSite", c: Cookie{SameSite: "sometimes"}, fail: true, expected: "unsupported SameSite mode: sometimes"},
+		{name: "SameSite none without Secure", c: Cookie{SameSite: "none", Secure: &insecure}, fail: true, expected: "SameSite 'none' requires Secure cookie"},
+		{name: "Secure prefix without Secure", c: Cookie{Name: "__Secure-SID", Secure: &insecure}, fail: true, expected: "cookie with '__Secure-' prefix must be Secure"},
+		{name: "Host prefix with domain", c: Cookie{Name: "__Host-SID", Domain: "example.com"}, fail: true, expected: "cookie with '__Host-' prefix must be Secure, have '/' path and no domain"},
+		{name: "Host prefix", c: Cookie{Name: "__Host-SID", Path: "/"}, fail: false},
+		{name: "Valid cookie", c: Cookie{Name: "SID", Domain: "example.com", Path: "/api", SameSite: "Strict", Persistent: true}, fail: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.c.Validate()
+			if tc.fail {
+				require.NotNil(t, err, "expected to see an error, but got nil")
+				require.Contains(t, err.Error(), tc.expected, "expected to see a different error")
+			} else {
+				require.NoError(t, err, "expected to get no error, but got: %v", err)
+			}
+		})
+	}
+}
+
+func Test_Cookie_Config(t *testing.T) {
+	insecure := false
+	config := (&Cookie{}).Config()
+	require.Equal(t, auth.DefaultCookieConfig(), config, "expected to see default cookie attributes")
+
+	config = (&Cookie{Name: "SID", Domain: "example.com", Path: "/api", SameSite: "strict", Secure: &insecure, Persistent: true}).Config()
+	require.Equal(t, auth.CookieConfig{
+		Name:       "SID",
+		Domain:     "example.com",
+		Path:       "/api",
+		SameSite:   http.SameSiteStrictMode,
+		Secure:     false,
+		Persistent: true,
+	}, config)
+}
+
+func Test_Notifier_Validate(t *testing.T) {
+	tt := []struct {
+		name     string
+		n        Notifier
+		fail     bool
+		expected string
+	}{
+		{
+			name:     "Empty type",
+			n:        Notifier{},
+			fail:     true,
+			expected: "notifier type must be provided",
+		},
+		{
+			name:     "Unknown type",
+			n:        Notifier{Type: "pigeon"},
+			fail:     true,
+			expected: "unsupported notifier type:",
+		},
+		{
+			name:     "File notifier without path",
+			n:        Notifier{Type: "file"},
+			fail:     true,
+			expected: "path must be provided for 'file' notifier",
+		},
+		{
+			name:     "SMTP notifier without sender",
+			n:        Notifier{Type: "smtp", Host: "localhost", Port: 25},
+			fail:     true,
+			expected: "sender address must be provided",
+		},
+		{
+			name: "Valid SMTP notifier",
+			n:    Notifier{Type: "smtp", Host: "localhost", Port: 25, From: "noreply@example.com"},
+			fail: false,
+		},
+		{
+			name: "Valid log notifier",
+			n:    Notifier{Type: "log"},
+			fail: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.n.Validate()
+			if tc.fail {
+				require.NotNil(t, err, "expected to see an error, but got nil")
+				require.Contains(t, err.Error(), tc.expected, "expected to see a different error")
+			} else {
+				require.NoError(t, err, "expected to get no error, but got: %v", err)
+			}
+		})
+	}
+}
+
 const (
 	testFolder        = "../../test/"
 	testConfigsFolder = testFolder + "configs/"
@@ -319,12 +857,14 @@ func Test_ReadConf(t *testing.T) {
 
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := ReadConf(tc.path)
+			c, err := ReadConf(tc.path)
 			if tc.fail {
 				require.NotNil(t, err, "expected to see an error, but got nil")
 				require.Contains(t, err.Error(), tc.expected, "expected to see a different error")
 			} else {
 				require.NoError(t, err, "expected to get no error, but got: %v", err)
+				// Good configurations must match the current schema
+				require.NoError(t, c.Validate(), "expected configuration to be valid")
 			}
 		})
 	}
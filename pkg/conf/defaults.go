@@ -0,0 +1,130 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Default returns configuration with default values of optional fields,
+// defaults are defined by 'default' field tags (lists are comma-separated)
+func Default() Conf {
+	var c Conf
+	if err := setDefaults(reflect.ValueOf(&c).Elem()); err != nil {
+		// Default tags are fixed, so an invalid one is a programming error
+		panic(err)
+	}
+	return c
+}
+
+// setDefaults sets default values of the fields which are not set,
+// fields of list items (e.g. listeners) are not changed
+func setDefaults(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) != 0 {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := setDefaults(field); err != nil {
+				return err
+			}
+			continue
+		}
+		value, exist := f.Tag.Lookup("default")
+		if !exist || !field.IsZero() {
+			continue
+		}
+		if err := setEnvValue(field, value, "."); err != nil {
+			return fmt.Errorf("invalid default value of '%s' field: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// schema represents a JSON Schema of a configuration field
+type schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+}
+
+// Schema returns JSON Schema of configuration, it describes every format
+// as the field names are the same
+func Schema() ([]byte, error) {
+	s, err := typeSchema(reflect.TypeOf(Conf{}))
+	if err != nil {
+		return nil, err
+	}
+	s.Schema = "http://json-schema.org/draft-07/schema#"
+	s.Title = "go-rest-template server configuration"
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func typeSchema(t reflect.Type) (*schema, error) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return &schema{Type: "string"}, nil
+	case reflect.Bool:
+		return &schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}, nil
+	case reflect.Slice:
+		items, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		values, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		s := &schema{Type: "object", Properties: make(map[string]*schema), AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if len(name) == 0 || name == "-" || len(f.PkgPath) != 0 {
+				continue
+			}
+			fs, err := typeSchema(f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("'%s': %v", name, err)
+			}
+			if enum, exist := f.Tag.Lookup("enum"); exist {
+				fs.Enum = strings.Split(enum, ",")
+			}
+			if value, exist := f.Tag.Lookup("default"); exist {
+				dv := reflect.New(f.Type).Elem()
+				if err := setEnvValue(dv, value, "."); err != nil {
+					return nil, fmt.Errorf("invalid default value of '%s': %v", name, err)
+				}
+				for dv.Kind() == reflect.Ptr {
+					dv = dv.Elem()
+				}
+				fs.Default = dv.Interface()
+			}
+			s.Properties[name] = fs
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported field type: %s", t)
+	}
+}
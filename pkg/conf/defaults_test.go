@@ -0,0 +1,100 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/certs"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Default(t *testing.T) {
+	c := Default()
+	// Default tags must match the values used if a field is 0
+	var empty Conf
+	require.Equal(t, empty.Effective().Server, c.Server)
+	require.Equal(t, DefaultSessionSweepInterval*time.Second, c.Authorization.SweepInterval())
+	require.Equal(t, auth.DefaultCookieConfig(), c.Authorization.Cookie.Config())
+	require.Equal(t, certs.DefaultHosts, c.TLSAutoGenerate.Hosts)
+	require.Equal(t, defaultCertValidDays, c.TLSAutoGenerate.ValidDays)
+	require.Equal(t, tlsProtocols, c.TLSOptions.ALPN)
+	var l Listener
+	require.NoError(t, setDefaults(reflect.ValueOf(&l).Elem()))
+	require.Equal(t, NetworkTCP, l.Network)
+	require.Equal(t, os.FileMode(defaultSocketMode), l.GetSocketMode())
+
+	// Only database type is required in a minimal configuration
+	c.Database.Type = "in-memory"
+	require.NoError(t, c.Validate(), "expected default configuration to be valid")
+}
+
+func Test_ReadConf_Formats(t *testing.T) {
+	expected, err := ReadConf(goodConfFolder + "config-format.yaml")
+	require.NoError(t, err, "expected to get no error, but got: %v", err)
+	require.Equal(t, 9443, expected.Port)
+	require.Equal(t, "token", expected.Authorization.Type)
+	require.Equal(t, 150000, expected.Authorization.PBKDF2Iterations, "expected a default value")
+	require.Len(t, expected.Listeners, 2)
+	expected.source = nil
+
+	for _, path := range []string{"config-format.json", "config-format.toml"} {
+		t.Run(path, func(t *testing.T) {
+			c, err := ReadConf(goodConfFolder + path)
+			require.NoError(t, err, "expected to get no error, but got: %v", err)
+			require.NoError(t, c.Validate(), "expected configuration to be valid")
+			c.source = nil
+			require.Equal(t, expected, c)
+		})
+	}
+
+	_, err = ReadConf(badConfFolder + "config-format.json")
+	require.Error(t, err, "expected to see an error, but got nil")
+	require.Contains(t, err.Error(), "failed to unmarshal configuration json:")
+
+	_, err = ReadConf(goodConfFolder + "secrets/db_password")
+	require.Error(t, err, "expected to see an error, but got nil")
+	require.Contains(t, err.Error(), "unsupported configuration file extension: ''")
+}
+
+func Test_ReadConf_FieldProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tt := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{name: "config.json", content: "{\n  \"port\": 8080,\n  \"databse\": {}\n}\n", expected: "databse (line 3): unknown field"},
+		// Lines are not known for TOML
+		{name: "config.toml", content: "port = 8080\n[databse]\n", expected: "databse: unknown field"},
+		{name: "config.yaml", content: "port: abc\ndatabse: {}\n", expected: "databse (line 2): unknown field; port (line 1): cannot unmarshal !!str `abc` into int"},
+		{name: "config.yml", content: "port: 8080\nserver:\n  readTimeout: [30]\n", expected: "server.readTimeout (line 3): cannot unmarshal !!seq into int"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name)
+			require.NoError(t, ioutil.WriteFile(path, []byte(tc.content), 0600))
+			c, err := ReadConf(path)
+			require.NoError(t, err, "expected to get no error, but got: %v", err)
+			err = c.Validate()
+			require.Error(t, err, "expected to see an error, but got nil")
+			require.Contains(t, err.Error(), tc.expected)
+		})
+	}
+}
+
+func Test_Schema(t *testing.T) {
+	schema, err := Schema()
+	require.NoError(t, err, "expected to get no error, but got: %v", err)
+	// Schema in configs is generated with 'config schema' command
+	committed, err := ioutil.ReadFile("../../configs/config.schema.json")
+	require.NoError(t, err, "failed to read schema: %v", err)
+	require.Equal(t, string(committed), string(schema), "'configs/config.schema.json' is outdated, regenerate it")
+}
@@ -0,0 +1,52 @@
+package conf
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/logging"
+)
+
+// Effective returns configuration the server runs with: values which are
+// resolved when the server starts (listeners, 0 timeouts and limits replaced by
+// built-in values etc.) are set, other configured values are kept as is
+func (c Conf) Effective() Conf {
+	c.Listeners = append([]Listener(nil), c.ServerListeners()...)
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		l.Network = l.GetNetwork()
+		if l.Network == NetworkUnix {
+			l.SocketMode = fmt.Sprintf("%04o", l.GetSocketMode())
+		}
+	}
+
+	var srv http.Server
+	c.Server.Apply(&srv)
+	c.Server.ReadHeaderTimeout = int(srv.ReadHeaderTimeout / time.Second)
+	c.Server.ReadTimeout = int(srv.ReadTimeout / time.Second)
+	c.Server.WriteTimeout = int(srv.WriteTimeout / time.Second)
+	c.Server.IdleTimeout = int(srv.IdleTimeout / time.Second)
+	c.Server.MaxHeaderBytes = srv.MaxHeaderBytes
+	c.Server.MaxBodyBytes = c.Server.GetMaxBodyBytes()
+
+	if len(c.LogLevel) == 0 {
+		c.LogLevel = logging.LevelInfo
+	}
+
+	c.TLSAutoGenerate.ValidDays = int(c.TLSAutoGenerate.ValidFor() / (24 * time.Hour))
+	if len(c.TLSOptions.MinVersion) == 0 {
+		c.TLSOptions.MinVersion = "1.2"
+	}
+	if len(c.TLSOptions.ALPN) == 0 {
+		c.TLSOptions.ALPN = append([]string(nil), tlsProtocols...)
+	}
+
+	c.Authorization.SessionSweepInterval = int(c.Authorization.SweepInterval() / time.Second)
+	cookie := c.Authorization.Cookie.Config()
+	c.Authorization.Cookie.Name = cookie.Name
+	c.Authorization.Cookie.Path = cookie.Path
+	secure := cookie.Secure
+	c.Authorization.Cookie.Secure = &secure
+	return c
+}
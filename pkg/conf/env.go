@@ -0,0 +1,135 @@
+package conf
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is a prefix of environment variables which override configuration,
+// variable name is built from YAML keys, e.g. 'APP_DATABASE_PASSWORD'
+const EnvPrefix = "APP"
+
+// ApplyEnv overrides configuration fields with environment variables returned by lookup
+// (e.g. os.LookupEnv), values may be 'file:' or 'env:' references, relative 'file:' references
+// are resolved relatively to baseDir. Lists are comma-separated ('a,b'), maps are comma-separated
+// 'key=value' pairs, lists of objects (e.g. 'listeners') can't be overridden.
+func (c *Conf) ApplyEnv(lookup func(key string) (string, bool), baseDir string) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, lookup, baseDir)
+}
+
+func applyEnv(v reflect.Value, prefix string, lookup func(key string) (string, bool), baseDir string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, ok := envKey(t.Field(i), prefix)
+		if !ok {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, key, lookup, baseDir); err != nil {
+				return err
+			}
+			continue
+		}
+		value, exist := lookup(key)
+		if !exist {
+			continue
+		}
+		if err := setEnvValue(field, value, baseDir); err != nil {
+			return fmt.Errorf("invalid '%s' environment variable: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// envKey returns environment variable name of a field with YAML key
+func envKey(f reflect.StructField, prefix string) (string, bool) {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if len(name) == 0 || name == "-" || len(f.PkgPath) != 0 {
+		return "", false
+	}
+	return prefix + "_" + strings.ToUpper(name), true
+}
+
+// setEnvValue converts environment variable value to the field type,
+// relative file references are resolved relatively to baseDir
+func setEnvValue(field reflect.Value, value, baseDir string) error {
+	switch field.Kind() {
+	case reflect.String:
+		resolved, err := resolveReference(value, baseDir)
+		if err != nil {
+			return err
+		}
+		field.SetString(resolved)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean, expected 'true' or 'false'", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not an integer or is out of range", value)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not a non-negative integer or is out of range", value)
+		}
+		field.SetUint(n)
+	case reflect.Ptr:
+		elem := reflect.New(field.Type().Elem())
+		if err := setEnvValue(elem.Elem(), value, baseDir); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.Struct {
+			return fmt.Errorf("list of objects can't be set with environment variable")
+		}
+		items := splitEnvList(value)
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setEnvValue(slice.Index(i), item, baseDir); err != nil {
+				return fmt.Errorf("item #%d: %v", i+1, err)
+			}
+		}
+		field.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(field.Type())
+		for _, pair := range splitEnvList(value) {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("'%s' is not a 'key=value' pair", pair)
+			}
+			k := reflect.New(field.Type().Key()).Elem()
+			if err := setEnvValue(k, strings.TrimSpace(kv[0]), baseDir); err != nil {
+				return fmt.Errorf("key '%s': %v", kv[0], err)
+			}
+			v := reflect.New(field.Type().Elem()).Elem()
+			if err := setEnvValue(v, strings.TrimSpace(kv[1]), baseDir); err != nil {
+				return fmt.Errorf("value of '%s' key: %v", kv[0], err)
+			}
+			m.SetMapIndex(k, v)
+		}
+		field.Set(m)
+	default:
+		return fmt.Errorf("unsupported field type: %s", field.Type())
+	}
+	return nil
+}
+
+// splitEnvList splits comma-separated list, empty items are dropped
+func splitEnvList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) != 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,60 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Conf_ApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"APP_PORT":                                   "9443",
+		"APP_DATABASE_PASSWORD":                      "secret",
+		"APP_AUTHORIZATION_SESSIONDURATION":          "600",
+		"APP_AUTHORIZATION_APIKEYS":                  "true",
+		"APP_AUTHORIZATION_COOKIE_SECURE":            "false",
+		"APP_AUTHORIZATION_OIDC_SCOPES":              "email, profile",
+		"APP_SERVER_ROUTEMAXBODYBYTES":               "/api/login=4096, /api/data/store=65536",
+		"APP_AUTHORIZATION_PASSWORDPOLICY_MINLENGTH": "12",
+	}
+	c := Conf{Port: 8443, Database: Database{Type: "postgres", Password: "file"}}
+	err := c.ApplyEnv(func(key string) (string, bool) {
+		v, exist := env[key]
+		return v, exist
+	}, ".")
+	require.NoError(t, err, "expected to get no error, but got: %v", err)
+	require.Equal(t, 9443, c.Port)
+	require.Equal(t, "postgres", c.Database.Type, "field without variable must be kept")
+	require.Equal(t, "secret", c.Database.Password)
+	require.Equal(t, 600, c.Authorization.SessionDuration)
+	require.True(t, c.Authorization.APIKeys)
+	require.NotNil(t, c.Authorization.Cookie.Secure)
+	require.False(t, *c.Authorization.Cookie.Secure)
+	require.Equal(t, []string{"email", "profile"}, c.Authorization.OIDC.Scopes)
+	require.Equal(t, map[string]int64{"/api/login": 4096, "/api/data/store": 65536}, c.Server.RouteMaxBodyBytes)
+	require.Equal(t, 12, c.Authorization.PasswordPolicy.MinLength)
+
+	tt := []struct {
+		name     string
+		key      string
+		value    string
+		expected string
+	}{
+		{name: "Invalid integer", key: "APP_PORT", value: "abc", expected: "invalid 'APP_PORT' environment variable: 'abc' is not an integer"},
+		{name: "Invalid boolean", key: "APP_TLS", value: "yes", expected: "invalid 'APP_TLS' environment variable: 'yes' is not a boolean"},
+		{name: "Invalid map", key: "APP_SERVER_ROUTEMAXBODYBYTES", value: "/api/login", expected: "'/api/login' is not a 'key=value' pair"},
+		{name: "Invalid map value", key: "APP_SERVER_ROUTEMAXBODYBYTES", value: "/api/login=large", expected: "value of '/api/login' key: 'large' is not an integer"},
+		{name: "List of objects", key: "APP_LISTENERS", value: ":8443", expected: "list of objects can't be set"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var c Conf
+			err := c.ApplyEnv(func(key string) (string, bool) {
+				return tc.value, key == tc.key
+			}, ".")
+			require.NotNil(t, err, "expected to see an error, but got nil")
+			require.Contains(t, err.Error(), tc.expected, "expected to see a different error")
+		})
+	}
+}
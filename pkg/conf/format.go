@@ -0,0 +1,62 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FormatYAML is a YAML configuration ('.yaml', '.yml')
+	FormatYAML = "yaml"
+	// FormatJSON is a JSON configuration ('.json')
+	FormatJSON = "json"
+	// FormatTOML is a TOML configuration ('.toml')
+	FormatTOML = "toml"
+)
+
+// formats maps configuration file extensions to formats
+var formats = map[string]string{
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+	".json": FormatJSON,
+	".toml": FormatTOML,
+}
+
+// Format returns configuration format detected by the file extension
+func Format(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	format, exist := formats[ext]
+	if !exist {
+		return "", fmt.Errorf("unsupported configuration file extension: '%s', supported extensions are: '.yaml', '.yml', '.json', '.toml'", ext)
+	}
+	return format, nil
+}
+
+// toYAML converts configuration document to YAML, so the same field names
+// (YAML keys) are used in every format
+func toYAML(data []byte, format string) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		return data, nil
+	case FormatJSON:
+		// JSON is a subset of YAML, it is parsed only to report JSON syntax errors
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return data, nil
+	case FormatTOML:
+		var v map[string]interface{}
+		if _, err := toml.Decode(string(data), &v); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(v)
+	default:
+		return nil, fmt.Errorf("unsupported configuration format: '%s'", format)
+	}
+}
@@ -0,0 +1,68 @@
+package conf
+
+import (
+	"reflect"
+	"strings"
+)
+
+// liveSettings lists configuration fields (YAML paths) which are applied
+// without restart when configuration is reloaded. Server timeouts and header
+// size limit are applied to new connections, the current ones keep the previous values.
+var liveSettings = map[string]bool{
+	"logLevel":                                     true,
+	"authorization.sessionDuration":                true,
+	"authorization.maxSessionLifetime":             true,
+	"authorization.accessTokenDuration":            true,
+	"authorization.refreshTokenDuration":           true,
+	"authorization.rateLimits.totpSessionFailures": true,
+	"authorization.rateLimits.totpUserFailures":    true,
+	"authorization.rateLimits.totpFailureWindow":   true,
+	"authorization.rateLimits.emailInterval":       true,
+	"server.readHeaderTimeout":                     true,
+	"server.readTimeout":                           true,
+	"server.writeTimeout":                          true,
+	"server.idleTimeout":                           true,
+	"server.maxHeaderBytes":                        true,
+	"server.maxBodyBytes":                          true,
+	"server.routeMaxBodyBytes":                     true,
+}
+
+// IsLive reports whether the field (YAML path, e.g. 'server.maxBodyBytes')
+// is applied without restart
+func IsLive(path string) bool {
+	return liveSettings[path]
+}
+
+// Diff returns YAML paths of the fields changed between two configurations,
+// split into the ones applied live and the ones which require restart
+func Diff(old, new Conf) (live, restart []string) {
+	for _, path := range diff(reflect.ValueOf(old), reflect.ValueOf(new), "") {
+		if IsLive(path) {
+			live = append(live, path)
+		} else {
+			restart = append(restart, path)
+		}
+	}
+	return live, restart
+}
+
+// diff compares structs field by field, other values (including lists and maps) are compared as a whole
+func diff(old, new reflect.Value, path string) []string {
+	var changed []string
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if len(name) == 0 || len(t.Field(i).PkgPath) != 0 {
+			continue
+		}
+		o, n := old.Field(i), new.Field(i)
+		if o.Kind() == reflect.Struct {
+			changed = append(changed, diff(o, n, path+name+".")...)
+			continue
+		}
+		if !reflect.DeepEqual(o.Interface(), n.Interface()) {
+			changed = append(changed, path+name)
+		}
+	}
+	return changed
+}
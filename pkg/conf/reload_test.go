@@ -0,0 +1,51 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Diff(t *testing.T) {
+	old := Conf{
+		Port:          8443,
+		Database:      Database{Type: "postgres", Password: "secret"},
+		Authorization: Authorization{SessionDuration: 600},
+		Server:        Server{RouteMaxBodyBytes: map[string]int64{"/api/login": 4096}},
+	}
+	tt := []struct {
+		name    string
+		change  func(c *Conf)
+		live    []string
+		restart []string
+	}{
+		{name: "No changes", change: func(c *Conf) {}},
+		{name: "Session duration", change: func(c *Conf) { c.Authorization.SessionDuration = 1200 }, live: []string{"authorization.sessionDuration"}},
+		{name: "Route body limits", change: func(c *Conf) {
+			c.Server.RouteMaxBodyBytes = map[string]int64{"/api/login": 8192}
+		}, live: []string{"server.routeMaxBodyBytes"}},
+		{name: "Port and timeout", change: func(c *Conf) {
+			c.Port = 9443
+			c.Server.ReadTimeout = 60
+		}, live: []string{"server.readTimeout"}, restart: []string{"port"}},
+		{name: "Log level and rate limits", change: func(c *Conf) {
+			c.LogLevel = "warning"
+			c.Authorization.RateLimits.EmailInterval = 120
+		}, live: []string{"authorization.rateLimits.emailInterval", "logLevel"}},
+		{name: "Mixed", change: func(c *Conf) {
+			c.Database.Password = "changed"
+			c.Server.MaxBodyBytes = 1024
+		}, live: []string{"server.maxBodyBytes"}, restart: []string{"database.password"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			new := old
+			new.Server.RouteMaxBodyBytes = map[string]int64{"/api/login": 4096}
+			tc.change(&new)
+			live, restart := Diff(old, new)
+			require.Equal(t, tc.live, live)
+			require.Equal(t, tc.restart, restart)
+		})
+	}
+}
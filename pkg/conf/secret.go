@@ -0,0 +1,106 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// fileReferencePrefix marks a value which is read from a file, e.g. 'file:/run/secrets/db_password',
+	// relative path is resolved relatively to the configuration file
+	fileReferencePrefix = "file:"
+	// envReferencePrefix marks a value which is read from an environment variable, e.g. 'env:DB_PASSWORD'
+	envReferencePrefix = "env:"
+	// RedactedValue replaces secrets when configuration is logged or dumped
+	RedactedValue = "[REDACTED]"
+)
+
+// resolveReferences replaces 'file:' and 'env:' references in string fields with
+// the values they point to
+func resolveReferences(v reflect.Value, baseDir, path string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if len(name) == 0 || len(t.Field(i).PkgPath) != 0 {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Struct:
+			if err := resolveReferences(field, baseDir, path+name+"."); err != nil {
+				return err
+			}
+		case reflect.String:
+			value, err := resolveReference(field.String(), baseDir)
+			if err != nil {
+				return fmt.Errorf("failed to resolve '%s%s': %v", path, name, err)
+			}
+			field.SetString(value)
+		}
+	}
+	return nil
+}
+
+// resolveReference returns the value a reference points to, other values are returned as is
+func resolveReference(value, baseDir string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, fileReferencePrefix):
+		path := strings.TrimPrefix(value, fileReferencePrefix)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read file: %v", err)
+		}
+		// Trailing newline is usually left by editors and 'echo'
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case strings.HasPrefix(value, envReferencePrefix):
+		name := strings.TrimPrefix(value, envReferencePrefix)
+		v, exist := os.LookupEnv(name)
+		if !exist {
+			return "", fmt.Errorf("environment variable '%s' is not set", name)
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+// Redacted returns a copy of configuration with secrets replaced by RedactedValue
+func (c Conf) Redacted() Conf {
+	redact(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+// String returns configuration in YAML format with secrets redacted, so
+// configuration can be logged safely
+func (c Conf) String() string {
+	b, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Sprintf("failed to marshal configuration: %v", err)
+	}
+	return string(b)
+}
+
+// redact replaces non-empty fields tagged with 'secret:"true"'
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if len(t.Field(i).PkgPath) != 0 {
+			continue
+		}
+		switch {
+		case field.Kind() == reflect.Struct:
+			redact(field)
+		case field.Kind() == reflect.String && t.Field(i).Tag.Get("secret") == "true" && field.Len() != 0:
+			field.SetString(RedactedValue)
+		}
+	}
+}
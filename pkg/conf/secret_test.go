@@ -0,0 +1,66 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReadConf_SecretReferences(t *testing.T) {
+	_, err := ReadConf(goodConfFolder + "config-secrets.yaml")
+	require.Error(t, err, "missing environment variable must be reported")
+	require.Contains(t, err.Error(), "failed to resolve 'notifier.password': environment variable 'TEST_SMTP_PASSWORD' is not set")
+
+	require.NoError(t, os.Setenv("TEST_SMTP_PASSWORD", "smtpSecret"))
+	defer os.Unsetenv("TEST_SMTP_PASSWORD")
+	c, err := ReadConf(goodConfFolder + "config-secrets.yaml")
+	require.NoError(t, err, "expected to get no error, but got: %v", err)
+	require.Equal(t, "dbSecret", c.Database.Password, "file reference must be resolved relatively to the configuration")
+	require.Equal(t, "smtpSecret", c.Notifier.Password)
+	require.Equal(t, "admin", c.Database.Username, "plain values must be kept")
+
+	// Environment overrides may be references as well
+	err = c.ApplyEnv(func(key string) (string, bool) {
+		return "env:TEST_SMTP_PASSWORD", key == "APP_DATABASE_PASSWORD"
+	}, ".")
+	require.NoError(t, err)
+	require.Equal(t, "smtpSecret", c.Database.Password)
+
+	// Relative file references of environment overrides are resolved relatively to the base directory
+	err = c.ApplyEnv(func(key string) (string, bool) {
+		return "file:secrets/db_password", key == "APP_NOTIFIER_PASSWORD"
+	}, goodConfFolder)
+	require.NoError(t, err)
+	require.Equal(t, "dbSecret", c.Notifier.Password)
+
+	_, err = ReadConf(badConfFolder + "config-secrets.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to resolve 'database.password': failed to read file")
+}
+
+func Test_Conf_Redacted(t *testing.T) {
+	c := Conf{
+		Port:     8443,
+		Database: Database{Username: "admin", Password: "dbSecret"},
+		Authorization: Authorization{
+			OIDC:              OIDC{ClientID: "client", ClientSecret: "oidcSecret"},
+			EmailVerification: EmailVerification{Key: "verificationKey"},
+		},
+		Notifier: Notifier{Password: ""},
+	}
+	r := c.Redacted()
+	require.Equal(t, RedactedValue, r.Database.Password)
+	require.Equal(t, RedactedValue, r.Authorization.OIDC.ClientSecret)
+	require.Equal(t, RedactedValue, r.Authorization.EmailVerification.Key)
+	require.Empty(t, r.Notifier.Password, "empty secret must stay empty")
+	require.Equal(t, "admin", r.Database.Username)
+	require.Equal(t, "dbSecret", c.Database.Password, "original configuration must not be modified")
+
+	for _, s := range []string{c.String(), fmt.Sprintf("%v", c), fmt.Sprintf("%+v", &c)} {
+		require.NotContains(t, s, "dbSecret")
+		require.NotContains(t, s, "oidcSecret")
+		require.Contains(t, s, RedactedValue)
+	}
+}
@@ -0,0 +1,200 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ValidationError represents a configuration problem of a field
+type ValidationError struct {
+	// YAML path of the field, e.g. 'database.host' or 'listeners[0].address'
+	Path string
+	// Line of the field (or its closest parent) in the configuration file, 0 if unknown
+	Line    int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	switch {
+	case len(e.Path) == 0:
+		return e.Message
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	default:
+		return fmt.Sprintf("%s (line %d): %s", e.Path, e.Line, e.Message)
+	}
+}
+
+// ValidationErrors lists every problem found in configuration
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, e := range errs {
+		messages = append(messages, e.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// validator collects problems of a configuration section
+type validator struct {
+	path string
+	errs *ValidationErrors
+}
+
+func newValidator() validator {
+	return validator{errs: &ValidationErrors{}}
+}
+
+// at returns validator of a nested field
+func (v validator) at(name string) validator {
+	return validator{path: joinPath(v.path, name), errs: v.errs}
+}
+
+// addf records a problem of the field, empty name means the section itself
+func (v validator) addf(name, format string, args ...interface{}) {
+	*v.errs = append(*v.errs, ValidationError{Path: joinPath(v.path, name), Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns collected problems, nil if there are none
+func (v validator) err() error {
+	if len(*v.errs) == 0 {
+		return nil
+	}
+	return *v.errs
+}
+
+func joinPath(path, name string) string {
+	switch {
+	case len(path) == 0:
+		return name
+	case len(name) == 0:
+		return path
+	case strings.HasPrefix(name, "["):
+		return path + name
+	default:
+		return path + "." + name
+	}
+}
+
+// parentPath returns path of the field's parent, empty for top level fields
+func parentPath(path string) string {
+	if i := strings.LastIndexAny(path, ".["); i > 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// source represents positions of fields in the configuration file
+type source struct {
+	// Lines of fields by YAML path
+	lines map[string]int
+	// YAML paths of the fields by lines of their values
+	values map[int]string
+	// Keys which don't match any configuration field and values which
+	// don't match the field type
+	problems ValidationErrors
+}
+
+// decodeSource decodes YAML document into configuration, fields are
+// decoded only once the document is parsed, so their lines are known
+// and values of a wrong type are reported with unknown keys by Validate
+func decodeSource(data []byte, c *Conf) (*source, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	s := &source{lines: make(map[string]int), values: make(map[int]string)}
+	if len(doc.Content) == 0 {
+		return s, nil
+	}
+	if doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, fmt.Errorf("line %d: configuration must be a mapping of fields", doc.Content[0].Line)
+	}
+	s.walk(doc.Content[0], reflect.TypeOf(Conf{}), "")
+	// Fields of the right type are decoded even if others fail
+	var typeErr *yamlv3.TypeError
+	if err := doc.Decode(c); errors.As(err, &typeErr) {
+		for _, e := range typeErr.Errors {
+			s.problems = append(s.problems, s.typeError(e))
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// typeError converts a decoding error, e.g. "line 3: cannot unmarshal !!str `abc` into int",
+// to a problem of the field which value is on the line
+func (s *source) typeError(e string) ValidationError {
+	var line int
+	if _, err := fmt.Sscanf(e, "line %d:", &line); err != nil {
+		return ValidationError{Message: e}
+	}
+	message := e
+	if i := strings.Index(e, ": "); i != -1 {
+		message = e[i+2:]
+	}
+	return ValidationError{Path: s.values[line], Line: line, Message: message}
+}
+
+func (s *source) walk(node *yamlv3.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldPath := joinPath(path, key.Value)
+			field, exist := yamlField(t, key.Value)
+			if !exist {
+				s.problems = append(s.problems, ValidationError{Path: fieldPath, Line: key.Line, Message: "unknown field"})
+				continue
+			}
+			s.lines[fieldPath] = key.Line
+			s.values[value.Line] = fieldPath
+			s.walk(value, field.Type, fieldPath)
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yamlv3.SequenceNode:
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			s.lines[itemPath] = item.Line
+			s.values[item.Line] = itemPath
+			s.walk(item, t.Elem(), itemPath)
+		}
+	}
+}
+
+// withoutLines drops lines, e.g. if the document was converted from another format
+func (s *source) withoutLines() {
+	s.lines = make(map[string]int)
+	for i := range s.problems {
+		s.problems[i].Line = 0
+	}
+}
+
+// line returns line of the field or of its closest parent present in the file
+func (s *source) line(path string) int {
+	for ; len(path) != 0; path = parentPath(path) {
+		if line, exist := s.lines[path]; exist {
+			return line
+		}
+	}
+	return 0
+}
+
+// yamlField returns struct field with the YAML key
+func yamlField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) == 0 && strings.Split(f.Tag.Get("yaml"), ",")[0] == key {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,65 @@
+package conf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Conf_Validate_AllProblems(t *testing.T) {
+	c, err := ReadConf(badConfFolder + "config-validation.yaml")
+	require.NoError(t, err, "unknown fields must not fail reading, but got: %v", err)
+
+	err = c.Validate()
+	require.Error(t, err, "expected to see an error, but got nil")
+	var problems ValidationErrors
+	require.True(t, errors.As(err, &problems), "expected to get validation errors, but got: %T", err)
+	require.Equal(t, ValidationErrors{
+		{Path: "database.pasword", Line: 6, Message: "unknown field"},
+		{Path: "listeners[0].address", Line: 16, Message: "address 'localhost' must be in 'host:port' format"},
+		{Path: "database.username", Line: 3, Message: "username must be non-empty string"},
+		{Path: "database.password", Line: 3, Message: "password must be non-empty string"},
+		{Path: "database.name", Line: 3, Message: "database name must be non-empty string"},
+		{Path: "authorization.sessionDuration", Line: 9, Message: "session duration in 'session' type must be greater than 0"},
+		{Path: "authorization.cookie.sameSite", Line: 14, Message: "unsupported SameSite mode: sometimes"},
+	}, problems)
+	require.Contains(t, err.Error(), "authorization.cookie.sameSite (line 14): unsupported SameSite mode: sometimes")
+}
+
+func Test_ParseSource_Malformed(t *testing.T) {
+	// Malformed document which made yaml.v3 parser panic before v3.0.0 (CVE-2022-28948),
+	// e.g. a half-written file must not crash the server on reload
+	require.NotPanics(t, func() {
+		_, err := decodeSource([]byte("0: [:!00 \xef"), &Conf{})
+		require.Error(t, err, "expected malformed document to be rejected")
+	})
+}
+
+func Test_Conf_Effective(t *testing.T) {
+	c := Conf{
+		TLS:             true,
+		Port:            8443,
+		TLSAutoGenerate: AutoGenerate{Enabled: true},
+		Server:          Server{ReadTimeout: 60},
+	}
+	e := c.Effective()
+	require.Equal(t, []Listener{{Network: NetworkTCP, Address: ":8443", TLS: true}}, e.Listeners)
+	require.Equal(t, "1.2", e.TLSOptions.MinVersion)
+	require.Equal(t, []string{"h2", "http/1.1"}, e.TLSOptions.ALPN)
+	require.Equal(t, 365, e.TLSAutoGenerate.ValidDays)
+	require.Equal(t, 60, e.Server.ReadTimeout, "configured value must be kept")
+	require.Equal(t, defaultWriteTimeout, e.Server.WriteTimeout)
+	require.Equal(t, int64(defaultMaxBodyBytes), e.Server.MaxBodyBytes)
+	require.Equal(t, DefaultSessionSweepInterval, e.Authorization.SessionSweepInterval)
+	require.Equal(t, "info", e.LogLevel)
+	require.Equal(t, "SESSIONID", e.Authorization.Cookie.Name)
+	require.True(t, e.Authorization.Cookie.IsSecure())
+	require.Empty(t, c.Listeners, "original configuration must not be changed")
+
+	// Loaded configuration with 'connectRetries: 0'
+	c = Default()
+	c.Database.ConnectRetries = 0
+	e = c.Effective()
+	require.Equal(t, 0, e.Database.ConnectRetries, "configured 0 must not be replaced by default")
+}
@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+)
+
+var errScopeNotGranted = errors.New("credentials do not grant required scope")
+
+// authCode returns HTTP status code of an authentication or authorization error
+func authCode(err error) int {
+	if errors.Is(err, errScopeNotGranted) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// scopedSession returns authenticated session which has the scope
+func (api *API) scopedSession(w http.ResponseWriter, r *http.Request, scope string) (auth.Session, error) {
+	session, err := api.Auth.CheckSession(w, r)
+	if err != nil {
+		return session, err
+	}
+	if !session.HasScope(scope) {
+		return session, fmt.Errorf("'%s' scope: %w", scope, errScopeNotGranted)
+	}
+	return session, nil
+}
+
+// authorizeAPIKey verifies that a request which carries an API key has
+// the scope, anonymous and cookie authenticated requests are allowed
+func (api *API) authorizeAPIKey(w http.ResponseWriter, r *http.Request, scope string) error {
+	if _, exist := auth.APIKeyFromRequest(r); !exist {
+		return nil
+	}
+	_, err := api.scopedSession(w, r, scope)
+	return err
+}
+
+// CreateAPIKeyRequest is a request to create a named API key
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Key lifetime in seconds, 0 means the key does not expire
+	ExpiresIn int `json:"expiresIn"`
+}
+
+const maxAPIKeyNameLength = 100
+
+// Validate performs create API key request validation
+func (cakr *CreateAPIKeyRequest) Validate() error {
+	var ve ValidationErrors
+	switch {
+	case len(cakr.Name) == 0:
+		ve.Add("name", "name is not provided")
+	case len(cakr.Name) > maxAPIKeyNameLength:
+		ve.Add("name", fmt.Sprintf("name must be at most %d characters long", maxAPIKeyNameLength))
+	}
+	for _, scope := range cakr.Scopes {
+		known := false
+		for _, s := range auth.APIKeyScopes {
+			if s == scope {
+				known = true
+			}
+		}
+		if !known {
+			ve.Add("scopes", fmt.Sprintf("unknown scope: '%s'", scope))
+		}
+	}
+	if cakr.ExpiresIn < 0 {
+		ve.Add("expiresIn", "expiry can't be negative")
+	}
+	if len(ve) != 0 {
+		return ve
+	}
+	return nil
+}
+
+// CreateAPIKeyResponse contains a new API key, the key is shown only once
+type CreateAPIKeyResponse struct {
+	types.APIKey
+	Key string `json:"key"`
+}
+
+// RevokeAPIKeyRequest is a request to revoke API key
+type RevokeAPIKeyRequest struct {
+	ID int `json:"id"`
+}
+
+const createAPIKeyTag = "CreateAPIKey"
+
+// CreateAPIKey creates API key for a logged in user
+func (api *API) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, err := api.scopedSession(w, r, auth.ScopeAccount)
+		if err != nil {
+			fail(w, createAPIKeyTag, err, authCode(err))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var cakr CreateAPIKeyRequest
+		if err := decoder.Decode(&cakr); err != nil {
+			fail(w, createAPIKeyTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+		if err := cakr.Validate(); err != nil {
+			failValidation(w, createAPIKeyTag, err.(ValidationErrors))
+			return
+		}
+
+		key, prefix, err := auth.GenerateAPIKey()
+		if err != nil {
+			fail(w, createAPIKeyTag, err, http.StatusInternalServerError)
+			return
+		}
+		k := types.APIKey{
+			UserID:    session.UserID,
+			Name:      cakr.Name,
+			Prefix:    prefix,
+			KeyHash:   auth.HashToken(key),
+			Scopes:    cakr.Scopes,
+			CreatedAt: time.Now(),
+		}
+		if k.Scopes == nil {
+			k.Scopes = []string{}
+		}
+		if cakr.ExpiresIn > 0 {
+			expiresAt := k.CreatedAt.Add(time.Duration(cakr.ExpiresIn) * time.Second)
+			k.ExpiresAt = &expiresAt
+		}
+		if k.ID, err = api.DB.CreateAPIKey(k); err != nil {
+			fail(w, createAPIKeyTag, err, http.StatusInternalServerError)
+			return
+		}
+
+		writeReponseObject(w, CreateAPIKeyResponse{APIKey: k, Key: key}, createAPIKeyTag,
+			fmt.Sprintf("Created API key with '%s' prefix for user with '%s' username", prefix, session.Username))
+	}
+}
+
+const listAPIKeysTag = "ListAPIKeys"
+
+// ListAPIKeys returns active API keys of a logged in user
+func (api *API) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		session, err := api.scopedSession(w, r, auth.ScopeAccount)
+		if err != nil {
+			fail(w, listAPIKeysTag, err, authCode(err))
+			return
+		}
+
+		keys, err := api.DB.ListAPIKeys(session.UserID)
+		if err != nil {
+			fail(w, listAPIKeysTag, err, http.StatusInternalServerError)
+			return
+		}
+		writeReponseObject(w, keys, listAPIKeysTag, "")
+	}
+}
+
+const revokeAPIKeyTag = "RevokeAPIKey"
+
+// RevokeAPIKey revokes API key of a logged in user
+func (api *API) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, err := api.scopedSession(w, r, auth.ScopeAccount)
+		if err != nil {
+			fail(w, revokeAPIKeyTag, err, authCode(err))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var rakr RevokeAPIKeyRequest
+		if err := decoder.Decode(&rakr); err != nil {
+			fail(w, revokeAPIKeyTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+
+		if err := api.DB.RevokeAPIKey(session.UserID, rakr.ID); err != nil {
+			if errors.Is(err, storage.ErrAPIKeyNotFound) {
+				fail(w, revokeAPIKeyTag, err, http.StatusNotFound)
+				return
+			}
+			fail(w, revokeAPIKeyTag, err, http.StatusInternalServerError)
+			return
+		}
+
+		writeResponseString(w, MsgStatusOK, revokeAPIKeyTag, fmt.Sprintf("Revoked API key with '%d' ID of user with '%s' username", rakr.ID, session.Username))
+	}
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_APIKeys(t *testing.T) {
+	api := testAPI(t)
+	api.Auth = auth.DefineAPIKeyAuth(api.Auth, api.DB)
+	testRegister(t, api, "tester", "tester-password")
+	cookie := testLogIn(t, api, "tester", "tester-password")
+
+	request := func(hnd http.HandlerFunc, method string, headers map[string]string, obj interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/?key=1", strings.NewReader(string(marshal(obj, t))))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		if len(headers) == 0 {
+			req.AddCookie(cookie)
+		}
+		hnd.ServeHTTP(rec, req)
+		return rec
+	}
+	create := func(cakr CreateAPIKeyRequest) CreateAPIKeyResponse {
+		rec := request(api.CreateAPIKey, http.MethodPost, nil, cakr)
+		require.Equal(t, http.StatusOK, rec.Code, "API key creation failed: %s", rec.Body.String())
+		var resp CreateAPIKeyResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.True(t, strings.HasPrefix(resp.Key, "grt_"+resp.Prefix+"_"), "unexpected key format: %s", resp.Key)
+		return resp
+	}
+
+	rec := request(api.CreateAPIKey, http.MethodPost, nil, CreateAPIKeyRequest{Name: "job", Scopes: []string{"admin"}})
+	require.Equal(t, http.StatusBadRequest, rec.Code, "unknown scope must be rejected")
+
+	reader := create(CreateAPIKeyRequest{Name: "reader", Scopes: []string{auth.ScopeDataRead}})
+	writer := create(CreateAPIKeyRequest{Name: "writer", Scopes: []string{auth.ScopeDataWrite}, ExpiresIn: 3600})
+	require.NotNil(t, writer.ExpiresAt, "expiry must be set")
+
+	rec = request(api.ListAPIKeys, http.MethodGet, nil, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var keys []types.APIKey
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &keys))
+	require.Len(t, keys, 2)
+	require.NotContains(t, rec.Body.String(), writer.Key, "key must not be listed")
+
+	_, err := api.DB.Store("test")
+	require.NoError(t, err)
+
+	// Key with a different last character of the secret
+	last := "x"
+	if strings.HasSuffix(reader.Key, last) {
+		last = "y"
+	}
+	invalidKey := reader.Key[:len(reader.Key)-1] + last
+
+	tt := []struct {
+		name         string
+		hnd          http.HandlerFunc
+		method       string
+		headers      map[string]string
+		body         interface{}
+		expectedCode int
+	}{
+		{
+			name:         "Read with X-API-Key header",
+			hnd:          api.GetData,
+			method:       http.MethodGet,
+			headers:      map[string]string{"X-API-Key": reader.Key},
+			expectedCode: 200,
+		},
+		{
+			name:         "Write with read-only key",
+			hnd:          api.Store,
+			method:       http.MethodPost,
+			headers:      map[string]string{"Authorization": "Bearer " + reader.Key},
+			body:         DataAdditionRequest{Data: "test"},
+			expectedCode: 403,
+		},
+		{
+			name:         "Write with Bearer key",
+			hnd:          api.Store,
+			method:       http.MethodPost,
+			headers:      map[string]string{"Authorization": "Bearer " + writer.Key},
+			body:         DataAdditionRequest{Data: "test"},
+			expectedCode: 200,
+		},
+		{
+			name:         "Invalid key secret",
+			hnd:          api.GetData,
+			method:       http.MethodGet,
+			headers:      map[string]string{"X-API-Key": invalidKey},
+			expectedCode: 401,
+		},
+		{
+			name:         "Account management with API key",
+			hnd:          api.ListAPIKeys,
+			method:       http.MethodGet,
+			headers:      map[string]string{"X-API-Key": reader.Key},
+			expectedCode: 403,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := request(tc.hnd, tc.method, tc.headers, tc.body)
+			require.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+
+	// Bearer value which is not an API key must not fall back to the cookie, as it skips CSRF check
+	rec = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(string(marshal(CreateAPIKeyRequest{Name: "forged"}, t))))
+	req.Header.Set("Authorization", "Bearer x")
+	req.AddCookie(cookie)
+	api.CSRF(http.HandlerFunc(api.CreateAPIKey)).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+
+	rec = request(api.RevokeAPIKey, http.MethodPost, nil, RevokeAPIKeyRequest{ID: reader.ID})
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = request(api.GetData, http.MethodGet, map[string]string{"X-API-Key": reader.Key}, nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code, "revoked key must be rejected")
+	rec = request(api.RevokeAPIKey, http.MethodPost, nil, RevokeAPIKeyRequest{ID: reader.ID})
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+)
+
+const csrfTag = "CSRF"
+
+// CSRF is a middleware which protects cookie-authenticated requests from
+// cross-site forgery with a double-submit token. CSRF cookie is issued on
+// any request which does not carry it, state changing requests must repeat
+// cookie value in 'X-CSRF-Token' header unless they use bearer token or API key.
+func (api *API) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(auth.CSRFCookieName); err != nil || len(cookie.Value) == 0 {
+			token, err := auth.GenerateCSRFToken()
+			if err != nil {
+				fail(w, csrfTag, err, http.StatusInternalServerError)
+				return
+			}
+			auth.SetCSRFCookie(w, token, api.Cookie)
+			w.Header().Set(auth.CSRFHeader, token)
+		}
+
+		if !auth.CSRFExempt(r) {
+			if err := auth.CheckCSRF(r); err != nil {
+				fail(w, csrfTag, fmt.Errorf("CSRF check failed: %v", err), http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CSRF(t *testing.T) {
+	api := testAPI(t)
+	hnd := api.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponseString(w, MsgStatusOK, "", "")
+	}))
+
+	// Token is issued on the first request
+	rec := httptest.NewRecorder()
+	hnd.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/data/get/all", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	token := rec.Header().Get(auth.CSRFHeader)
+	require.NotEmpty(t, token, "expected CSRF token to be issued")
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1, "expected CSRF cookie to be set")
+	require.Equal(t, token, cookies[0].Value)
+	require.False(t, cookies[0].HttpOnly, "CSRF cookie must be readable by the client")
+
+	tt := []struct {
+		name         string
+		method       string
+		cookie       bool
+		headers      map[string]string
+		expectedCode int
+	}{
+		{name: "Safe method", method: http.MethodGet, expectedCode: 200},
+		{name: "No token", method: http.MethodPost, cookie: true, expectedCode: 403},
+		{name: "No cookie", method: http.MethodPost, headers: map[string]string{auth.CSRFHeader: token}, expectedCode: 403},
+		{name: "Token mismatch", method: http.MethodPost, cookie: true, headers: map[string]string{auth.CSRFHeader: "other"}, expectedCode: 403},
+		{name: "Valid token", method: http.MethodPost, cookie: true, headers: map[string]string{auth.CSRFHeader: token}, expectedCode: 200},
+		{name: "Bearer token is exempt", method: http.MethodPost, headers: map[string]string{"Authorization": "Bearer token"}, expectedCode: 200},
+		{name: "API key is exempt", method: http.MethodDelete, headers: map[string]string{auth.APIKeyHeader: "key"}, expectedCode: 200},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, "/api/data/store", nil)
+			if tc.cookie {
+				req.AddCookie(cookies[0])
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			hnd.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+}
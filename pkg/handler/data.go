@@ -7,13 +7,34 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
 )
 
+// ReadYourWritesHeader requests data reads from the primary database when set to 'true',
+// so a client sees its own writes regardless of replication lag
+const ReadYourWritesHeader = "X-Read-Your-Writes"
+
+// reader returns database data reads of the request are served by
+func (api *API) reader(r *http.Request) storage.DB {
+	if r.Header.Get(ReadYourWritesHeader) == "true" {
+		if pr, ok := api.DB.(storage.PrimaryReader); ok {
+			return pr.Primary()
+		}
+	}
+	return api.DB
+}
+
 const getTag = "GetData"
 
 // GetData queries some key in database
 func (api *API) GetData(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
+		if err := api.authorizeAPIKey(w, r, auth.ScopeDataRead); err != nil {
+			fail(w, getTag, err, authCode(err))
+			return
+		}
 		tm := time.Now()
 		keyString := r.URL.Query().Get("key")
 		if len(keyString) == 0 {
@@ -26,7 +47,7 @@ func (api *API) GetData(w http.ResponseWriter, r *http.Request) {
 			fail(w, getTag, fmt.Errorf("key must be an integer: %v", err), http.StatusInternalServerError)
 			return
 		}
-		d, err := api.DB.GetKey(key)
+		d, err := api.reader(r).GetKey(key)
 		if err != nil {
 			fail(w, getTag, fmt.Errorf("failed to get data for '%d' key: %v", key, err), http.StatusInternalServerError)
 			return
@@ -41,9 +62,13 @@ const getAllTag = "GetAllData"
 // GetAllData queries all data from main table
 func (api *API) GetAllData(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
+		if err := api.authorizeAPIKey(w, r, auth.ScopeDataRead); err != nil {
+			fail(w, getAllTag, err, authCode(err))
+			return
+		}
 		tm := time.Now()
 
-		data, err := api.DB.GetAll()
+		data, err := api.reader(r).GetAll()
 		if err != nil {
 			fail(w, getAllTag, fmt.Errorf("failed to get all data from 'data_table': %v", err), http.StatusInternalServerError)
 			return
@@ -57,10 +82,14 @@ const storeTag = "Store"
 // Store performs key addition to the database
 func (api *API) Store(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
+		if err := api.authorizeAPIKey(w, r, auth.ScopeDataWrite); err != nil {
+			fail(w, storeTag, err, authCode(err))
+			return
+		}
 		decoder := json.NewDecoder(r.Body)
 		var dar DataAdditionRequest
 		if err := decoder.Decode(&dar); err != nil {
-			fail(w, storeTag, fmt.Errorf("error while decoding body request: %v", err), http.StatusInternalServerError)
+			fail(w, storeTag, fmt.Errorf("error while decoding body request: %v", err), decodeFailCode(err, http.StatusInternalServerError))
 			return
 		}
 
@@ -83,6 +112,15 @@ func fail(w http.ResponseWriter, tag string, err error, code int) {
 	http.Error(w, err.Error(), code)
 }
 
+func failValidation(w http.ResponseWriter, tag string, ve ValidationErrors) {
+	log.Printf("[%s] Error: request validation failed: %v", tag, ve)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(ValidationErrorResponse{Status: StatusValidationFailed, Errors: ve}); err != nil {
+		log.Printf("[%s] Error: failed to encode response: %v", tag, err)
+	}
+}
+
 func writeResponseString(w http.ResponseWriter, msg, logTag, logMsg string) {
 	if _, err := w.Write([]byte(msg)); err != nil {
 		fail(w, logTag, fmt.Errorf("failed to write response: %v", err), http.StatusInternalServerError)
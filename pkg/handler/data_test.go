@@ -111,3 +111,55 @@ func Test_Store(t *testing.T) {
 		})
 	}
 }
+
+// replicatedStorage reads data from a lagging replica unless primary is requested
+type replicatedStorage struct {
+	*storage.InMemoryStorage
+	primary *storage.InMemoryStorage
+}
+
+func (rs *replicatedStorage) Primary() storage.DB {
+	return rs.primary
+}
+
+func Test_GetData_ReadYourWrites(t *testing.T) {
+	replica, primary := &storage.InMemoryStorage{}, &storage.InMemoryStorage{}
+	require.NoError(t, replica.Connect(), "failed to connect to replica")
+	require.NoError(t, primary.Connect(), "failed to connect to primary")
+	_, err := primary.Store("test")
+	require.NoError(t, err, "expected Store() to succeed")
+
+	tt := []struct {
+		name         string
+		header       string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Read from replica",
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: "failed to get data for '1' key",
+		},
+		{
+			name:         "Read your writes",
+			header:       "true",
+			expectedCode: http.StatusOK,
+			expectedBody: string(marshal(types.Data{ID: 1, String: "test"}, t)),
+		},
+	}
+
+	api := API{DB: &replicatedStorage{InMemoryStorage: replica, primary: primary}}
+	hnd := http.HandlerFunc(api.GetData)
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/api/data/get?key=1", nil)
+			if len(tc.header) != 0 {
+				req.Header.Set(ReadYourWritesHeader, tc.header)
+			}
+			hnd.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedCode, rec.Code)
+			require.Contains(t, rec.Body.String(), tc.expectedBody)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const limitBodyTag = "LimitBody"
+
+// errBodyTooLarge is returned when request body exceeds the limit of the route
+var errBodyTooLarge = errors.New("request body is too large")
+
+// LimitBody is a middleware which limits request body size with the route limit or
+// the default one, larger requests are rejected with 413 status. Handlers map
+// errBodyTooLarge to 413 with decodeFailCode while decoding the body.
+func (api *API) LimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := api.bodyLimit(r.URL.Path)
+		if limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			fail(w, limitBodyTag, fmt.Errorf("%v: %d bytes exceed %d bytes limit", errBodyTooLarge, r.ContentLength, limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetBodyLimits sets request body size limits, 0 means no limit, limits
+// can be changed while the server is running
+func (api *API) SetBodyLimits(maxBodyBytes int64, routeMaxBodyBytes map[string]int64) {
+	routes := make(map[string]int64, len(routeMaxBodyBytes))
+	for route, limit := range routeMaxBodyBytes {
+		routes[route] = limit
+	}
+	api.bodyLimitsMutex.Lock()
+	defer api.bodyLimitsMutex.Unlock()
+	api.maxBodyBytes = maxBodyBytes
+	api.routeMaxBodyBytes = routes
+}
+
+// bodyLimit returns body size limit of the route
+func (api *API) bodyLimit(path string) int64 {
+	api.bodyLimitsMutex.RLock()
+	defer api.bodyLimitsMutex.RUnlock()
+	if l, exist := api.routeMaxBodyBytes[path]; exist {
+		return l
+	}
+	return api.maxBodyBytes
+}
+
+// limitedBody replaces an error of http.MaxBytesReader with errBodyTooLarge
+type limitedBody struct {
+	io.ReadCloser
+	read  int64
+	limit int64
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	n, err := lb.ReadCloser.Read(p)
+	lb.read += int64(n)
+	// MaxBytesReader fails only after the whole limit is read
+	if err != nil && err != io.EOF && lb.read >= lb.limit {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+// decodeFailCode returns status code of a body decoding error,
+// 413 is returned if the body exceeds the limit
+func decodeFailCode(err error, code int) int {
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return code
+}
+
+const (
+	// defaultTOTPSessionFailures is a number of failed second factor attempts
+	// after which the pending session is revoked
+	defaultTOTPSessionFailures = 5
+	// defaultTOTPUserFailures limits failed second factor attempts of a user across
+	// pending sessions, so the code can't be guessed by logging in again
+	defaultTOTPUserFailures = 20
+	// defaultTOTPFailureWindow is a period failed attempts are counted within
+	defaultTOTPFailureWindow = 15 * time.Minute
+	// defaultEmailInterval is a minimal interval between verification or
+	// password reset links sent to an email
+	defaultEmailInterval = time.Minute
+)
+
+// RateLimits limits failed second factor attempts and emails sent to an address,
+// 0 means a default value
+type RateLimits struct {
+	// Failed attempts after which a pending session is revoked
+	TOTPSessionFailures int
+	// Failed attempts of a user across pending sessions
+	TOTPUserFailures int
+	// Period failed attempts are counted within
+	TOTPFailureWindow time.Duration
+	// Minimal interval between verification or password reset links sent to an email
+	EmailInterval time.Duration
+}
+
+// SetRateLimits sets rate limits, limits can be changed while the server is running
+func (api *API) SetRateLimits(limits RateLimits) {
+	api.limitsMutex.Lock()
+	defer api.limitsMutex.Unlock()
+	api.limits = limits
+}
+
+// rateLimits returns rate limits with 0 values replaced by the defaults
+func (api *API) rateLimits() RateLimits {
+	api.limitsMutex.RLock()
+	limits := api.limits
+	api.limitsMutex.RUnlock()
+	if limits.TOTPSessionFailures == 0 {
+		limits.TOTPSessionFailures = defaultTOTPSessionFailures
+	}
+	if limits.TOTPUserFailures == 0 {
+		limits.TOTPUserFailures = defaultTOTPUserFailures
+	}
+	if limits.TOTPFailureWindow == 0 {
+		limits.TOTPFailureWindow = defaultTOTPFailureWindow
+	}
+	if limits.EmailInterval == 0 {
+		limits.EmailInterval = defaultEmailInterval
+	}
+	return limits
+}
+
+// intervalLimiter allows an action once per interval for every key,
+// e.g. sending an email to an address
+type intervalLimiter struct {
+	mutex sync.Mutex
+	last  map[string]time.Time
+}
+
+// allow reports whether the action is allowed for the key and, if so, records it
+func (il *intervalLimiter) allow(key string, interval time.Duration) bool {
+	il.mutex.Lock()
+	defer il.mutex.Unlock()
+	now := time.Now()
+	if il.last == nil {
+		il.last = make(map[string]time.Time)
+	}
+	for k, t := range il.last {
+		if now.Sub(t) >= interval {
+			delete(il.last, k)
+		}
+	}
+	if _, exist := il.last[key]; exist {
+		return false
+	}
+	il.last[key] = now
+	return true
+}
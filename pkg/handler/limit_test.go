@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LimitBody(t *testing.T) {
+	api := testAPI(t)
+	api.SetBodyLimits(128, map[string]int64{"/api/login": 32})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/login", api.LogIn)
+	mux.HandleFunc("/api/register/user", api.RegisterUser)
+	hnd := api.LimitBody(mux)
+
+	large := `{"username": "` + strings.Repeat("a", 200) + `", "password": "password"}`
+	tt := []struct {
+		name string
+		path string
+		body string
+		// Unknown length forces the limit to be checked while reading
+		unknownLength bool
+		expectedCode  int
+	}{
+		{name: "Route limit", path: "/api/login", body: `{"username": "user", "password": "password"}`, expectedCode: 413},
+		{name: "Route limit (unknown length)", path: "/api/login", body: `{"username": "user", "password": "password"}`, unknownLength: true, expectedCode: 413},
+		{name: "Default limit", path: "/api/register/user", body: large, expectedCode: 413},
+		{name: "Default limit (unknown length)", path: "/api/register/user", body: large, unknownLength: true, expectedCode: 413},
+		{name: "Body within limit", path: "/api/login", body: `{"username":"u","password":"p"}`, expectedCode: 401},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			if tc.unknownLength {
+				req.ContentLength = -1
+			}
+			hnd.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+			if tc.expectedCode == http.StatusRequestEntityTooLarge {
+				require.Contains(t, rec.Body.String(), "request body is too large")
+			}
+		})
+	}
+
+	// Limits can be changed while serving
+	api.SetBodyLimits(1024, nil)
+	rec := httptest.NewRecorder()
+	hnd.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"username": "user", "password": "password"}`)))
+	require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+}
+
+func Test_RateLimits(t *testing.T) {
+	var api API
+	require.Equal(t, RateLimits{
+		TOTPSessionFailures: defaultTOTPSessionFailures,
+		TOTPUserFailures:    defaultTOTPUserFailures,
+		TOTPFailureWindow:   defaultTOTPFailureWindow,
+		EmailInterval:       defaultEmailInterval,
+	}, api.rateLimits(), "defaults must be used when limits are not set")
+
+	limits := RateLimits{TOTPSessionFailures: 3, TOTPUserFailures: 6, TOTPFailureWindow: time.Minute, EmailInterval: time.Millisecond}
+	api.SetRateLimits(limits)
+	require.Equal(t, limits, api.rateLimits())
+
+	require.True(t, api.passwordResets.allow("tester@example.com", api.rateLimits().EmailInterval))
+	require.False(t, api.passwordResets.allow("tester@example.com", api.rateLimits().EmailInterval))
+	time.Sleep(2 * time.Millisecond)
+	require.True(t, api.passwordResets.allow("tester@example.com", api.rateLimits().EmailInterval), "changed interval must be applied")
+}
@@ -2,8 +2,12 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
 )
 
 // LogInRequest represents a login request
@@ -31,7 +35,7 @@ func (api *API) LogIn(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 		var lir LogInRequest
 		if err := decoder.Decode(&lir); err != nil {
-			fail(w, logInTag, fmt.Errorf("failed to decode body request: %v", err), http.StatusBadRequest)
+			fail(w, logInTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
 			return
 		}
 		if err := lir.Validate(); err != nil {
@@ -47,19 +51,47 @@ func (api *API) LogIn(w http.ResponseWriter, r *http.Request) {
 
 		passwordHash := api.Auth.PBKDF2HashPassword(lir.Password, passwordSalt)
 
-		_, err = api.DB.VerifyUserCredentials(lir.Username, passwordHash)
+		user, err := api.DB.VerifyUserCredentials(lir.Username, passwordHash)
 		if err != nil {
 			fail(w, logInTag, err, http.StatusUnauthorized)
 			return
 		}
+		api.logInUser(w, r, user, logInTag)
+	}
+}
+
+// logInUser creates a session of an authenticated user, pending session is
+// created if the second factor must be provided or enrolled
+func (api *API) logInUser(w http.ResponseWriter, r *http.Request, user types.User, tag string) {
+	if api.EmailVerifier != nil && !user.IsVerified {
+		fail(w, tag, fmt.Errorf("email address of user with '%s' username is not verified, use the link sent to the email", user.Username), http.StatusForbidden)
+		return
+	}
 
-		if _, err := api.Auth.CreateSession(w); err != nil {
-			fail(w, logInTag, fmt.Errorf("failed to create session: %v", err), http.StatusUnauthorized)
+	totp, err := api.DB.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, storage.ErrTOTPNotFound) {
+		fail(w, tag, fmt.Errorf("failed to get user second factor: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if totp.Enabled || (api.RequireTOTPForAdmins && user.IsAdmin) {
+		if _, err := api.Auth.CreatePendingSession(w, r, user); err != nil {
+			fail(w, tag, fmt.Errorf("failed to create session: %v", err), http.StatusUnauthorized)
+			return
+		}
+		if totp.Enabled {
+			writeResponseString(w, MsgStatusTOTPRequired, tag, fmt.Sprintf("User with '%s' username must provide TOTP code", user.Username))
 			return
 		}
+		writeResponseString(w, MsgStatusTOTPEnrollmentRequired, tag, fmt.Sprintf("User with '%s' username must enroll TOTP", user.Username))
+		return
+	}
 
-		writeResponseString(w, MsgStatusOK, logInTag, fmt.Sprintf("Successfully logged in user with '%s' username", lir.Username))
+	if _, err := api.Auth.CreateSession(w, r, user); err != nil {
+		fail(w, tag, fmt.Errorf("failed to create session: %v", err), http.StatusUnauthorized)
+		return
 	}
+
+	writeResponseString(w, MsgStatusOK, tag, fmt.Sprintf("Successfully logged in user with '%s' username", user.Username))
 }
 
 const logInStatusTag = "LogInStatus"
@@ -67,7 +99,7 @@ const logInStatusTag = "LogInStatus"
 // LogInStatus checks if user is logged in or is authorized
 func (api *API) LogInStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
-		if err := api.Auth.CheckSession(w, r); err != nil {
+		if _, err := api.Auth.CheckSession(w, r); err != nil {
 			fail(w, logInStatusTag, err, http.StatusUnauthorized)
 			return
 		}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+)
+
+// oidcUsernameAttempts is a number of attempts to find a free username
+// for a user created on the first OpenID Connect log in
+const oidcUsernameAttempts = 5
+
+var (
+	errOIDCEmail = errors.New("identity provider must return a valid email")
+	// errOIDCUnverifiedEmail is returned if an account would be created for an email
+	// nobody has verified, so it could later be linked to the owner of the email
+	errOIDCUnverifiedEmail = errors.New("identity provider did not verify the email and email verification is disabled")
+)
+
+const oidcLogInTag = "OIDCLogIn"
+
+// OIDCLogIn starts OpenID Connect log in and redirects the user to the identity provider
+func (api *API) OIDCLogIn(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if api.OIDC == nil {
+			fail(w, oidcLogInTag, fmt.Errorf("OpenID Connect log in is disabled"), http.StatusNotFound)
+			return
+		}
+		authURL, state, err := api.OIDC.Begin()
+		if err != nil {
+			fail(w, oidcLogInTag, fmt.Errorf("failed to start log in: %v", err), http.StatusBadGateway)
+			return
+		}
+		// Lax mode is required as the callback is a cross-site redirect from the provider
+		http.SetCookie(w, &http.Cookie{
+			Name:     auth.OIDCStateCookieName,
+			Value:    state,
+			Path:     "/",
+			MaxAge:   int(auth.OIDCLogInDuration.Seconds()),
+			HttpOnly: true,
+			Secure:   api.Cookie.Secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+const oidcCallbackTag = "OIDCCallback"
+
+// OIDCCallback completes OpenID Connect log in: the user is found by the
+// linked identity, linked by verified email or created, and a session is created
+func (api *API) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if api.OIDC == nil {
+			fail(w, oidcCallbackTag, fmt.Errorf("OpenID Connect log in is disabled"), http.StatusNotFound)
+			return
+		}
+		q := r.URL.Query()
+		if e := q.Get("error"); len(e) != 0 {
+			fail(w, oidcCallbackTag, fmt.Errorf("identity provider returned error: %s %s", e, q.Get("error_description")), http.StatusUnauthorized)
+			return
+		}
+		state := q.Get("state")
+		cookie, err := r.Cookie(auth.OIDCStateCookieName)
+		if err != nil || len(state) == 0 || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			fail(w, oidcCallbackTag, fmt.Errorf("log in state does not match"), http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     auth.OIDCStateCookieName,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   api.Cookie.Secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		claims, err := api.OIDC.Finish(state, q.Get("code"))
+		if err != nil {
+			fail(w, oidcCallbackTag, err, http.StatusUnauthorized)
+			return
+		}
+		user, err := api.oidcUser(claims)
+		if err != nil {
+			switch {
+			case errors.Is(err, errOIDCEmail):
+				fail(w, oidcCallbackTag, err, http.StatusBadRequest)
+			case errors.Is(err, errOIDCUnverifiedEmail):
+				fail(w, oidcCallbackTag, err, http.StatusForbidden)
+			case errors.Is(err, storage.ErrUserExists):
+				fail(w, oidcCallbackTag, err, http.StatusConflict)
+			default:
+				fail(w, oidcCallbackTag, fmt.Errorf("failed to get user: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+		if user.IsDisabled {
+			fail(w, oidcCallbackTag, fmt.Errorf("user with '%s' username is disabled", user.Username), http.StatusForbidden)
+			return
+		}
+
+		api.logInUser(w, r, user, oidcCallbackTag)
+	}
+}
+
+// oidcUser returns user linked to the identity, existing user with the same
+// verified email is linked and a new user is created otherwise
+func (api *API) oidcUser(claims auth.OIDCClaims) (types.User, error) {
+	user, err := api.DB.GetUserByIdentity(claims.Issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		return user, err
+	}
+
+	claims.Email = normalizeEmail(claims.Email)
+	if len(claims.Email) == 0 || len(claims.Email) > maxEmailLength {
+		return user, errOIDCEmail
+	}
+	if addr, err := mail.ParseAddress(claims.Email); err != nil || addr.Address != claims.Email {
+		return user, errOIDCEmail
+	}
+
+	user, err = api.DB.GetUserByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// Linking by an email which is not verified on both sides would allow account takeover
+		if !claims.EmailVerified {
+			return user, fmt.Errorf("user with '%s' email exists, but email is not verified by identity provider: %w", claims.Email, storage.ErrUserExists)
+		}
+		if !user.IsVerified {
+			return user, fmt.Errorf("user with '%s' email exists, but email of the user is not verified: %w", claims.Email, storage.ErrUserExists)
+		}
+	case errors.Is(err, storage.ErrUserNotFound):
+		return api.registerOIDCUser(claims)
+	default:
+		return user, err
+	}
+
+	if err := api.DB.LinkUserIdentity(user.ID, claims.Issuer, claims.Subject); err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// registerOIDCUser creates a user with a random password linked to the identity,
+// the user can set a password later with password reset. If the provider doesn't
+// report the email as verified, the user is created only if email verification is
+// enabled and verification link is sent.
+func (api *API) registerOIDCUser(claims auth.OIDCClaims) (types.User, error) {
+	if !claims.EmailVerified && api.EmailVerifier == nil {
+		return types.User{}, errOIDCUnverifiedEmail
+	}
+	passwordSalt, err := auth.GenerateRandomString(16)
+	if err != nil {
+		return types.User{}, fmt.Errorf("failed to generate password salt: %v", err)
+	}
+	password, err := auth.GenerateRandomString(32)
+	if err != nil {
+		return types.User{}, fmt.Errorf("failed to generate password: %v", err)
+	}
+	fullname := claims.Name
+	if len(fullname) > maxFullnameLength {
+		fullname = fullname[:maxFullnameLength]
+	}
+	user := types.User{
+		Username:     oidcUsername(claims),
+		Fullname:     fullname,
+		PasswordSalt: passwordSalt,
+		PasswordHash: api.Auth.PBKDF2HashPassword(password, passwordSalt),
+		Email:        claims.Email,
+		IsVerified:   claims.EmailVerified,
+	}
+
+	base := user.Username
+	for attempt := 1; ; attempt++ {
+		// User and identity are stored together, every attempt is a separate
+		// transaction as a failed insert aborts Postgres transaction
+		err = api.DB.WithTx(func(tx storage.DB) error {
+			var err error
+			if user.ID, err = tx.RegisterUser(user); err != nil {
+				return err
+			}
+			return tx.LinkUserIdentity(user.ID, claims.Issuer, claims.Subject)
+		})
+		if err == nil {
+			if !user.IsVerified {
+				// User is created anyway, a new link can be requested with ResendVerification
+				if err := api.sendVerificationEmail(user.ID, user); err != nil {
+					log.Printf("[%s] Error: failed to send verification email to user with '%s' username: %v", oidcCallbackTag, user.Username, err)
+				}
+			}
+			return user, nil
+		}
+		if !errors.Is(err, storage.ErrUserExists) || attempt == oidcUsernameAttempts {
+			return user, err
+		}
+		suffix, err := auth.GenerateRandomString(4)
+		if err != nil {
+			return user, fmt.Errorf("failed to generate username: %v", err)
+		}
+		user.Username = base + "-" + suffix
+	}
+}
+
+// oidcUsername derives username from preferred username or email
+func oidcUsername(claims auth.OIDCClaims) string {
+	candidate := claims.PreferredUsername
+	if len(candidate) == 0 {
+		candidate = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	var b strings.Builder
+	for _, r := range candidate {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '_' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	username := strings.TrimLeft(b.String(), "._-")
+	// Leave space for a suffix if username is taken
+	if len(username) > maxUsernameLength-5 {
+		username = username[:maxUsernameLength-5]
+	}
+	if !usernameRegexp.MatchString(username) {
+		return "user"
+	}
+	return username
+}
@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/auth/oidctest"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+// testOIDCLogIn performs log in via the mock provider and returns callback response
+func testOIDCLogIn(t *testing.T, api *API, mock *oidctest.Provider) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	http.HandlerFunc(api.OIDCLogIn).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1, "expected state cookie to be set")
+
+	callback, err := mock.Authorize(rec.Header().Get("Location"))
+	require.NoError(t, err, "failed to authorize: %v", err)
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	req.AddCookie(cookies[0])
+	http.HandlerFunc(api.OIDCCallback).ServeHTTP(rec, req)
+	return rec
+}
+
+func testSessionCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == auth.SSMCookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func Test_OIDCLogIn(t *testing.T) {
+	api := testAPI(t)
+	mock := oidctest.NewProvider("client", "secret")
+	defer mock.Close()
+	api.OIDC = auth.DefineOIDCProvider(mock.Issuer(), "client", "secret", "https://localhost/api/oidc/callback", []string{"email", "profile"})
+
+	// A new user is created on the first log in
+	mock.Claims = map[string]interface{}{"sub": "new", "email": "new@example.com", "email_verified": true, "preferred_username": "new user!"}
+	rec := testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NotNil(t, testSessionCookie(rec), "expected session cookie to be set")
+	user, err := api.DB.GetUserByIdentity(mock.Issuer(), "new")
+	require.NoError(t, err, "expected identity to be linked: %v", err)
+	require.Equal(t, "newuser", user.Username)
+	require.True(t, user.IsVerified)
+
+	// The same user is found on the next log in
+	rec = testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	again, err := api.DB.GetUserByIdentity(mock.Issuer(), "new")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, again.ID)
+
+	// Local user is linked by verified email
+	unverified := testRegisterUser(t, api, types.User{Username: "unverified"}, "password")
+	mock.Claims = map[string]interface{}{"sub": "unverified", "email": unverified.Email, "email_verified": true}
+	rec = testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusConflict, rec.Code, "user with unverified email must not be linked")
+	local := testRegisterUser(t, api, types.User{Username: "local", IsVerified: true}, "password")
+	mock.Claims = map[string]interface{}{"sub": "local", "email": local.Email, "email_verified": false}
+	rec = testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusConflict, rec.Code, "unverified email must not be linked")
+	mock.Claims["email_verified"] = true
+	rec = testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	linked, err := api.DB.GetUserByIdentity(mock.Issuer(), "local")
+	require.NoError(t, err)
+	require.Equal(t, local.ID, linked.ID)
+
+	// Email is required to create a user
+	mock.Claims = map[string]interface{}{"sub": "no-email"}
+	rec = testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "identity provider must return a valid email")
+
+	// Unverified email can't be claimed without email verification
+	mock.Claims = map[string]interface{}{"sub": "unverified-new", "email": "unverified-new@example.com", "email_verified": false}
+	rec = testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Contains(t, rec.Body.String(), "email verification is disabled")
+	_, err = api.DB.GetUserByEmail("unverified-new@example.com")
+	require.True(t, errors.Is(err, storage.ErrUserNotFound), "user must not be created for unverified email")
+}
+
+// failingIdentityStorage fails to link identities, including in transactions
+type failingIdentityStorage struct {
+	storage.DB
+}
+
+func (fis failingIdentityStorage) LinkUserIdentity(userID int, issuer, subject string) error {
+	return errors.New("disk is full")
+}
+
+func (fis failingIdentityStorage) WithTx(fn func(tx storage.DB) error) error {
+	return fis.DB.WithTx(func(tx storage.DB) error { return fn(failingIdentityStorage{tx}) })
+}
+
+func Test_OIDCLogIn_LinkFailed(t *testing.T) {
+	api := testAPI(t)
+	mock := oidctest.NewProvider("client", "secret")
+	defer mock.Close()
+	api.OIDC = auth.DefineOIDCProvider(mock.Issuer(), "client", "secret", "https://localhost/api/oidc/callback", []string{"email"})
+	api.DB = failingIdentityStorage{api.DB}
+
+	mock.Claims = map[string]interface{}{"sub": "new", "email": "new@example.com", "email_verified": true}
+	rec := testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	_, err := api.DB.GetUserByEmail("new@example.com")
+	require.True(t, errors.Is(err, storage.ErrUserNotFound), "user must not be stored without identity")
+}
+
+func Test_OIDCCallback(t *testing.T) {
+	api := testAPI(t)
+	rec := httptest.NewRecorder()
+	http.HandlerFunc(api.OIDCLogIn).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code, "log in must be disabled without provider")
+
+	mock := oidctest.NewProvider("client", "")
+	defer mock.Close()
+	api.OIDC = auth.DefineOIDCProvider(mock.Issuer(), "client", "", "https://localhost/api/oidc/callback", nil)
+
+	tt := []struct {
+		name         string
+		url          string
+		cookie       *http.Cookie
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Provider error",
+			url:          "/api/oidc/callback?error=access_denied&state=state",
+			expectedCode: 401,
+			expectedBody: "access_denied",
+		},
+		{
+			name:         "No state cookie",
+			url:          "/api/oidc/callback?code=code&state=state",
+			expectedCode: 400,
+			expectedBody: "log in state does not match",
+		},
+		{
+			name:         "State mismatch",
+			url:          "/api/oidc/callback?code=code&state=state",
+			cookie:       &http.Cookie{Name: auth.OIDCStateCookieName, Value: "other"},
+			expectedCode: 400,
+			expectedBody: "log in state does not match",
+		},
+		{
+			name:         "Unknown state",
+			url:          "/api/oidc/callback?code=code&state=state",
+			cookie:       &http.Cookie{Name: auth.OIDCStateCookieName, Value: "state"},
+			expectedCode: 401,
+			expectedBody: "log in state is invalid or expired",
+		},
+	}
+
+	hnd := http.HandlerFunc(api.OIDCCallback)
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			hnd.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedCode, rec.Code)
+			require.Contains(t, rec.Body.String(), tc.expectedBody)
+		})
+	}
+}
+
+func Test_OIDCLogIn_UnverifiedEmail(t *testing.T) {
+	api := testAPI(t)
+	api.EmailVerifier = auth.DefineEmailVerifier("TESTKEYTESTKEYTESTKEYTESTKEYTEST", time.Hour)
+	api.EmailVerificationURL = "https://localhost/api/register/verify?token="
+	notifier := api.Notifier.(*recordingNotifier)
+	mock := oidctest.NewProvider("client", "secret")
+	defer mock.Close()
+	api.OIDC = auth.DefineOIDCProvider(mock.Issuer(), "client", "secret", "https://localhost/api/oidc/callback", []string{"email", "profile"})
+
+	// Verification link is sent if the provider doesn't verify the email
+	mock.Claims = map[string]interface{}{"sub": "new", "email": "new@example.com", "email_verified": false}
+	rec := testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	require.Len(t, notifier.messages, 1, "expected verification link to be sent")
+	require.Equal(t, "new@example.com", notifier.messages[0].To)
+
+	body := notifier.messages[0].Body
+	link := body[strings.Index(body, api.EmailVerificationURL):]
+	rec = httptest.NewRecorder()
+	http.HandlerFunc(api.VerifyEmail).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(link, "https://localhost"), nil))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusOK, rec.Code, "expected log in after verification: %s", rec.Body.String())
+	require.Len(t, notifier.messages, 1, "expected no other verification link")
+}
+
+func Test_OIDCLogIn_UnverifiedAccountNotLinked(t *testing.T) {
+	api := testAPI(t)
+	api.EmailVerifier = auth.DefineEmailVerifier("TESTKEYTESTKEYTESTKEYTESTKEYTEST", time.Hour)
+	api.EmailVerificationURL = "https://localhost/api/register/verify?token="
+	mock := oidctest.NewProvider("client", "secret")
+	defer mock.Close()
+	api.OIDC = auth.DefineOIDCProvider(mock.Issuer(), "client", "secret", "https://localhost/api/oidc/callback", []string{"email"})
+
+	// Account created for an email the provider didn't verify
+	mock.Claims = map[string]interface{}{"sub": "claimer", "email": "victim@example.com", "email_verified": false}
+	rec := testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+
+	// Owner of the verified email must not be linked to it
+	mock.Claims = map[string]interface{}{"sub": "owner", "email": "victim@example.com", "email_verified": true}
+	rec = testOIDCLogIn(t, api, mock)
+	require.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	_, err := api.DB.GetUserByIdentity(mock.Issuer(), "owner")
+	require.True(t, errors.Is(err, storage.ErrUserNotFound), "identity must not be linked")
+}
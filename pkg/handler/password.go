@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/notify"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+)
+
+// ChangePasswordRequest is a request of a logged in user to change password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// PasswordResetRequest is a request to send a password reset link
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetConfirmRequest sets a new password using a reset token
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// validatePassword checks new password against configured password policy
+func (api *API) validatePassword(field, password string) error {
+	policy := api.PasswordPolicy
+	if policy == nil {
+		policy = &auth.PasswordPolicy{}
+	}
+	var ve ValidationErrors
+	for _, err := range policy.Check(password) {
+		ve.Add(field, err.Error())
+	}
+	if len(ve) != 0 {
+		return ve
+	}
+	return nil
+}
+
+// setPassword generates a new salt and stores new password hash of the user in db
+func (api *API) setPassword(db storage.DB, userID int, password string) error {
+	passwordSalt, err := auth.GenerateRandomString(16)
+	if err != nil {
+		return fmt.Errorf("failed to generate password salt: %v", err)
+	}
+	passwordHash := api.Auth.PBKDF2HashPassword(password, passwordSalt)
+	return db.UpdateUserPassword(userID, passwordSalt, passwordHash)
+}
+
+const changePasswordTag = "ChangePassword"
+
+// ChangePassword changes password of a logged in user
+func (api *API) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, err := api.scopedSession(w, r, auth.ScopeAccount)
+		if err != nil {
+			fail(w, changePasswordTag, err, authCode(err))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var cpr ChangePasswordRequest
+		if err := decoder.Decode(&cpr); err != nil {
+			fail(w, changePasswordTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+		if len(cpr.CurrentPassword) == 0 {
+			failValidation(w, changePasswordTag, ValidationErrors{{Field: "currentPassword", Message: "current password is not provided"}})
+			return
+		}
+		if err := api.validatePassword("newPassword", cpr.NewPassword); err != nil {
+			failValidation(w, changePasswordTag, err.(ValidationErrors))
+			return
+		}
+
+		passwordSalt, err := api.DB.GetUserSalt(session.Username)
+		if err != nil {
+			fail(w, changePasswordTag, fmt.Errorf("failed to get user salt: %v", err), http.StatusUnauthorized)
+			return
+		}
+		if _, err := api.DB.VerifyUserCredentials(session.Username, api.Auth.PBKDF2HashPassword(cpr.CurrentPassword, passwordSalt)); err != nil {
+			fail(w, changePasswordTag, fmt.Errorf("current password is invalid"), http.StatusUnauthorized)
+			return
+		}
+
+		if err := api.setPassword(api.DB, session.UserID, cpr.NewPassword); err != nil {
+			fail(w, changePasswordTag, fmt.Errorf("failed to change password: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// Other sessions might belong to whoever knew the old password
+		api.Auth.RevokeOtherSessions(session.UserID, session)
+
+		writeResponseString(w, MsgStatusOK, changePasswordTag, fmt.Sprintf("Successfully changed password of user with '%s' username", session.Username))
+	}
+}
+
+const requestPasswordResetTag = "RequestPasswordReset"
+
+// RequestPasswordReset issues a password reset token and sends it to the user.
+// Response does not reveal whether user with given email exists, so failures
+// of sending the link are only logged.
+func (api *API) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		decoder := json.NewDecoder(r.Body)
+		var prr PasswordResetRequest
+		if err := decoder.Decode(&prr); err != nil {
+			fail(w, requestPasswordResetTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+		if len(prr.Email) == 0 {
+			failValidation(w, requestPasswordResetTag, ValidationErrors{{Field: "email", Message: "email is not provided"}})
+			return
+		}
+		// The limit is applied to every email, so it doesn't reveal whether the account exists
+		if interval := api.rateLimits().EmailInterval; !api.passwordResets.allow(normalizeEmail(prr.Email), interval) {
+			fail(w, requestPasswordResetTag, fmt.Errorf("password reset link was sent recently, retry in %v", interval), http.StatusTooManyRequests)
+			return
+		}
+
+		user, err := api.DB.GetUserByEmail(prr.Email)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				writeResponseString(w, MsgStatusOK, requestPasswordResetTag, fmt.Sprintf("Password reset requested for unknown '%s' email", prr.Email))
+				return
+			}
+			fail(w, requestPasswordResetTag, fmt.Errorf("failed to get user: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := api.sendPasswordReset(user); err != nil {
+			log.Printf("[%s] Error: failed to send password reset link to user with '%s' username: %v", requestPasswordResetTag, user.Username, err)
+			writeResponseString(w, MsgStatusOK, requestPasswordResetTag, "")
+			return
+		}
+
+		writeResponseString(w, MsgStatusOK, requestPasswordResetTag, fmt.Sprintf("Password reset link sent to user with '%s' username", user.Username))
+	}
+}
+
+// sendPasswordReset stores a new password reset token of the user and sends the reset link
+func (api *API) sendPasswordReset(user types.User) error {
+	token, err := auth.GenerateRandomString(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate password reset token: %v", err)
+	}
+	if err := api.DB.StorePasswordResetToken(types.PasswordResetToken{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(api.PasswordResetTokenDuration),
+	}); err != nil {
+		return err
+	}
+	return api.Notifier.Notify(notify.Message{
+		To:      user.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("A password reset was requested for '%s' account.\n\nUse the following link to set a new password (valid for %v):\n%s%s\n\nIf you did not request a password reset, ignore this message.",
+			user.Username, api.PasswordResetTokenDuration, api.PasswordResetURL, token),
+	})
+}
+
+const resetPasswordTag = "ResetPassword"
+
+// ResetPassword sets a new password using a password reset token
+func (api *API) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		decoder := json.NewDecoder(r.Body)
+		var prc PasswordResetConfirmRequest
+		if err := decoder.Decode(&prc); err != nil {
+			fail(w, resetPasswordTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+		if len(prc.Token) == 0 {
+			failValidation(w, resetPasswordTag, ValidationErrors{{Field: "token", Message: "token is not provided"}})
+			return
+		}
+		// Password is validated before the token is consumed,
+		// so a weak password does not burn the token
+		if err := api.validatePassword("newPassword", prc.NewPassword); err != nil {
+			failValidation(w, resetPasswordTag, err.(ValidationErrors))
+			return
+		}
+
+		// Token is consumed only if the password is stored
+		var userID int
+		err := api.DB.WithTx(func(tx storage.DB) error {
+			var err error
+			if userID, err = tx.ConsumePasswordResetToken(auth.HashToken(prc.Token)); err != nil {
+				return err
+			}
+			return api.setPassword(tx, userID, prc.NewPassword)
+		})
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidToken) {
+				fail(w, resetPasswordTag, err, http.StatusBadRequest)
+				return
+			}
+			fail(w, resetPasswordTag, fmt.Errorf("failed to reset password: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// Existing sessions might belong to whoever knew the old password
+		api.Auth.RevokeUserSessions(userID)
+
+		writeResponseString(w, MsgStatusOK, resetPasswordTag, fmt.Sprintf("Successfully reset password of user with '%d' ID", userID))
+	}
+}
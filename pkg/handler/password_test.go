@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/notify"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotifier struct {
+	messages []notify.Message
+}
+
+func (rn *recordingNotifier) Notify(m notify.Message) error {
+	rn.messages = append(rn.messages, m)
+	return nil
+}
+
+func testAPI(t *testing.T) *API {
+	api := &API{
+		DB:                         &storage.InMemoryStorage{},
+		Auth:                       auth.DefineSSM(10, 0, 1, 16, auth.DefaultCookieConfig()),
+		PasswordPolicy:             &auth.PasswordPolicy{MinLength: 8},
+		Notifier:                   &recordingNotifier{},
+		PasswordResetTokenDuration: time.Hour,
+		PasswordResetURL:           "https://localhost/reset?token=",
+		Cookie:                     auth.DefaultCookieConfig(),
+	}
+	require.NoError(t, api.DB.Connect(), "failed to connect to database")
+	return api
+}
+
+func testRegister(t *testing.T, api *API, username, password string) types.User {
+	return testRegisterUser(t, api, types.User{Username: username}, password)
+}
+
+func testRegisterUser(t *testing.T, api *API, user types.User, password string) types.User {
+	salt := "TESTSALT"
+	user.Email = user.Username + "@example.com"
+	user.PasswordSalt = salt
+	user.PasswordHash = api.Auth.PBKDF2HashPassword(password, salt)
+	id, err := api.DB.RegisterUser(user)
+	require.NoError(t, err, "failed to register user: %v", err)
+	user.ID = id
+	return user
+}
+
+func testLogIn(t *testing.T, api *API, username, password string) *http.Cookie {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/login", strings.NewReader(string(marshal(LogInRequest{Username: username, Password: password}, t))))
+	http.HandlerFunc(api.LogIn).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, "log in failed: %s", rec.Body.String())
+	cookies := rec.Result().Cookies()
+	require.NotEmpty(t, cookies, "expected session cookie to be set")
+	return cookies[0]
+}
+
+func Test_ChangePassword(t *testing.T) {
+	api := testAPI(t)
+	testRegister(t, api, "tester", "old-password")
+	cookie := testLogIn(t, api, "tester", "old-password")
+	other := testLogIn(t, api, "tester", "old-password")
+
+	tt := []struct {
+		name         string
+		cookie       *http.Cookie
+		request      ChangePasswordRequest
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Not logged in",
+			request:      ChangePasswordRequest{CurrentPassword: "old-password", NewPassword: "new-password"},
+			expectedCode: 401,
+		},
+		{
+			name:         "Weak new password",
+			cookie:       cookie,
+			request:      ChangePasswordRequest{CurrentPassword: "old-password", NewPassword: "short"},
+			expectedCode: 400,
+			expectedBody: "password must be at least 8 characters long",
+		},
+		{
+			name:         "Wrong current password",
+			cookie:       cookie,
+			request:      ChangePasswordRequest{CurrentPassword: "wrong-password", NewPassword: "new-password"},
+			expectedCode: 401,
+			expectedBody: "current password is invalid",
+		},
+		{
+			name:         "Valid change",
+			cookie:       cookie,
+			request:      ChangePasswordRequest{CurrentPassword: "old-password", NewPassword: "new-password"},
+			expectedCode: 200,
+			expectedBody: MsgStatusOK,
+		},
+	}
+
+	hnd := http.HandlerFunc(api.ChangePassword)
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/api/user/password/change", strings.NewReader(string(marshal(tc.request, t))))
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			hnd.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedCode, rec.Code)
+			require.Contains(t, rec.Body.String(), tc.expectedBody)
+		})
+	}
+
+	testLogIn(t, api, "tester", "new-password")
+
+	check := func(cookie *http.Cookie) error {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		_, err := api.Auth.CheckSession(httptest.NewRecorder(), req)
+		return err
+	}
+	require.NoError(t, check(cookie), "session which changed the password must be kept")
+	require.Error(t, check(other), "other sessions must be revoked after password change")
+}
+
+func Test_PasswordReset(t *testing.T) {
+	api := testAPI(t)
+	testRegister(t, api, "tester", "old-password")
+	notifier := api.Notifier.(*recordingNotifier)
+
+	request := func(hnd http.HandlerFunc, obj interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(string(marshal(obj, t))))
+		hnd.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Unknown email must not be revealed
+	rec := request(api.RequestPasswordReset, PasswordResetRequest{Email: "unknown@example.com"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Empty(t, notifier.messages, "no message must be sent for unknown email")
+
+	rec = request(api.RequestPasswordReset, PasswordResetRequest{Email: "tester@example.com"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, notifier.messages, 1, "expected reset link to be sent")
+	require.Equal(t, "tester@example.com", notifier.messages[0].To)
+
+	// Limit is applied regardless of whether the account exists
+	for _, email := range []string{"Tester@example.com", "unknown@example.com"} {
+		rec = request(api.RequestPasswordReset, PasswordResetRequest{Email: email})
+		require.Equal(t, http.StatusTooManyRequests, rec.Code, "expected reset requests of '%s' to be limited", email)
+	}
+	require.Len(t, notifier.messages, 1, "limited request must not send a link")
+
+	body := notifier.messages[0].Body
+	i := strings.Index(body, api.PasswordResetURL)
+	require.NotEqual(t, -1, i, "message must contain reset link")
+	token := strings.Fields(body[i+len(api.PasswordResetURL):])[0]
+
+	rec = request(api.ResetPassword, PasswordResetConfirmRequest{Token: "invalid", NewPassword: "new-password"})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "token is invalid or expired")
+
+	rec = request(api.ResetPassword, PasswordResetConfirmRequest{Token: token, NewPassword: "short"})
+	require.Equal(t, http.StatusBadRequest, rec.Code, "weak password must be rejected")
+
+	rec = request(api.ResetPassword, PasswordResetConfirmRequest{Token: token, NewPassword: "new-password"})
+	require.Equal(t, http.StatusOK, rec.Code, "reset failed: %s", rec.Body.String())
+	testLogIn(t, api, "tester", "new-password")
+
+	rec = request(api.ResetPassword, PasswordResetConfirmRequest{Token: token, NewPassword: "other-password"})
+	require.Equal(t, http.StatusBadRequest, rec.Code, "token must be single-use")
+}
+
+// failingPasswordStorage fails password updates, including the ones in transactions
+type failingPasswordStorage struct {
+	storage.DB
+}
+
+func (fps failingPasswordStorage) UpdateUserPassword(userID int, passwordSalt, passwordHash string) error {
+	return errors.New("disk is full")
+}
+
+func (fps failingPasswordStorage) WithTx(fn func(tx storage.DB) error) error {
+	return fps.DB.WithTx(func(tx storage.DB) error { return fn(failingPasswordStorage{tx}) })
+}
+
+func Test_PasswordReset_KeepsTokenOnFailure(t *testing.T) {
+	api := testAPI(t)
+	user := testRegister(t, api, "tester", "old-password")
+	token := "TESTTOKEN"
+	require.NoError(t, api.DB.StorePasswordResetToken(types.PasswordResetToken{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}), "failed to store password reset token")
+
+	reset := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(string(marshal(PasswordResetConfirmRequest{Token: token, NewPassword: "new-password"}, t))))
+		http.HandlerFunc(api.ResetPassword).ServeHTTP(rec, req)
+		return rec
+	}
+
+	db := api.DB
+	api.DB = failingPasswordStorage{db}
+	rec := reset()
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Contains(t, rec.Body.String(), "disk is full")
+
+	api.DB = db
+	rec = reset()
+	require.Equal(t, http.StatusOK, rec.Code, "expected token to be kept after failed reset: %s", rec.Body.String())
+	testLogIn(t, api, "tester", "new-password")
+}
+
+func Test_RequestPasswordReset_NotifierFailed(t *testing.T) {
+	api := testAPI(t)
+	testRegister(t, api, "tester", "old-password")
+	api.Notifier = failingNotifier{}
+
+	for _, email := range []string{"tester@example.com", "unknown@example.com"} {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(string(marshal(PasswordResetRequest{Email: email}, t))))
+		http.HandlerFunc(api.RequestPasswordReset).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, "response must not reveal whether '%s' account exists", email)
+		require.Equal(t, MsgStatusOK, rec.Body.String())
+	}
+}
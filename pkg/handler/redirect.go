@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// RedirectToHTTPS returns a handler which permanently redirects every request
+// to the same host and path over HTTPS on the port
+func RedirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if len(host) == 0 {
+			http.Error(w, "host is not provided", http.StatusBadRequest)
+			return
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			// IPv6 address must be bracketed without a port as well
+			host = "[" + host + "]"
+		}
+		// Permanent redirect keeps request method and body
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RedirectToHTTPS(t *testing.T) {
+	tt := []struct {
+		name     string
+		port     int
+		host     string
+		url      string
+		expected string
+	}{
+		{name: "Default port", port: 443, host: "example.com", url: "/api/login", expected: "https://example.com/api/login"},
+		{name: "Host with port", port: 8443, host: "example.com:8080", url: "/api/data/get?key=1", expected: "https://example.com:8443/api/data/get?key=1"},
+		{name: "IPv6 host", port: 8443, host: "[::1]:8080", url: "/", expected: "https://[::1]:8443/"},
+		{name: "IPv6 host on default port", port: 443, host: "[::1]:8080", url: "/", expected: "https://[::1]/"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tc.url, nil)
+			req.Host = tc.host
+			RedirectToHTTPS(tc.port).ServeHTTP(rec, req)
+			require.Equal(t, http.StatusPermanentRedirect, rec.Code)
+			require.Equal(t, tc.expected, rec.Header().Get("Location"))
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = ""
+	RedirectToHTTPS(443).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code, "request without host can't be redirected")
+}
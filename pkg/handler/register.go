@@ -4,9 +4,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
 
 	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/notify"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
 	"github.com/sergeikus/go-rest-template/pkg/types"
 )
 
@@ -18,20 +25,64 @@ type RegisterUserRequest struct {
 	Email    string `json:"email"`
 }
 
-var errRegisterUserRequestNoUsername = errors.New("username is not provided")
-var errRegisterUserRequestNoPassword = errors.New("password is not provided")
+const (
+	maxUsernameLength = 50
+	maxFullnameLength = 255
+	maxEmailLength    = 100
+)
+
+// usernameRegexp allows latin letters, digits, '.', '_' and '-' and
+// requires username to start with a letter or a digit
+var usernameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{2,49}$`)
+
+// Validate performs register user request validation against
+// a password policy, returned error is ValidationErrors.
+// Email is normalized to lower case, as emails are unique regardless of case
+func (rur *RegisterUserRequest) Validate(policy *auth.PasswordPolicy) error {
+	var ve ValidationErrors
+
+	rur.Email = normalizeEmail(rur.Email)
 
-// Validate performs register user request validation
-func (rur *RegisterUserRequest) Validate() error {
-	if len(rur.Username) == 0 {
-		return errRegisterUserRequestNoUsername
+	switch {
+	case len(rur.Username) == 0:
+		ve.Add("username", "username is not provided")
+	case !usernameRegexp.MatchString(rur.Username):
+		ve.Add("username", fmt.Sprintf("username must be 3-%d characters long and contain only letters, digits, '.', '_' or '-'", maxUsernameLength))
 	}
-	if len(rur.Password) == 0 {
-		return errRegisterUserRequestNoPassword
+
+	if len(rur.Fullname) > maxFullnameLength {
+		ve.Add("fullname", fmt.Sprintf("fullname must be at most %d characters long", maxFullnameLength))
+	}
+
+	switch {
+	case len(rur.Email) == 0:
+		ve.Add("email", "email is not provided")
+	case len(rur.Email) > maxEmailLength:
+		ve.Add("email", fmt.Sprintf("email must be at most %d characters long", maxEmailLength))
+	default:
+		if addr, err := mail.ParseAddress(rur.Email); err != nil || addr.Address != rur.Email {
+			ve.Add("email", "email is not a valid address")
+		}
+	}
+
+	if policy == nil {
+		policy = &auth.PasswordPolicy{}
+	}
+	for _, err := range policy.Check(rur.Password) {
+		ve.Add("password", err.Error())
+	}
+
+	if len(ve) != 0 {
+		return ve
 	}
 	return nil
 }
 
+// normalizeEmail trims spaces and lowers the case of an email
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 const registerUserTag = "RegisterUser"
 
 // RegisterUser performs user registration in the database
@@ -40,10 +91,15 @@ func (api *API) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 		var rur RegisterUserRequest
 		if err := decoder.Decode(&rur); err != nil {
-			fail(w, registerUserTag, fmt.Errorf("failed to decode body request: %v", err), http.StatusBadRequest)
+			fail(w, registerUserTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
 			return
 		}
-		if err := rur.Validate(); err != nil {
+		if err := rur.Validate(api.PasswordPolicy); err != nil {
+			var ve ValidationErrors
+			if errors.As(err, &ve) {
+				failValidation(w, registerUserTag, ve)
+				return
+			}
 			fail(w, registerUserTag, fmt.Errorf("register user request validation failed: %v", err), http.StatusBadRequest)
 			return
 		}
@@ -58,16 +114,129 @@ func (api *API) RegisterUser(w http.ResponseWriter, r *http.Request) {
 
 		user := types.User{
 			Username:     rur.Username,
+			Fullname:     rur.Fullname,
 			PasswordSalt: passwordSalt,
 			PasswordHash: passwordHash,
 			Email:        rur.Email,
 			IsDisabled:   false,
+			IsVerified:   api.EmailVerifier == nil,
 		}
-		if _, err := api.DB.RegisterUser(user); err != nil {
-			fail(w, logInTag, fmt.Errorf("failed to register new user: %v", err), http.StatusInternalServerError)
+		userID, err := api.DB.RegisterUser(user)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserExists) {
+				fail(w, registerUserTag, fmt.Errorf("failed to register new user: %w", err), http.StatusConflict)
+				return
+			}
+			fail(w, registerUserTag, fmt.Errorf("failed to register new user: %v", err), http.StatusInternalServerError)
 			return
 		}
+		// Email is sent after the user is stored, so the link always belongs to an existing
+		// account, if sending fails a new link can be requested with ResendVerification
+		if api.EmailVerifier != nil {
+			if err := api.sendVerificationEmail(userID, user); err != nil {
+				log.Printf("[%s] Error: failed to send verification email to user with '%s' username: %v", registerUserTag, user.Username, err)
+			}
+		}
 
 		writeResponseString(w, MsgStatusOK, registerUserTag, fmt.Sprintf("Successfully registered user with '%s' username", rur.Username))
 	}
 }
+
+// sendVerificationEmail sends a signed verification link of the user email
+func (api *API) sendVerificationEmail(userID int, user types.User) error {
+	token := api.EmailVerifier.Token(userID, user.Email)
+	return api.Notifier.Notify(notify.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome, %s!\n\nUse the following link to verify your email address (valid for %v):\n%s%s",
+			user.Username, api.EmailVerifier.TokenDuration(), api.EmailVerificationURL, url.QueryEscape(token)),
+	})
+}
+
+const verifyEmailTag = "VerifyEmail"
+
+// VerifyEmail marks user email as verified using a signed token
+func (api *API) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if api.EmailVerifier == nil {
+			fail(w, verifyEmailTag, fmt.Errorf("email verification is disabled"), http.StatusNotFound)
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if len(token) == 0 {
+			fail(w, verifyEmailTag, fmt.Errorf("token must be provided"), http.StatusBadRequest)
+			return
+		}
+
+		userID, email, err := api.EmailVerifier.Verify(token)
+		if err != nil {
+			fail(w, verifyEmailTag, err, http.StatusBadRequest)
+			return
+		}
+		if err := api.DB.VerifyUserEmail(userID, email); err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				fail(w, verifyEmailTag, fmt.Errorf("verification token does not match any user"), http.StatusBadRequest)
+				return
+			}
+			fail(w, verifyEmailTag, fmt.Errorf("failed to verify email: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		writeResponseString(w, MsgStatusOK, verifyEmailTag, fmt.Sprintf("Successfully verified email of user with '%d' ID", userID))
+	}
+}
+
+// VerificationResendRequest requests a new email verification link
+type VerificationResendRequest struct {
+	Email string `json:"email"`
+}
+
+const resendVerificationTag = "ResendVerification"
+
+// ResendVerification sends a new verification link to an unverified user,
+// response does not reveal whether user with given email exists
+func (api *API) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if api.EmailVerifier == nil {
+			fail(w, resendVerificationTag, fmt.Errorf("email verification is disabled"), http.StatusNotFound)
+			return
+		}
+		decoder := json.NewDecoder(r.Body)
+		var vrr VerificationResendRequest
+		if err := decoder.Decode(&vrr); err != nil {
+			fail(w, resendVerificationTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+		if len(vrr.Email) == 0 {
+			failValidation(w, resendVerificationTag, ValidationErrors{{Field: "email", Message: "email is not provided"}})
+			return
+		}
+		// The limit is applied to every email, so it doesn't reveal whether the account exists
+		if interval := api.rateLimits().EmailInterval; !api.verificationResends.allow(normalizeEmail(vrr.Email), interval) {
+			fail(w, resendVerificationTag, fmt.Errorf("verification link was sent recently, retry in %v", interval), http.StatusTooManyRequests)
+			return
+		}
+
+		user, err := api.DB.GetUserByEmail(vrr.Email)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				writeResponseString(w, MsgStatusOK, resendVerificationTag, fmt.Sprintf("Verification link requested for unknown '%s' email", vrr.Email))
+				return
+			}
+			fail(w, resendVerificationTag, fmt.Errorf("failed to get user: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if user.IsVerified {
+			writeResponseString(w, MsgStatusOK, resendVerificationTag, fmt.Sprintf("Verification link requested for verified user with '%s' username", user.Username))
+			return
+		}
+		// Failure is only logged, so the response doesn't reveal that the account exists
+		if err := api.sendVerificationEmail(user.ID, user); err != nil {
+			log.Printf("[%s] Error: failed to send verification email to user with '%s' username: %v", resendVerificationTag, user.Username, err)
+			writeResponseString(w, MsgStatusOK, resendVerificationTag, "")
+			return
+		}
+
+		writeResponseString(w, MsgStatusOK, resendVerificationTag, fmt.Sprintf("Verification link sent to user with '%s' username", user.Username))
+	}
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/notify"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RegisterUser(t *testing.T) {
+	tt := []struct {
+		name           string
+		request        RegisterUserRequest
+		expectedCode   int
+		expectedBody   string
+		expectedFields []string
+	}{
+		{
+			name:           "Empty request",
+			request:        RegisterUserRequest{},
+			expectedCode:   400,
+			expectedFields: []string{"username", "email", "password"},
+		},
+		{
+			name: "Invalid username and email",
+			request: RegisterUserRequest{
+				Username: "a b",
+				Email:    "not-an-email",
+				Password: "Valid-Passw0rd",
+			},
+			expectedCode:   400,
+			expectedFields: []string{"username", "email"},
+		},
+		{
+			name: "Weak password",
+			request: RegisterUserRequest{
+				Username: "tester",
+				Email:    "tester@example.com",
+				Password: "password",
+			},
+			expectedCode:   400,
+			expectedFields: []string{"password", "password"},
+		},
+		{
+			name: "Valid registration",
+			request: RegisterUserRequest{
+				Username: "tester",
+				Fullname: "Test User",
+				Email:    "tester@example.com",
+				Password: "Valid-Passw0rd",
+			},
+			expectedCode: 200,
+			expectedBody: MsgStatusOK,
+		},
+		{
+			name: "Duplicate username",
+			request: RegisterUserRequest{
+				Username: "tester",
+				Email:    "other@example.com",
+				Password: "Valid-Passw0rd",
+			},
+			expectedCode: 409,
+			expectedBody: "user already exists",
+		},
+		{
+			name: "Duplicate email",
+			request: RegisterUserRequest{
+				Username: "other",
+				Email:    "tester@example.com",
+				Password: "Valid-Passw0rd",
+			},
+			expectedCode: 409,
+			expectedBody: "user already exists",
+		},
+		{
+			name: "Duplicate email in other case",
+			request: RegisterUserRequest{
+				Username: "other",
+				Email:    "Tester@Example.com",
+				Password: "Valid-Passw0rd",
+			},
+			expectedCode: 409,
+			expectedBody: "user already exists",
+		},
+		{
+			name: "Email is normalized",
+			request: RegisterUserRequest{
+				Username: "mixed",
+				Email:    " Mixed.Case@Example.COM ",
+				Password: "Valid-Passw0rd",
+			},
+			expectedCode: 200,
+			expectedBody: MsgStatusOK,
+		},
+	}
+
+	api := API{
+		DB:             &storage.InMemoryStorage{},
+		Auth:           auth.DefineSSM(10, 0, 1, 16, auth.DefaultCookieConfig()),
+		PasswordPolicy: &auth.PasswordPolicy{MinLength: 10, RequireDigit: true},
+	}
+	err := api.DB.Connect()
+	require.NoError(t, err, "expected to see no errors, but got: %v", err)
+
+	hnd := http.HandlerFunc(api.RegisterUser)
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/api/register/user", strings.NewReader(string(marshal(tc.request, t))))
+			hnd.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedCode, rec.Code)
+			if len(tc.expectedFields) != 0 {
+				var ver ValidationErrorResponse
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ver), "failed to unmarshal validation response")
+				require.Equal(t, StatusValidationFailed, ver.Status)
+				var fields []string
+				for _, fe := range ver.Errors {
+					fields = append(fields, fe.Field)
+				}
+				require.Equal(t, tc.expectedFields, fields, "unexpected invalid fields")
+				return
+			}
+			require.Contains(t, rec.Body.String(), tc.expectedBody)
+		})
+	}
+
+	u, err := api.DB.VerifyUserCredentials("tester", api.Auth.PBKDF2HashPassword("Valid-Passw0rd", mustSalt(t, api.DB, "tester")))
+	require.NoError(t, err, "registered user must be able to log in")
+	require.Equal(t, "Test User", u.Fullname, "fullname must be stored")
+
+	u, err = api.DB.GetUserByUsername("mixed")
+	require.NoError(t, err)
+	require.Equal(t, "mixed.case@example.com", u.Email, "email must be stored in lower case")
+}
+
+func mustSalt(t *testing.T, db storage.DB, username string) string {
+	salt, err := db.GetUserSalt(username)
+	require.NoError(t, err, "failed to get user salt: %v", err)
+	return salt
+}
+
+func Test_EmailVerification(t *testing.T) {
+	api := testAPI(t)
+	api.EmailVerifier = auth.DefineEmailVerifier("TESTKEYTESTKEYTESTKEYTESTKEYTEST", time.Hour)
+	api.EmailVerificationURL = "https://localhost/api/register/verify?token="
+	notifier := api.Notifier.(*recordingNotifier)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/register/user", strings.NewReader(string(marshal(RegisterUserRequest{
+		Username: "tester",
+		Email:    "tester@example.com",
+		Password: "Valid-Passw0rd",
+	}, t))))
+	http.HandlerFunc(api.RegisterUser).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, "registration failed: %s", rec.Body.String())
+	require.Len(t, notifier.messages, 1, "expected verification link to be sent")
+
+	// Log in is blocked until email is verified
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/api/login", strings.NewReader(string(marshal(LogInRequest{Username: "tester", Password: "Valid-Passw0rd"}, t))))
+	http.HandlerFunc(api.LogIn).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	body := notifier.messages[0].Body
+	link := body[strings.Index(body, api.EmailVerificationURL):]
+
+	tt := []struct {
+		name         string
+		target       string
+		expectedCode int
+	}{
+		{name: "Token not provided", target: "/api/register/verify", expectedCode: 400},
+		{name: "Invalid token", target: "/api/register/verify?token=invalid", expectedCode: 400},
+		{name: "Valid token", target: strings.TrimPrefix(link, "https://localhost"), expectedCode: 200},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, tc.target, nil)
+			http.HandlerFunc(api.VerifyEmail).ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+
+	testLogIn(t, api, "tester", "Valid-Passw0rd")
+}
+
+// failingNotifier fails to deliver every message
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(m notify.Message) error {
+	return errors.New("mail server is unavailable")
+}
+
+func Test_RegisterUser_VerificationEmailFailed(t *testing.T) {
+	api := testAPI(t)
+	api.EmailVerifier = auth.DefineEmailVerifier("TESTKEYTESTKEYTESTKEYTESTKEYTEST", time.Hour)
+	api.EmailVerificationURL = "https://localhost/api/register/verify?token="
+
+	register := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/register/user", strings.NewReader(string(marshal(RegisterUserRequest{
+			Username: "tester",
+			Email:    "tester@example.com",
+			Password: "Valid-Passw0rd",
+		}, t))))
+		http.HandlerFunc(api.RegisterUser).ServeHTTP(rec, req)
+		return rec
+	}
+
+	notifier := api.Notifier
+	api.Notifier = failingNotifier{}
+	rec := register()
+	require.Equal(t, http.StatusOK, rec.Code, "registration must not depend on the mail server: %s", rec.Body.String())
+	user, err := api.DB.GetUserByUsername("tester")
+	require.NoError(t, err, "expected user to be stored")
+	require.False(t, user.IsVerified)
+
+	api.Notifier = notifier
+	rec = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/register/verify/resend", strings.NewReader(string(marshal(VerificationResendRequest{Email: "tester@example.com"}, t))))
+	http.HandlerFunc(api.ResendVerification).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Len(t, notifier.(*recordingNotifier).messages, 1, "expected verification link to be resent")
+}
+
+func Test_ResendVerification(t *testing.T) {
+	api := testAPI(t)
+	api.EmailVerifier = auth.DefineEmailVerifier("TESTKEYTESTKEYTESTKEYTESTKEYTEST", time.Hour)
+	api.EmailVerificationURL = "https://localhost/api/register/verify?token="
+	notifier := api.Notifier.(*recordingNotifier)
+	testRegisterUser(t, api, types.User{Username: "unverified"}, "Valid-Passw0rd")
+	testRegisterUser(t, api, types.User{Username: "verified", IsVerified: true}, "Valid-Passw0rd")
+
+	tt := []struct {
+		name             string
+		email            string
+		expectedCode     int
+		expectedMessages int
+	}{
+		{name: "Email not provided", email: "", expectedCode: 400},
+		{name: "Unknown email", email: "unknown@example.com", expectedCode: 200},
+		{name: "Verified user", email: "verified@example.com", expectedCode: 200},
+		{name: "Unverified user", email: "unverified@example.com", expectedCode: 200, expectedMessages: 1},
+		{name: "Resent too early", email: "Unverified@example.com", expectedCode: 429, expectedMessages: 1},
+		{name: "Unknown email resent too early", email: "unknown@example.com", expectedCode: 429, expectedMessages: 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/api/register/verify/resend", strings.NewReader(string(marshal(VerificationResendRequest{Email: tc.email}, t))))
+			http.HandlerFunc(api.ResendVerification).ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+			require.Len(t, notifier.messages, tc.expectedMessages)
+		})
+	}
+	require.Equal(t, "unverified@example.com", notifier.messages[0].To)
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+)
+
+// SessionInfo describes an active session, session ID is not disclosed
+type SessionInfo struct {
+	Handle       string    `json:"handle"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastActivity time.Time `json:"lastActivity"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"userAgent"`
+	// Set for the session which performed the request
+	Current bool `json:"current"`
+}
+
+// RevokeSessionRequest is a request to revoke user session
+type RevokeSessionRequest struct {
+	Handle string `json:"handle"`
+}
+
+// RevokeUserSessionsRequest is an admin request to revoke every session of a user
+type RevokeUserSessionsRequest struct {
+	UserID int `json:"userId"`
+}
+
+// RevokedSessionsResponse contains number of revoked sessions
+type RevokedSessionsResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+const listSessionsTag = "ListSessions"
+
+// ListSessions returns active sessions of a logged in user
+func (api *API) ListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		session, err := api.scopedSession(w, r, auth.ScopeAccount)
+		if err != nil {
+			fail(w, listSessionsTag, err, authCode(err))
+			return
+		}
+
+		infos := []SessionInfo{}
+		for _, s := range api.Auth.ListSessions(session.UserID) {
+			infos = append(infos, SessionInfo{
+				Handle:       s.Handle(),
+				CreatedAt:    s.CreatedAt,
+				LastActivity: s.LastActivity,
+				IP:           s.IP,
+				UserAgent:    s.UserAgent,
+				Current:      s.ID == session.ID,
+			})
+		}
+		writeReponseObject(w, infos, listSessionsTag, "")
+	}
+}
+
+const revokeSessionTag = "RevokeSession"
+
+// RevokeSession revokes one of the sessions of a logged in user
+func (api *API) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, err := api.scopedSession(w, r, auth.ScopeAccount)
+		if err != nil {
+			fail(w, revokeSessionTag, err, authCode(err))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var rsr RevokeSessionRequest
+		if err := decoder.Decode(&rsr); err != nil {
+			fail(w, revokeSessionTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+		if err := api.Auth.RevokeSession(session.UserID, rsr.Handle); err != nil {
+			fail(w, revokeSessionTag, err, http.StatusNotFound)
+			return
+		}
+
+		writeResponseString(w, MsgStatusOK, revokeSessionTag, fmt.Sprintf("Revoked session of user with '%s' username", session.Username))
+	}
+}
+
+const logoutEverywhereTag = "LogoutEverywhere"
+
+// LogoutEverywhere revokes every session of a logged in user including the current one
+func (api *API) LogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, err := api.scopedSession(w, r, auth.ScopeAccount)
+		if err != nil {
+			fail(w, logoutEverywhereTag, err, authCode(err))
+			return
+		}
+
+		count := api.Auth.RevokeUserSessions(session.UserID)
+		writeReponseObject(w, RevokedSessionsResponse{Revoked: count}, logoutEverywhereTag,
+			fmt.Sprintf("Revoked %d sessions of user with '%s' username", count, session.Username))
+	}
+}
+
+const adminRevokeSessionsTag = "AdminRevokeSessions"
+
+// AdminRevokeSessions revokes every session of a given user, caller must be an admin
+func (api *API) AdminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, err := api.scopedSession(w, r, auth.ScopeAccount)
+		if err != nil {
+			fail(w, adminRevokeSessionsTag, err, authCode(err))
+			return
+		}
+		admin, err := api.DB.GetUserByID(session.UserID)
+		if err != nil {
+			fail(w, adminRevokeSessionsTag, fmt.Errorf("failed to get user: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !admin.IsAdmin {
+			fail(w, adminRevokeSessionsTag, fmt.Errorf("user with '%s' username is not an admin", session.Username), http.StatusForbidden)
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var rusr RevokeUserSessionsRequest
+		if err := decoder.Decode(&rusr); err != nil {
+			fail(w, adminRevokeSessionsTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+		if _, err := api.DB.GetUserByID(rusr.UserID); err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				fail(w, adminRevokeSessionsTag, err, http.StatusNotFound)
+				return
+			}
+			fail(w, adminRevokeSessionsTag, fmt.Errorf("failed to get user: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		count := api.Auth.RevokeUserSessions(rusr.UserID)
+		writeReponseObject(w, RevokedSessionsResponse{Revoked: count}, adminRevokeSessionsTag,
+			fmt.Sprintf("Admin '%s' revoked %d sessions of user with '%d' ID", session.Username, count, rusr.UserID))
+	}
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sergeikus/go-rest-template/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SessionManagement(t *testing.T) {
+	api := testAPI(t)
+	user := testRegister(t, api, "tester", "tester-password")
+	testRegisterUser(t, api, types.User{Username: "admin", IsAdmin: true}, "admin-password")
+
+	first := testLogIn(t, api, "tester", "tester-password")
+	testLogIn(t, api, "tester", "tester-password")
+	testLogIn(t, api, "tester", "tester-password")
+	admin := testLogIn(t, api, "admin", "admin-password")
+
+	request := func(hnd http.HandlerFunc, method string, cookie *http.Cookie, obj interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/", strings.NewReader(string(marshal(obj, t))))
+		req.Header.Set("User-Agent", "test-agent")
+		req.AddCookie(cookie)
+		hnd.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(api.ListSessions, http.MethodGet, first, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), first.Value, "session IDs must not be disclosed")
+	var infos []SessionInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &infos))
+	require.Len(t, infos, 3)
+	var secondHandle string
+	currentCount := 0
+	for _, i := range infos {
+		if i.Current {
+			currentCount++
+		} else if len(secondHandle) == 0 {
+			secondHandle = i.Handle
+		}
+	}
+	require.Equal(t, 1, currentCount, "exactly one session must be marked as current")
+
+	rec = request(api.RevokeSession, http.MethodPost, first, RevokeSessionRequest{Handle: secondHandle})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = request(api.RevokeSession, http.MethodPost, first, RevokeSessionRequest{Handle: secondHandle})
+	require.Equal(t, http.StatusNotFound, rec.Code, "revoked session must not exist")
+	require.Len(t, api.Auth.ListSessions(user.ID), 2)
+
+	// Regular user can't revoke sessions of other users
+	rec = request(api.AdminRevokeSessions, http.MethodPost, first, RevokeUserSessionsRequest{UserID: user.ID})
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = request(api.LogoutEverywhere, http.MethodPost, first, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"revoked":2`)
+	rec = request(api.LogInStatus, http.MethodPost, first, nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code, "current session must be revoked as well")
+
+	third := testLogIn(t, api, "tester", "tester-password")
+	rec = request(api.AdminRevokeSessions, http.MethodPost, admin, RevokeUserSessionsRequest{UserID: user.ID})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Contains(t, rec.Body.String(), `"revoked":1`)
+	rec = request(api.LogInStatus, http.MethodPost, third, nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = request(api.AdminRevokeSessions, http.MethodPost, admin, RevokeUserSessionsRequest{UserID: 100})
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
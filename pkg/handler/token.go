@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// RefreshTokenRequest exchanges a refresh token for a new pair of tokens
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+const refreshTokenTag = "RefreshToken"
+
+// RefreshToken issues new access and refresh tokens, the presented refresh
+// token is rotated and can't be used again
+func (api *API) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if api.TokenAuth == nil {
+			fail(w, refreshTokenTag, fmt.Errorf("token authorization is disabled"), http.StatusNotFound)
+			return
+		}
+		decoder := json.NewDecoder(r.Body)
+		var rtr RefreshTokenRequest
+		if err := decoder.Decode(&rtr); err != nil {
+			fail(w, refreshTokenTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+		if len(rtr.RefreshToken) == 0 {
+			fail(w, refreshTokenTag, fmt.Errorf("refresh token must be provided"), http.StatusBadRequest)
+			return
+		}
+
+		session, err := api.TokenAuth.Refresh(w, r, rtr.RefreshToken)
+		if err != nil {
+			fail(w, refreshTokenTag, err, http.StatusUnauthorized)
+			return
+		}
+
+		writeResponseString(w, MsgStatusOK, refreshTokenTag, fmt.Sprintf("Successfully refreshed tokens of user with '%s' username", session.Username))
+	}
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RefreshToken(t *testing.T) {
+	api := testAPI(t)
+	ta := auth.DefineTokenAuth(60, 3600, 1, 16, api.DB)
+	api.Auth = ta
+	api.TokenAuth = ta
+	testRegister(t, api, "tester", "password")
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/login", strings.NewReader(string(marshal(LogInRequest{Username: "tester", Password: "password"}, t))))
+	http.HandlerFunc(api.LogIn).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, "log in failed: %s", rec.Body.String())
+	refresh := rec.Header().Get(auth.RefreshTokenHeader)
+	require.NotEmpty(t, refresh, "expected refresh token to be issued")
+
+	tt := []struct {
+		name         string
+		request      RefreshTokenRequest
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "No refresh token",
+			expectedCode: 400,
+			expectedBody: "refresh token must be provided",
+		},
+		{
+			name:         "Valid refresh token",
+			request:      RefreshTokenRequest{RefreshToken: refresh},
+			expectedCode: 200,
+			expectedBody: MsgStatusOK,
+		},
+		{
+			name:         "Reused refresh token",
+			request:      RefreshTokenRequest{RefreshToken: refresh},
+			expectedCode: 401,
+			expectedBody: "refresh token was already used",
+		},
+	}
+
+	hnd := http.HandlerFunc(api.RefreshToken)
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/api/token/refresh", strings.NewReader(string(marshal(tc.request, t))))
+			hnd.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedCode, rec.Code)
+			require.Contains(t, rec.Body.String(), tc.expectedBody)
+			if tc.expectedCode == http.StatusOK {
+				require.NotEmpty(t, rec.Header().Get(auth.AccessTokenHeader), "expected access token to be issued")
+				require.NotEqual(t, refresh, rec.Header().Get(auth.RefreshTokenHeader), "expected refresh token to be rotated")
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/storage"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+)
+
+// recoveryCodesCount is a number of recovery codes issued on TOTP enrollment
+const recoveryCodesCount = 10
+
+// failureCounter counts failed attempts by key within a window
+type failureCounter struct {
+	mutex    sync.Mutex
+	failures map[string]failures
+}
+
+type failures struct {
+	count int
+	since time.Time
+}
+
+// add records a failed attempt and returns number of failed attempts of the key within the window
+func (fc *failureCounter) add(key string, window time.Duration) int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	now := time.Now()
+	if fc.failures == nil {
+		fc.failures = make(map[string]failures)
+	}
+	for k, f := range fc.failures {
+		if now.Sub(f.since) >= window {
+			delete(fc.failures, k)
+		}
+	}
+	f, exist := fc.failures[key]
+	if !exist {
+		f.since = now
+	}
+	f.count++
+	fc.failures[key] = f
+	return f.count
+}
+
+// count returns number of failed attempts of the key within the window
+func (fc *failureCounter) count(key string, window time.Duration) int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	f, exist := fc.failures[key]
+	if !exist || time.Since(f.since) >= window {
+		return 0
+	}
+	return f.count
+}
+
+// reset forgets failed attempts of the keys
+func (fc *failureCounter) reset(keys ...string) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	for _, k := range keys {
+		delete(fc.failures, k)
+	}
+}
+
+// TOTPEnrollmentResponse contains a new TOTP secret
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPConfirmRequest confirms TOTP enrollment with a code
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+// TOTPConfirmResponse contains recovery codes, they are shown only once
+type TOTPConfirmResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// LogInTOTPRequest is a second log in step, either code or recovery code must be provided
+type LogInTOTPRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// enrollmentSession returns a session allowed to enroll TOTP: either a regular
+// session or a pending one of a user who has no TOTP enabled yet
+func (api *API) enrollmentSession(w http.ResponseWriter, r *http.Request) (auth.Session, error) {
+	if session, err := api.Auth.CheckSession(w, r); err == nil {
+		if !session.HasScope(auth.ScopeAccount) {
+			return session, fmt.Errorf("'%s' scope: %w", auth.ScopeAccount, errScopeNotGranted)
+		}
+		return session, nil
+	}
+	session, err := api.Auth.CheckPendingSession(w, r)
+	if err != nil {
+		return session, err
+	}
+	totp, err := api.DB.GetTOTP(session.UserID)
+	if err == nil && totp.Enabled {
+		return session, fmt.Errorf("TOTP code must be provided to complete log in")
+	}
+	return session, nil
+}
+
+const enrollTOTPTag = "EnrollTOTP"
+
+// EnrollTOTP generates a new TOTP secret for the user, enrollment
+// must be confirmed with a code
+func (api *API) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, err := api.enrollmentSession(w, r)
+		if err != nil {
+			fail(w, enrollTOTPTag, err, authCode(err))
+			return
+		}
+
+		totp, err := api.DB.GetTOTP(session.UserID)
+		if err != nil && !errors.Is(err, storage.ErrTOTPNotFound) {
+			fail(w, enrollTOTPTag, err, http.StatusInternalServerError)
+			return
+		}
+		if totp.Enabled {
+			fail(w, enrollTOTPTag, fmt.Errorf("TOTP is already enabled"), http.StatusConflict)
+			return
+		}
+
+		secret, err := auth.GenerateTOTPSecret()
+		if err != nil {
+			fail(w, enrollTOTPTag, fmt.Errorf("failed to generate TOTP secret: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := api.DB.SaveTOTP(types.TOTP{UserID: session.UserID, Secret: secret}); err != nil {
+			fail(w, enrollTOTPTag, err, http.StatusInternalServerError)
+			return
+		}
+
+		writeReponseObject(w, TOTPEnrollmentResponse{
+			Secret: secret,
+			URI:    auth.TOTPURI(api.TOTPIssuer, session.Username, secret),
+		}, enrollTOTPTag, fmt.Sprintf("Started TOTP enrollment of user with '%s' username", session.Username))
+	}
+}
+
+const confirmTOTPTag = "ConfirmTOTP"
+
+// ConfirmTOTP enables TOTP after verifying a code and returns recovery codes.
+// Pending session of the user is completed.
+func (api *API) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, err := api.enrollmentSession(w, r)
+		if err != nil {
+			fail(w, confirmTOTPTag, err, authCode(err))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var tcr TOTPConfirmRequest
+		if err := decoder.Decode(&tcr); err != nil {
+			fail(w, confirmTOTPTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+
+		totp, err := api.DB.GetTOTP(session.UserID)
+		if err != nil {
+			if errors.Is(err, storage.ErrTOTPNotFound) {
+				fail(w, confirmTOTPTag, fmt.Errorf("TOTP enrollment is not started"), http.StatusBadRequest)
+				return
+			}
+			fail(w, confirmTOTPTag, err, http.StatusInternalServerError)
+			return
+		}
+		if totp.Enabled {
+			fail(w, confirmTOTPTag, fmt.Errorf("TOTP is already enabled"), http.StatusConflict)
+			return
+		}
+		step, err := auth.ValidateTOTP(totp.Secret, tcr.Code, time.Now(), totp.LastUsedStep)
+		if err != nil {
+			fail(w, confirmTOTPTag, err, http.StatusBadRequest)
+			return
+		}
+
+		codes, err := auth.GenerateRecoveryCodes(recoveryCodesCount)
+		if err != nil {
+			fail(w, confirmTOTPTag, err, http.StatusInternalServerError)
+			return
+		}
+		hashes := make([]string, 0, len(codes))
+		for _, c := range codes {
+			hashes = append(hashes, auth.HashToken(c))
+		}
+		if err := api.DB.StoreRecoveryCodes(session.UserID, hashes); err != nil {
+			fail(w, confirmTOTPTag, err, http.StatusInternalServerError)
+			return
+		}
+		totp.Enabled = true
+		totp.LastUsedStep = step
+		if err := api.DB.SaveTOTP(totp); err != nil {
+			fail(w, confirmTOTPTag, err, http.StatusInternalServerError)
+			return
+		}
+
+		if session.Pending {
+			if _, err := api.Auth.CompletePendingSession(w, r); err != nil {
+				fail(w, confirmTOTPTag, fmt.Errorf("failed to complete log in: %v", err), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		writeReponseObject(w, TOTPConfirmResponse{RecoveryCodes: codes}, confirmTOTPTag,
+			fmt.Sprintf("Enabled TOTP of user with '%s' username", session.Username))
+	}
+}
+
+const logInTOTPTag = "LogInTOTP"
+
+// LogInTOTP completes log in with a TOTP code or a recovery code
+func (api *API) LogInTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, err := api.Auth.CheckPendingSession(w, r)
+		if err != nil {
+			fail(w, logInTOTPTag, err, http.StatusUnauthorized)
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var ltr LogInTOTPRequest
+		if err := decoder.Decode(&ltr); err != nil {
+			fail(w, logInTOTPTag, fmt.Errorf("failed to decode body request: %v", err), decodeFailCode(err, http.StatusBadRequest))
+			return
+		}
+
+		totp, err := api.DB.GetTOTP(session.UserID)
+		if err != nil || !totp.Enabled {
+			fail(w, logInTOTPTag, fmt.Errorf("TOTP is not enabled for user with '%s' username", session.Username), http.StatusUnauthorized)
+			return
+		}
+
+		sessionKey, userKey := "session:"+session.Handle(), "user:"+strconv.Itoa(session.UserID)
+		limits := api.rateLimits()
+		if api.totpFailures.count(userKey, limits.TOTPFailureWindow) >= limits.TOTPUserFailures {
+			api.Auth.RevokeSession(session.UserID, session.Handle())
+			fail(w, logInTOTPTag, fmt.Errorf("too many failed attempts of user with '%s' username, retry later", session.Username), http.StatusTooManyRequests)
+			return
+		}
+
+		switch {
+		case len(ltr.Code) != 0:
+			step, err := auth.ValidateTOTP(totp.Secret, ltr.Code, time.Now(), totp.LastUsedStep)
+			if err == nil {
+				// Step is consumed atomically, so concurrent requests can't use the same code
+				if err = api.DB.UseTOTPStep(session.UserID, step); errors.Is(err, storage.ErrInvalidToken) {
+					err = fmt.Errorf("TOTP code was already used")
+				} else if err != nil {
+					fail(w, logInTOTPTag, err, http.StatusInternalServerError)
+					return
+				}
+			}
+			if err != nil {
+				api.failTOTP(w, session, sessionKey, userKey, err)
+				return
+			}
+		case len(ltr.RecoveryCode) != 0:
+			if err := api.DB.ConsumeRecoveryCode(session.UserID, auth.HashToken(auth.NormalizeRecoveryCode(ltr.RecoveryCode))); err != nil {
+				api.failTOTP(w, session, sessionKey, userKey, fmt.Errorf("recovery code is invalid"))
+				return
+			}
+		default:
+			fail(w, logInTOTPTag, fmt.Errorf("code or recovery code must be provided"), http.StatusBadRequest)
+			return
+		}
+		api.totpFailures.reset(sessionKey, userKey)
+
+		if _, err := api.Auth.CompletePendingSession(w, r); err != nil {
+			fail(w, logInTOTPTag, fmt.Errorf("failed to complete log in: %v", err), http.StatusUnauthorized)
+			return
+		}
+
+		writeResponseString(w, MsgStatusOK, logInTOTPTag, fmt.Sprintf("Successfully logged in user with '%s' username", session.Username))
+	}
+}
+
+// failTOTP records a failed second factor attempt, pending session is
+// revoked after the configured number of attempts
+func (api *API) failTOTP(w http.ResponseWriter, session auth.Session, sessionKey, userKey string, err error) {
+	limits := api.rateLimits()
+	api.totpFailures.add(userKey, limits.TOTPFailureWindow)
+	if api.totpFailures.add(sessionKey, limits.TOTPFailureWindow) >= limits.TOTPSessionFailures {
+		api.Auth.RevokeSession(session.UserID, session.Handle())
+		api.totpFailures.reset(sessionKey)
+		err = fmt.Errorf("%v, too many failed attempts, log in again", err)
+	}
+	fail(w, logInTOTPTag, err, http.StatusUnauthorized)
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TOTPTwoStepLogIn(t *testing.T) {
+	api := testAPI(t)
+	api.TOTPIssuer = "test"
+	api.RequireTOTPForAdmins = true
+	testRegisterUser(t, api, types.User{Username: "admin", IsAdmin: true}, "admin-password")
+
+	request := func(hnd http.HandlerFunc, cookie *http.Cookie, obj interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(string(marshal(obj, t))))
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		hnd.ServeHTTP(rec, req)
+		return rec
+	}
+	sessionCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		cookies := rec.Result().Cookies()
+		require.NotEmpty(t, cookies, "expected session cookie to be set")
+		return cookies[0]
+	}
+
+	// Admin must enroll TOTP on the first log in
+	rec := request(api.LogIn, nil, LogInRequest{Username: "admin", Password: "admin-password"})
+	require.Equal(t, MsgStatusTOTPEnrollmentRequired, rec.Body.String())
+	pending := sessionCookie(rec)
+
+	rec = request(api.LogInStatus, pending, nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code, "pending session must not be authenticated")
+
+	rec = request(api.EnrollTOTP, pending, nil)
+	require.Equal(t, http.StatusOK, rec.Code, "enrollment failed: %s", rec.Body.String())
+	var ter TOTPEnrollmentResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ter))
+	require.Contains(t, ter.URI, "otpauth://totp/test:admin?")
+
+	rec = request(api.ConfirmTOTP, pending, TOTPConfirmRequest{Code: "000000"})
+	require.Equal(t, http.StatusBadRequest, rec.Code, "invalid code must be rejected")
+
+	code, err := auth.TOTPCode(ter.Secret, time.Now())
+	require.NoError(t, err)
+	rec = request(api.ConfirmTOTP, pending, TOTPConfirmRequest{Code: code})
+	require.Equal(t, http.StatusOK, rec.Code, "confirmation failed: %s", rec.Body.String())
+	var tcr TOTPConfirmResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tcr))
+	require.Len(t, tcr.RecoveryCodes, recoveryCodesCount)
+	full := sessionCookie(rec)
+	require.NotEqual(t, pending.Value, full.Value, "session ID must be regenerated")
+
+	rec = request(api.LogInStatus, full, nil)
+	require.Equal(t, http.StatusOK, rec.Code, "session must be authenticated after enrollment")
+
+	// Subsequent log in requires a TOTP code
+	rec = request(api.LogIn, nil, LogInRequest{Username: "admin", Password: "admin-password"})
+	require.Equal(t, MsgStatusTOTPRequired, rec.Body.String())
+	pending = sessionCookie(rec)
+
+	rec = request(api.LogInTOTP, pending, LogInTOTPRequest{Code: code})
+	require.Equal(t, http.StatusUnauthorized, rec.Code, "code must not be accepted twice")
+
+	rec = request(api.LogInTOTP, pending, LogInTOTPRequest{RecoveryCode: strings.ToUpper(tcr.RecoveryCodes[0])})
+	require.Equal(t, http.StatusOK, rec.Code, "recovery code must be accepted: %s", rec.Body.String())
+
+	rec = request(api.LogIn, nil, LogInRequest{Username: "admin", Password: "admin-password"})
+	pending = sessionCookie(rec)
+	rec = request(api.LogInTOTP, pending, LogInTOTPRequest{RecoveryCode: tcr.RecoveryCodes[0]})
+	require.Equal(t, http.StatusUnauthorized, rec.Code, "recovery code must be single-use")
+}
+
+func Test_LogInTOTP_FailedAttempts(t *testing.T) {
+	api := testAPI(t)
+	user := testRegister(t, api, "tester", "tester-password")
+	secret, err := auth.GenerateTOTPSecret()
+	require.NoError(t, err)
+	require.NoError(t, api.DB.SaveTOTP(types.TOTP{UserID: user.ID, Secret: secret, Enabled: true}))
+	code, err := auth.TOTPCode(secret, time.Now())
+	require.NoError(t, err)
+	// A code which doesn't match any accepted step
+	accepted := map[string]bool{}
+	for _, d := range []time.Duration{-auth.TOTPPeriod, 0, auth.TOTPPeriod} {
+		c, err := auth.TOTPCode(secret, time.Now().Add(d))
+		require.NoError(t, err)
+		accepted[c] = true
+	}
+	invalid := "000000"
+	for i := 1; accepted[invalid]; i++ {
+		invalid = fmt.Sprintf("%06d", i)
+	}
+
+	request := func(hnd http.HandlerFunc, cookie *http.Cookie, obj interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(string(marshal(obj, t))))
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		hnd.ServeHTTP(rec, req)
+		return rec
+	}
+	logIn := func() *http.Cookie {
+		rec := request(api.LogIn, nil, LogInRequest{Username: "tester", Password: "tester-password"})
+		require.Equal(t, MsgStatusTOTPRequired, rec.Body.String())
+		return rec.Result().Cookies()[0]
+	}
+
+	// Pending session is revoked after too many failed attempts
+	pending := logIn()
+	for i := 1; i < defaultTOTPSessionFailures; i++ {
+		rec := request(api.LogInTOTP, pending, LogInTOTPRequest{Code: invalid})
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Contains(t, rec.Body.String(), "TOTP code is invalid")
+	}
+	rec := request(api.LogInTOTP, pending, LogInTOTPRequest{RecoveryCode: "invalid"})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Contains(t, rec.Body.String(), "too many failed attempts, log in again")
+	rec = request(api.LogInTOTP, pending, LogInTOTPRequest{Code: code})
+	require.Equal(t, http.StatusUnauthorized, rec.Code, "revoked pending session must not be completed")
+
+	// Failures are counted per user across pending sessions
+	for failed := defaultTOTPSessionFailures; failed < defaultTOTPUserFailures; failed++ {
+		if failed%defaultTOTPSessionFailures == 0 {
+			pending = logIn()
+		}
+		rec = request(api.LogInTOTP, pending, LogInTOTPRequest{Code: invalid})
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+	rec = request(api.LogInTOTP, logIn(), LogInTOTPRequest{Code: code})
+	require.Equal(t, http.StatusTooManyRequests, rec.Code, "user must be locked out after too many failed attempts")
+
+	// Successful attempt resets failures
+	api.totpFailures.reset("user:" + strconv.Itoa(user.ID))
+	rec = request(api.LogInTOTP, logIn(), LogInTOTPRequest{Code: code})
+	require.Equal(t, http.StatusOK, rec.Code, "log in failed: %s", rec.Body.String())
+	require.Zero(t, api.totpFailures.count("user:"+strconv.Itoa(user.ID), defaultTOTPFailureWindow))
+}
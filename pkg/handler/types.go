@@ -2,8 +2,12 @@ package handler
 
 import (
 	"errors"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sergeikus/go-rest-template/pkg/auth"
+	"github.com/sergeikus/go-rest-template/pkg/notify"
 	"github.com/sergeikus/go-rest-template/pkg/storage"
 )
 
@@ -11,13 +15,86 @@ import (
 type API struct {
 	DB   storage.DB
 	Auth auth.Auth
+	// Set when token authorization type is used, enables refresh endpoint
+	TokenAuth *auth.TokenAuth
+	// Request body size limit in bytes applied by LimitBody, 0 means no limit,
+	// body size limits are set only with SetBodyLimits
+	maxBodyBytes int64
+	// Body size limits of specific routes (URL paths) overriding the default one
+	routeMaxBodyBytes map[string]int64
+	bodyLimitsMutex   sync.RWMutex
+	// Session cookie attributes, CSRF cookie shares its domain, path and Secure flag
+	Cookie auth.CookieConfig
+	// If set, users can log in with an external OpenID Connect provider
+	OIDC *auth.OIDCProvider
+	// Password requirements applied on user registration,
+	// if nil only non-empty password is required
+	PasswordPolicy *auth.PasswordPolicy
+	// Delivers password reset links to users
+	Notifier notify.Notifier
+	// Password reset token lifetime
+	PasswordResetTokenDuration time.Duration
+	// Base URL of a password reset page, token is appended to it
+	PasswordResetURL string
+	// If set, new accounts are created unverified and can't log in
+	// until email is verified
+	EmailVerifier *auth.EmailVerifier
+	// Base URL of an email verification endpoint, token is appended to it
+	EmailVerificationURL string
+	// Verification links and password reset links sent by normalized email
+	verificationResends intervalLimiter
+	passwordResets      intervalLimiter
+	// Issuer name shown in authenticator apps
+	TOTPIssuer string
+	// If set, admins must enroll TOTP before their session is authenticated
+	RequireTOTPForAdmins bool
+	// Failed second factor attempts by pending session and by user
+	totpFailures failureCounter
+	// Rate limits of second factor attempts and emails sent,
+	// they are set only with SetRateLimits
+	limits      RateLimits
+	limitsMutex sync.RWMutex
 }
 
 const (
 	// MsgStatusOK represents a success string message
 	MsgStatusOK = "{\"status\": \"OK\"}"
+	// MsgStatusTOTPRequired is returned on log in when TOTP code must be provided
+	MsgStatusTOTPRequired = "{\"status\": \"TOTP_REQUIRED\"}"
+	// MsgStatusTOTPEnrollmentRequired is returned on log in when TOTP must be enrolled
+	MsgStatusTOTPEnrollmentRequired = "{\"status\": \"TOTP_ENROLLMENT_REQUIRED\"}"
+	// StatusValidationFailed is a status of a response with field validation errors
+	StatusValidationFailed = "validation failed"
 )
 
+// FieldError describes a validation problem of a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is a list of request field validation problems
+type ValidationErrors []FieldError
+
+// Add appends a field validation problem
+func (ve *ValidationErrors) Add(field, message string) {
+	*ve = append(*ve, FieldError{Field: field, Message: message})
+}
+
+func (ve ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(ve))
+	for _, fe := range ve {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidationErrorResponse is returned to the client when request fields are invalid
+type ValidationErrorResponse struct {
+	Status string           `json:"status"`
+	Errors ValidationErrors `json:"errors"`
+}
+
 // DataAdditionRequest represents for a key
 type DataAdditionRequest struct {
 	Data string `json:"data"`
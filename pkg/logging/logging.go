@@ -0,0 +1,80 @@
+// Package logging provides log level filtering of the standard logger output
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Log levels, messages are classified by 'Error:' and 'Warning:' markers,
+// other messages are informational
+const (
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+const (
+	info int32 = iota
+	warning
+	failure
+)
+
+var (
+	errorMarker   = []byte("Error:")
+	warningMarker = []byte("Warning:")
+)
+
+// DefineFilter returns a writer which writes messages of the level and above to out,
+// it is meant to be set as log output
+func DefineFilter(out io.Writer, level string) (*Filter, error) {
+	f := &Filter{out: out}
+	if err := f.SetLevel(level); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Filter drops log messages below the minimal level, the level
+// can be changed while the server is running
+type Filter struct {
+	out   io.Writer
+	level int32
+}
+
+// SetLevel sets the minimal level of the messages written, empty level means 'info'
+func (f *Filter) SetLevel(level string) error {
+	switch level {
+	case LevelInfo, "":
+		atomic.StoreInt32(&f.level, info)
+	case LevelWarning:
+		atomic.StoreInt32(&f.level, warning)
+	case LevelError:
+		atomic.StoreInt32(&f.level, failure)
+	default:
+		return fmt.Errorf("unsupported log level '%s'", level)
+	}
+	return nil
+}
+
+// Write writes the message if its level is not below the minimal one,
+// log.Logger calls Write once per message
+func (f *Filter) Write(p []byte) (int, error) {
+	if messageLevel(p) < atomic.LoadInt32(&f.level) {
+		return len(p), nil
+	}
+	return f.out.Write(p)
+}
+
+func messageLevel(p []byte) int32 {
+	switch {
+	case bytes.Contains(p, errorMarker):
+		return failure
+	case bytes.Contains(p, warningMarker):
+		return warning
+	default:
+		return info
+	}
+}
@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Filter(t *testing.T) {
+	var out bytes.Buffer
+	f, err := DefineFilter(&out, LevelWarning)
+	require.NoError(t, err)
+	logger := log.New(f, "", 0)
+
+	logger.Printf("[Tag] Info message")
+	logger.Printf("[Tag] Warning: warning message")
+	logger.Printf("[Tag] Error: error message")
+	require.Equal(t, "[Tag] Warning: warning message\n[Tag] Error: error message\n", out.String())
+
+	out.Reset()
+	require.NoError(t, f.SetLevel(LevelError))
+	logger.Printf("[Tag] Warning: warning message")
+	logger.Printf("[Tag] Error: error message")
+	require.Equal(t, "[Tag] Error: error message\n", out.String())
+
+	out.Reset()
+	require.NoError(t, f.SetLevel(LevelInfo))
+	logger.Printf("[Tag] Info message")
+	require.Equal(t, "[Tag] Info message\n", out.String())
+
+	require.Error(t, f.SetLevel("debug"), "unsupported level must be rejected")
+	_, err = DefineFilter(&out, "debug")
+	require.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// NotifierTypeLog defines notifier which writes messages to the server log
+	NotifierTypeLog = "log"
+	// NotifierTypeFile defines notifier which appends messages to a file
+	NotifierTypeFile = "file"
+	// NotifierTypeSMTP defines notifier which sends messages via an SMTP server
+	NotifierTypeSMTP = "smtp"
+)
+
+// Message represents a notification sent to a user
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier represents an interface which delivers
+// messages (e.g. password reset links) to users
+type Notifier interface {
+	Notify(m Message) error
+}
+
+// LogNotifier writes messages to the server log,
+// it is intended for development only
+type LogNotifier struct{}
+
+// Notify writes message to the log
+func (ln *LogNotifier) Notify(m Message) error {
+	log.Printf("[Notify] To: %s, Subject: %s\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// FileNotifier appends messages to a file
+type FileNotifier struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+// Notify appends message to the file
+func (fn *FileNotifier) Notify(m Message) error {
+	fn.mutex.Lock()
+	defer fn.mutex.Unlock()
+	f, err := os.OpenFile(fn.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open notification file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), m.To, m.Subject, m.Body); err != nil {
+		return fmt.Errorf("failed to write notification: %v", err)
+	}
+	return nil
+}
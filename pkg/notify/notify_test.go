@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// smtpStandIn is a minimal SMTP server which accepts a single
+// message and passes it to the channel
+func smtpStandIn(t *testing.T) (string, int, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "failed to listen: %v", err)
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		reply := func(line string) {
+			rw.WriteString(line + "\r\n")
+			rw.Flush()
+		}
+		reply("220 localhost ESMTP stand-in")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "DATA"):
+				inData = true
+				reply("354 End data with <CR><LF>.<CR><LF>")
+			case strings.HasPrefix(cmd, "QUIT"):
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, received
+}
+
+func Test_SMTPNotifier_Notify(t *testing.T) {
+	host, port, received := smtpStandIn(t)
+	sn := &SMTPNotifier{Host: host, Port: port, From: "noreply@example.com"}
+
+	err := sn.Notify(Message{To: "user@example.com", Subject: "Test\r\nBcc: evil@example.com", Body: "Hello\nWorld"})
+	require.NoError(t, err, "expected to get no error, but got: %v", err)
+
+	msg := <-received
+	require.Contains(t, msg, "To: user@example.com\r\n")
+	require.Contains(t, msg, "Subject: TestBcc: evil@example.com\r\n", "header injection must be prevented")
+	require.Contains(t, msg, "Hello\r\nWorld")
+}
+
+func Test_SMTPNotifier_Notify_Unreachable(t *testing.T) {
+	sn := &SMTPNotifier{Host: "127.0.0.1", Port: 1, From: "noreply@example.com"}
+	err := sn.Notify(Message{To: "user@example.com"})
+	require.NotNil(t, err, "expected to see an error, but got nil")
+	require.Contains(t, err.Error(), "failed to send email to 'user@example.com':")
+}
+
+func Test_FileNotifier_Notify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.txt")
+	fn := &FileNotifier{Path: path}
+
+	require.NoError(t, fn.Notify(Message{To: "a@example.com", Subject: "First", Body: "one"}))
+	require.NoError(t, fn.Notify(Message{To: "b@example.com", Subject: "Second", Body: "two"}))
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "failed to read notification file: %v", err)
+	require.Contains(t, string(b), "To: a@example.com\nSubject: First\n\none")
+	require.Contains(t, string(b), "To: b@example.com\nSubject: Second\n\ntwo")
+}
@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPNotifier sends messages as emails via an SMTP server
+type SMTPNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Notify sends message as an email
+func (sn *SMTPNotifier) Notify(m Message) error {
+	var a smtp.Auth
+	if len(sn.Username) != 0 {
+		a = smtp.PlainAuth("", sn.Username, sn.Password, sn.Host)
+	}
+
+	addr := net.JoinHostPort(sn.Host, strconv.Itoa(sn.Port))
+	if err := smtp.SendMail(addr, a, sn.From, []string{m.To}, composeEmail(sn.From, m)); err != nil {
+		return fmt.Errorf("failed to send email to '%s': %v", m.To, err)
+	}
+	return nil
+}
+
+func composeEmail(from string, m Message) []byte {
+	// Header values must not contain line breaks
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	b.WriteString("From: " + clean.Replace(from) + "\r\n")
+	b.WriteString("To: " + clean.Replace(m.To) + "\r\n")
+	b.WriteString("Subject: " + clean.Replace(m.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/sergeikus/go-rest-template/pkg/types"
 )
@@ -15,13 +17,51 @@ type InMemoryStorage struct {
 	mutex sync.Mutex
 	// Simulates primary key index
 	index int
+	// Registered users by username
+	users map[string]types.User
+	// Simulates users primary key index
+	userIndex int
+	// Password reset tokens by token hash
+	resetTokens map[string]types.PasswordResetToken
+	// TOTP second factors by user ID
+	totps map[int]types.TOTP
+	// Recovery code hashes by user ID, sets are replaced, not modified
+	recoveryCodes map[int]map[string]struct{}
+	// API keys by ID
+	apiKeys map[int]types.APIKey
+	// Simulates API keys primary key index
+	apiKeyIndex int
+	// Refresh tokens by token hash
+	refreshTokens map[string]types.RefreshToken
+	// Revoked refresh token families
+	revokedFamilies map[string]struct{}
+	// User IDs by external identity
+	identities map[identity]int
+
+	// Versions of the tables, they are changed on every write
+	versions [tablesCount]int
+	// Storage the transaction was started on, nil if it is not a transaction.
+	// Transaction tables contain only rows written by the transaction
+	parent *InMemoryStorage
+	// Versions of the parent tables read by the transaction
+	readVersions map[table]int
+	// Primary key indexes loaded from the parent storage
+	indexLoaded [tablesCount]bool
+}
+
+// identity is an external identity of a user
+type identity struct {
+	issuer  string
+	subject string
 }
 
 // Connect simulates connection to database
 func (ims *InMemoryStorage) Connect() error {
-	ims.data = make(map[int]types.Data)
+	ims.makeTables()
 	ims.mutex = sync.Mutex{}
 	ims.index = 1
+	ims.userIndex = 1
+	ims.apiKeyIndex = 1
 	return nil
 }
 
@@ -32,13 +72,12 @@ func (ims *InMemoryStorage) Store(data string) (id int, err error) {
 	}
 	ims.mutex.Lock()
 	defer ims.mutex.Unlock()
-	if _, exist := ims.data[ims.index]; exist {
-		return id, fmt.Errorf("key with '%d' ID already exists", ims.index)
+	index := ims.indexOf(dataTable)
+	if _, exist := ims.dataRow(*index); exist {
+		return id, fmt.Errorf("key with '%d' ID already exists", *index)
 	}
-	ims.data[ims.index] = types.Data{ID: ims.index, String: data}
-	id = ims.index
-
-	ims.index++
+	id = ims.nextID(dataTable)
+	ims.putData(types.Data{ID: id, String: data})
 	return id, nil
 }
 
@@ -49,7 +88,9 @@ func (ims *InMemoryStorage) GetAll() ([]types.Data, error) {
 
 // GetKey returns data for a paricular key
 func (ims *InMemoryStorage) GetKey(key int) (d types.Data, err error) {
-	d, exist := ims.data[key]
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	d, exist := ims.dataRow(key)
 	if !exist {
 		return d, fmt.Errorf("data with '%d' key does not exist", key)
 	}
@@ -63,15 +104,355 @@ func (ims *InMemoryStorage) Close() {
 
 // VerifyUserCredentials checks user login in database
 func (ims *InMemoryStorage) VerifyUserCredentials(username, passwordHash string) (types.User, error) {
-	return types.User{}, fmt.Errorf("not implemented")
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	u, exist := ims.user(username)
+	if !exist || u.PasswordHash != passwordHash {
+		return types.User{}, fmt.Errorf("failed to get user from database: invalid credentials")
+	}
+	return u, nil
 }
 
 // GetUserSalt salt returns user password salt
 func (ims *InMemoryStorage) GetUserSalt(username string) (salt string, err error) {
-	return salt, fmt.Errorf("not implemented")
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	u, exist := ims.user(username)
+	if !exist {
+		return salt, fmt.Errorf("user with '%s' username does not exist", username)
+	}
+	return u.PasswordSalt, nil
 }
 
 // RegisterUser user registers new user
 func (ims *InMemoryStorage) RegisterUser(user types.User) (id int, err error) {
-	return id, fmt.Errorf("not implemented")
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	if _, exist := ims.user(user.Username); exist {
+		return id, fmt.Errorf("username '%s' is taken: %w", user.Username, ErrUserExists)
+	}
+	if _, exist := ims.findUser(func(u types.User) bool { return strings.EqualFold(u.Email, user.Email) }); exist {
+		return id, fmt.Errorf("email '%s' is taken: %w", user.Email, ErrUserExists)
+	}
+	user.ID = ims.nextID(usersTable)
+	ims.putUser(user)
+	return user.ID, nil
+}
+
+// GetUserByID returns user with a given ID
+func (ims *InMemoryStorage) GetUserByID(userID int) (types.User, error) {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	if u, exist := ims.findUser(func(u types.User) bool { return u.ID == userID }); exist {
+		return u, nil
+	}
+	return types.User{}, fmt.Errorf("user with '%d' ID: %w", userID, ErrUserNotFound)
+}
+
+// GetUserByUsername returns user with a given username
+func (ims *InMemoryStorage) GetUserByUsername(username string) (types.User, error) {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	u, exist := ims.user(username)
+	if !exist {
+		return types.User{}, fmt.Errorf("user with '%s' username: %w", username, ErrUserNotFound)
+	}
+	return u, nil
+}
+
+// GetUserByEmail returns user with a given email
+func (ims *InMemoryStorage) GetUserByEmail(email string) (types.User, error) {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	if u, exist := ims.findUser(func(u types.User) bool { return strings.EqualFold(u.Email, email) }); exist {
+		return u, nil
+	}
+	return types.User{}, fmt.Errorf("user with '%s' email: %w", email, ErrUserNotFound)
+}
+
+// UpdateUserPassword sets new user password salt and hash
+func (ims *InMemoryStorage) UpdateUserPassword(userID int, passwordSalt, passwordHash string) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	u, exist := ims.findUser(func(u types.User) bool { return u.ID == userID })
+	if !exist {
+		return fmt.Errorf("user with '%d' ID: %w", userID, ErrUserNotFound)
+	}
+	u.PasswordSalt = passwordSalt
+	u.PasswordHash = passwordHash
+	ims.putUser(u)
+	return nil
+}
+
+// VerifyUserEmail marks user email as verified, email must match
+// the current user email
+func (ims *InMemoryStorage) VerifyUserEmail(userID int, email string) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	u, exist := ims.findUser(func(u types.User) bool { return u.ID == userID && strings.EqualFold(u.Email, email) })
+	if !exist {
+		return fmt.Errorf("user with '%d' ID and '%s' email: %w", userID, email, ErrUserNotFound)
+	}
+	u.IsVerified = true
+	ims.putUser(u)
+	return nil
+}
+
+// StorePasswordResetToken stores password reset token
+func (ims *InMemoryStorage) StorePasswordResetToken(token types.PasswordResetToken) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	if _, exist := ims.resetToken(token.TokenHash); exist {
+		return fmt.Errorf("password reset token already exists")
+	}
+	ims.putResetToken(token)
+	return nil
+}
+
+// ConsumePasswordResetToken marks password reset token as used and returns
+// its user ID, every other token of the user is invalidated as well
+func (ims *InMemoryStorage) ConsumePasswordResetToken(tokenHash string) (userID int, err error) {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	token, exist := ims.resetToken(tokenHash)
+	if !exist || token.Used || time.Now().After(token.ExpiresAt) {
+		return userID, ErrInvalidToken
+	}
+	var used []types.PasswordResetToken
+	ims.eachResetToken(func(t types.PasswordResetToken) bool {
+		if t.UserID == token.UserID {
+			t.Used = true
+			used = append(used, t)
+		}
+		return true
+	})
+	for _, t := range used {
+		ims.putResetToken(t)
+	}
+	return token.UserID, nil
+}
+
+// GetTOTP returns TOTP second factor of the user
+func (ims *InMemoryStorage) GetTOTP(userID int) (types.TOTP, error) {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	totp, exist := ims.totp(userID)
+	if !exist {
+		return totp, fmt.Errorf("user with '%d' ID: %w", userID, ErrTOTPNotFound)
+	}
+	return totp, nil
+}
+
+// SaveTOTP creates or replaces TOTP second factor of the user
+func (ims *InMemoryStorage) SaveTOTP(totp types.TOTP) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	ims.putTOTP(totp)
+	return nil
+}
+
+// UseTOTPStep records a used TOTP time step if it is later than the last used one
+func (ims *InMemoryStorage) UseTOTPStep(userID int, step int64) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	totp, exist := ims.totp(userID)
+	if !exist || totp.LastUsedStep >= step {
+		return ErrInvalidToken
+	}
+	totp.LastUsedStep = step
+	ims.putTOTP(totp)
+	return nil
+}
+
+// StoreRecoveryCodes replaces recovery codes of the user
+func (ims *InMemoryStorage) StoreRecoveryCodes(userID int, codeHashes []string) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	codes := make(map[string]struct{}, len(codeHashes))
+	for _, h := range codeHashes {
+		codes[h] = struct{}{}
+	}
+	ims.putRecoveryCodes(userID, codes)
+	return nil
+}
+
+// ConsumeRecoveryCode removes recovery code of the user,
+// error is returned if the code does not exist
+func (ims *InMemoryStorage) ConsumeRecoveryCode(userID int, codeHash string) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	codes := ims.userRecoveryCodes(userID)
+	if _, exist := codes[codeHash]; !exist {
+		return ErrInvalidToken
+	}
+	// Sets may be shared with transactions, so the set is replaced
+	left := make(map[string]struct{}, len(codes)-1)
+	for h := range codes {
+		if h != codeHash {
+			left[h] = struct{}{}
+		}
+	}
+	ims.putRecoveryCodes(userID, left)
+	return nil
+}
+
+// CreateAPIKey stores API key
+func (ims *InMemoryStorage) CreateAPIKey(key types.APIKey) (id int, err error) {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	if _, exist := ims.findAPIKey(func(k types.APIKey) bool { return k.Prefix == key.Prefix }); exist {
+		return id, fmt.Errorf("API key with '%s' prefix already exists", key.Prefix)
+	}
+	key.ID = ims.nextID(apiKeysTable)
+	ims.putAPIKey(key)
+	return key.ID, nil
+}
+
+// ListAPIKeys returns API keys of the user which are not revoked
+func (ims *InMemoryStorage) ListAPIKeys(userID int) ([]types.APIKey, error) {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	keys := []types.APIKey{}
+	for id := 1; id < *ims.indexOf(apiKeysTable); id++ {
+		if k, exist := ims.apiKey(id); exist && k.UserID == userID && !k.Revoked {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes API key of the user
+func (ims *InMemoryStorage) RevokeAPIKey(userID, keyID int) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	k, exist := ims.apiKey(keyID)
+	if !exist || k.UserID != userID || k.Revoked {
+		return fmt.Errorf("API key with '%d' ID: %w", keyID, ErrAPIKeyNotFound)
+	}
+	k.Revoked = true
+	ims.putAPIKey(k)
+	return nil
+}
+
+// GetAPIKeyByPrefix returns API key with its owner username
+func (ims *InMemoryStorage) GetAPIKeyByPrefix(prefix string) (types.APIKey, error) {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	k, exist := ims.findAPIKey(func(k types.APIKey) bool { return k.Prefix == prefix })
+	if !exist {
+		return types.APIKey{}, fmt.Errorf("API key with '%s' prefix: %w", prefix, ErrAPIKeyNotFound)
+	}
+	if u, exist := ims.findUser(func(u types.User) bool { return u.ID == k.UserID }); exist {
+		k.Username = u.Username
+	}
+	return k, nil
+}
+
+// UpdateAPIKeyLastUsed sets API key last usage time
+func (ims *InMemoryStorage) UpdateAPIKeyLastUsed(keyID int, t time.Time) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	k, exist := ims.apiKey(keyID)
+	if !exist {
+		return fmt.Errorf("API key with '%d' ID: %w", keyID, ErrAPIKeyNotFound)
+	}
+	k.LastUsedAt = &t
+	ims.putAPIKey(k)
+	return nil
+}
+
+// StoreRefreshToken stores refresh token, token family is created with the first token
+func (ims *InMemoryStorage) StoreRefreshToken(token types.RefreshToken) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	if _, exist := ims.refreshToken(token.TokenHash); exist {
+		return fmt.Errorf("refresh token already exists")
+	}
+	ims.putRefreshToken(token)
+	return nil
+}
+
+// UseRefreshToken marks refresh token as used and returns it,
+// if the token was already used it is returned with ErrRefreshTokenReused
+func (ims *InMemoryStorage) UseRefreshToken(tokenHash string) (types.RefreshToken, error) {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	token, exist := ims.refreshToken(tokenHash)
+	if !exist {
+		return types.RefreshToken{}, ErrInvalidToken
+	}
+	if token.Used {
+		return token, ErrRefreshTokenReused
+	}
+	if ims.familyRevoked(token.FamilyID) || time.Now().After(token.ExpiresAt) {
+		return types.RefreshToken{}, ErrInvalidToken
+	}
+	token.Used = true
+	ims.putRefreshToken(token)
+	return token, nil
+}
+
+// RevokeRefreshTokenFamily invalidates every refresh token of the family
+func (ims *InMemoryStorage) RevokeRefreshTokenFamily(familyID string) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	ims.revokeFamily(familyID)
+	return nil
+}
+
+// RevokeUserRefreshTokens invalidates every refresh token family of
+// the user except the kept one, empty family ID keeps none
+func (ims *InMemoryStorage) RevokeUserRefreshTokens(userID int, keepFamilyID string) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	var families []string
+	ims.eachRefreshToken(func(t types.RefreshToken) bool {
+		if t.UserID == userID && t.FamilyID != keepFamilyID {
+			families = append(families, t.FamilyID)
+		}
+		return true
+	})
+	for _, familyID := range families {
+		ims.revokeFamily(familyID)
+	}
+	return nil
+}
+
+// GetUserByIdentity returns user linked to an external identity
+func (ims *InMemoryStorage) GetUserByIdentity(issuer, subject string) (types.User, error) {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	if userID, exist := ims.identityUser(identity{issuer: issuer, subject: subject}); exist {
+		if u, exist := ims.findUser(func(u types.User) bool { return u.ID == userID }); exist {
+			return u, nil
+		}
+	}
+	return types.User{}, fmt.Errorf("user with '%s' identity of '%s' issuer: %w", subject, issuer, ErrUserNotFound)
+}
+
+// LinkUserIdentity links an external identity to the user
+func (ims *InMemoryStorage) LinkUserIdentity(userID int, issuer, subject string) error {
+	ims.mutex.Lock()
+	defer ims.mutex.Unlock()
+	key := identity{issuer: issuer, subject: subject}
+	if _, exist := ims.identityUser(key); exist {
+		return fmt.Errorf("identity '%s' of '%s' issuer is already linked: %w", subject, issuer, ErrUserExists)
+	}
+	ims.putIdentity(key, userID)
+	return nil
+}
+
+// WithTx runs fn on a transaction which reads the storage and keeps its own
+// writes (copy-on-write). The storage is not locked while fn runs, writes
+// are applied if fn succeeds and tables read by the transaction weren't
+// changed meanwhile, otherwise ErrTxConflict is returned
+func (ims *InMemoryStorage) WithTx(fn func(tx DB) error) error {
+	tx := &InMemoryStorage{parent: ims, readVersions: make(map[table]int)}
+	tx.makeTables()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	return tx.commit()
 }
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/sergeikus/go-rest-template/pkg/types"
+)
+
+// table identifies a map of the in-memory storage, transactions
+// detect concurrent changes by versions of the tables
+type table int
+
+const (
+	dataTable table = iota
+	usersTable
+	resetTokensTable
+	totpsTable
+	recoveryCodesTable
+	apiKeysTable
+	refreshTokensTable
+	revokedFamiliesTable
+	identitiesTable
+	tablesCount
+)
+
+func (ims *InMemoryStorage) makeTables() {
+	ims.data = make(map[int]types.Data)
+	ims.users = make(map[string]types.User)
+	ims.resetTokens = make(map[string]types.PasswordResetToken)
+	ims.totps = make(map[int]types.TOTP)
+	ims.recoveryCodes = make(map[int]map[string]struct{})
+	ims.apiKeys = make(map[int]types.APIKey)
+	ims.refreshTokens = make(map[string]types.RefreshToken)
+	ims.revokedFamilies = make(map[string]struct{})
+	ims.identities = make(map[identity]int)
+}
+
+// Rows are read and written with the storage locked. Transaction reads its own
+// rows first and then rows of the parent storage, which is locked only while
+// it is read, writes are kept in the transaction until it is committed.
+
+// readParent runs fn with the parent storage locked, the first read of a table
+// records its version, so commit detects changes made after the read
+func (ims *InMemoryStorage) readParent(t table, fn func(p *InMemoryStorage)) {
+	ims.parent.mutex.Lock()
+	defer ims.parent.mutex.Unlock()
+	if _, exist := ims.readVersions[t]; !exist {
+		ims.readVersions[t] = ims.parent.versions[t]
+	}
+	fn(ims.parent)
+}
+
+// commit applies writes of the transaction to the parent storage,
+// ErrTxConflict is returned if a table read by the transaction was changed
+func (ims *InMemoryStorage) commit() error {
+	p := ims.parent
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for t, version := range ims.readVersions {
+		if p.versions[t] != version {
+			return ErrTxConflict
+		}
+	}
+
+	for _, d := range ims.data {
+		p.putData(d)
+	}
+	for _, u := range ims.users {
+		p.putUser(u)
+	}
+	for _, t := range ims.resetTokens {
+		p.putResetToken(t)
+	}
+	for _, totp := range ims.totps {
+		p.putTOTP(totp)
+	}
+	for userID, codes := range ims.recoveryCodes {
+		p.putRecoveryCodes(userID, codes)
+	}
+	for _, k := range ims.apiKeys {
+		p.putAPIKey(k)
+	}
+	for _, t := range ims.refreshTokens {
+		p.putRefreshToken(t)
+	}
+	for familyID := range ims.revokedFamilies {
+		p.revokeFamily(familyID)
+	}
+	for key, userID := range ims.identities {
+		p.putIdentity(key, userID)
+	}
+	for _, t := range []table{dataTable, usersTable, apiKeysTable} {
+		if ims.indexLoaded[t] {
+			*p.indexOf(t) = *ims.indexOf(t)
+			p.versions[t]++
+		}
+	}
+	return nil
+}
+
+// indexOf returns primary key index of the table (data, users or API keys),
+// transaction loads it from the parent storage on the first use
+func (ims *InMemoryStorage) indexOf(t table) *int {
+	var index *int
+	switch t {
+	case dataTable:
+		index = &ims.index
+	case usersTable:
+		index = &ims.userIndex
+	case apiKeysTable:
+		index = &ims.apiKeyIndex
+	default:
+		panic(fmt.Sprintf("table %d has no primary key index", t))
+	}
+	if ims.parent != nil && !ims.indexLoaded[t] {
+		ims.readParent(t, func(p *InMemoryStorage) { *index = *p.indexOf(t) })
+		ims.indexLoaded[t] = true
+	}
+	return index
+}
+
+// nextID returns primary key of a new row of the table
+func (ims *InMemoryStorage) nextID(t table) int {
+	index := ims.indexOf(t)
+	id := *index
+	*index++
+	ims.versions[t]++
+	return id
+}
+
+func (ims *InMemoryStorage) dataRow(id int) (types.Data, bool) {
+	d, exist := ims.data[id]
+	if !exist && ims.parent != nil {
+		ims.readParent(dataTable, func(p *InMemoryStorage) { d, exist = p.dataRow(id) })
+	}
+	return d, exist
+}
+
+func (ims *InMemoryStorage) putData(d types.Data) {
+	ims.data[d.ID] = d
+	ims.versions[dataTable]++
+}
+
+func (ims *InMemoryStorage) user(username string) (types.User, bool) {
+	u, exist := ims.users[username]
+	if !exist && ims.parent != nil {
+		ims.readParent(usersTable, func(p *InMemoryStorage) { u, exist = p.user(username) })
+	}
+	return u, exist
+}
+
+// findUser returns a user matching the condition
+func (ims *InMemoryStorage) findUser(match func(types.User) bool) (types.User, bool) {
+	for _, u := range ims.users {
+		if match(u) {
+			return u, true
+		}
+	}
+	var found types.User
+	var exist bool
+	if ims.parent != nil {
+		ims.readParent(usersTable, func(p *InMemoryStorage) {
+			found, exist = p.findUser(func(u types.User) bool {
+				_, replaced := ims.users[u.Username]
+				return !replaced && match(u)
+			})
+		})
+	}
+	return found, exist
+}
+
+func (ims *InMemoryStorage) putUser(u types.User) {
+	ims.users[u.Username] = u
+	ims.versions[usersTable]++
+}
+
+func (ims *InMemoryStorage) resetToken(tokenHash string) (types.PasswordResetToken, bool) {
+	t, exist := ims.resetTokens[tokenHash]
+	if !exist && ims.parent != nil {
+		ims.readParent(resetTokensTable, func(p *InMemoryStorage) { t, exist = p.resetToken(tokenHash) })
+	}
+	return t, exist
+}
+
+// eachResetToken calls fn for every password reset token until it returns false
+func (ims *InMemoryStorage) eachResetToken(fn func(types.PasswordResetToken) bool) {
+	for _, t := range ims.resetTokens {
+		if !fn(t) {
+			return
+		}
+	}
+	if ims.parent != nil {
+		ims.readParent(resetTokensTable, func(p *InMemoryStorage) {
+			p.eachResetToken(func(t types.PasswordResetToken) bool {
+				if _, replaced := ims.resetTokens[t.TokenHash]; replaced {
+					return true
+				}
+				return fn(t)
+			})
+		})
+	}
+}
+
+func (ims *InMemoryStorage) putResetToken(t types.PasswordResetToken) {
+	ims.resetTokens[t.TokenHash] = t
+	ims.versions[resetTokensTable]++
+}
+
+func (ims *InMemoryStorage) totp(userID int) (types.TOTP, bool) {
+	totp, exist := ims.totps[userID]
+	if !exist && ims.parent != nil {
+		ims.readParent(totpsTable, func(p *InMemoryStorage) { totp, exist = p.totp(userID) })
+	}
+	return totp, exist
+}
+
+func (ims *InMemoryStorage) putTOTP(totp types.TOTP) {
+	ims.totps[totp.UserID] = totp
+	ims.versions[totpsTable]++
+}
+
+// userRecoveryCodes returns recovery codes of the user, the set must not be modified
+func (ims *InMemoryStorage) userRecoveryCodes(userID int) map[string]struct{} {
+	codes, exist := ims.recoveryCodes[userID]
+	if !exist && ims.parent != nil {
+		ims.readParent(recoveryCodesTable, func(p *InMemoryStorage) { codes = p.userRecoveryCodes(userID) })
+	}
+	return codes
+}
+
+func (ims *InMemoryStorage) putRecoveryCodes(userID int, codes map[string]struct{}) {
+	ims.recoveryCodes[userID] = codes
+	ims.versions[recoveryCodesTable]++
+}
+
+func (ims *InMemoryStorage) apiKey(id int) (types.APIKey, bool) {
+	k, exist := ims.apiKeys[id]
+	if !exist && ims.parent != nil {
+		ims.readParent(apiKeysTable, func(p *InMemoryStorage) { k, exist = p.apiKey(id) })
+	}
+	return k, exist
+}
+
+// findAPIKey returns an API key matching the condition
+func (ims *InMemoryStorage) findAPIKey(match func(types.APIKey) bool) (types.APIKey, bool) {
+	for _, k := range ims.apiKeys {
+		if match(k) {
+			return k, true
+		}
+	}
+	var found types.APIKey
+	var exist bool
+	if ims.parent != nil {
+		ims.readParent(apiKeysTable, func(p *InMemoryStorage) {
+			found, exist = p.findAPIKey(func(k types.APIKey) bool {
+				_, replaced := ims.apiKeys[k.ID]
+				return !replaced && match(k)
+			})
+		})
+	}
+	return found, exist
+}
+
+func (ims *InMemoryStorage) putAPIKey(k types.APIKey) {
+	ims.apiKeys[k.ID] = k
+	ims.versions[apiKeysTable]++
+}
+
+func (ims *InMemoryStorage) refreshToken(tokenHash string) (types.RefreshToken, bool) {
+	t, exist := ims.refreshTokens[tokenHash]
+	if !exist && ims.parent != nil {
+		ims.readParent(refreshTokensTable, func(p *InMemoryStorage) { t, exist = p.refreshToken(tokenHash) })
+	}
+	return t, exist
+}
+
+// eachRefreshToken calls fn for every refresh token until it returns false
+func (ims *InMemoryStorage) eachRefreshToken(fn func(types.RefreshToken) bool) {
+	for _, t := range ims.refreshTokens {
+		if !fn(t) {
+			return
+		}
+	}
+	if ims.parent != nil {
+		ims.readParent(refreshTokensTable, func(p *InMemoryStorage) {
+			p.eachRefreshToken(func(t types.RefreshToken) bool {
+				if _, replaced := ims.refreshTokens[t.TokenHash]; replaced {
+					return true
+				}
+				return fn(t)
+			})
+		})
+	}
+}
+
+func (ims *InMemoryStorage) putRefreshToken(t types.RefreshToken) {
+	ims.refreshTokens[t.TokenHash] = t
+	ims.versions[refreshTokensTable]++
+}
+
+func (ims *InMemoryStorage) familyRevoked(familyID string) bool {
+	_, revoked := ims.revokedFamilies[familyID]
+	if !revoked && ims.parent != nil {
+		ims.readParent(revokedFamiliesTable, func(p *InMemoryStorage) { revoked = p.familyRevoked(familyID) })
+	}
+	return revoked
+}
+
+func (ims *InMemoryStorage) revokeFamily(familyID string) {
+	ims.revokedFamilies[familyID] = struct{}{}
+	ims.versions[revokedFamiliesTable]++
+}
+
+func (ims *InMemoryStorage) identityUser(key identity) (int, bool) {
+	userID, exist := ims.identities[key]
+	if !exist && ims.parent != nil {
+		ims.readParent(identitiesTable, func(p *InMemoryStorage) { userID, exist = p.identityUser(key) })
+	}
+	return userID, exist
+}
+
+func (ims *InMemoryStorage) putIdentity(key identity, userID int) {
+	ims.identities[key] = userID
+	ims.versions[identitiesTable]++
+}
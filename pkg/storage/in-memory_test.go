@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/sergeikus/go-rest-template/pkg/types"
 	"github.com/stretchr/testify/require"
@@ -79,3 +82,249 @@ func Test_Store(t *testing.T) {
 		})
 	}
 }
+
+func Test_ConsumePasswordResetToken(t *testing.T) {
+	ims := &InMemoryStorage{}
+	require.NoError(t, ims.Connect())
+
+	require.NoError(t, ims.StorePasswordResetToken(types.PasswordResetToken{TokenHash: "valid", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, ims.StorePasswordResetToken(types.PasswordResetToken{TokenHash: "other", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, ims.StorePasswordResetToken(types.PasswordResetToken{TokenHash: "expired", UserID: 2, ExpiresAt: time.Now().Add(-time.Second)}))
+
+	tt := []struct {
+		name      string
+		tokenHash string
+		fail      bool
+	}{
+		{name: "Unknown token", tokenHash: "unknown", fail: true},
+		{name: "Expired token", tokenHash: "expired", fail: true},
+		{name: "Valid token", tokenHash: "valid", fail: false},
+		{name: "Already used token", tokenHash: "valid", fail: true},
+		{name: "Token of the same user is invalidated", tokenHash: "other", fail: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			userID, err := ims.ConsumePasswordResetToken(tc.tokenHash)
+			if tc.fail {
+				require.True(t, errors.Is(err, ErrInvalidToken), "expected to see invalid token error, but got: %v", err)
+			} else {
+				require.NoError(t, err, "expected to get no error, but got: %v", err)
+				require.Equal(t, 1, userID, "expected to see a different user ID")
+			}
+		})
+	}
+}
+
+func Test_UseRefreshToken(t *testing.T) {
+	ims := &InMemoryStorage{}
+	require.NoError(t, ims.Connect())
+
+	require.NoError(t, ims.StoreRefreshToken(types.RefreshToken{TokenHash: "first", FamilyID: "family", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, ims.StoreRefreshToken(types.RefreshToken{TokenHash: "second", FamilyID: "family", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, ims.StoreRefreshToken(types.RefreshToken{TokenHash: "expired", FamilyID: "other", UserID: 1, ExpiresAt: time.Now().Add(-time.Second)}))
+
+	tt := []struct {
+		name        string
+		tokenHash   string
+		revoke      string
+		expectedErr error
+	}{
+		{name: "Unknown token", tokenHash: "unknown", expectedErr: ErrInvalidToken},
+		{name: "Expired token", tokenHash: "expired", expectedErr: ErrInvalidToken},
+		{name: "Valid token", tokenHash: "first"},
+		{name: "Reused token", tokenHash: "first", expectedErr: ErrRefreshTokenReused},
+		{name: "Token of revoked family", tokenHash: "second", revoke: "family", expectedErr: ErrInvalidToken},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.revoke) != 0 {
+				require.NoError(t, ims.RevokeRefreshTokenFamily(tc.revoke))
+			}
+			token, err := ims.UseRefreshToken(tc.tokenHash)
+			if tc.expectedErr != nil {
+				require.True(t, errors.Is(err, tc.expectedErr), "expected to see '%v' error, but got: %v", tc.expectedErr, err)
+				return
+			}
+			require.NoError(t, err, "expected to get no error, but got: %v", err)
+			require.Equal(t, "family", token.FamilyID, "expected to see a different token family")
+			require.Equal(t, 1, token.UserID, "expected to see a different user ID")
+		})
+	}
+}
+
+func Test_LinkUserIdentity(t *testing.T) {
+	ims := &InMemoryStorage{}
+	require.NoError(t, ims.Connect())
+	id, err := ims.RegisterUser(types.User{Username: "test", Email: "test@example.com"})
+	require.NoError(t, err)
+
+	_, err = ims.GetUserByIdentity("issuer", "subject")
+	require.True(t, errors.Is(err, ErrUserNotFound), "expected to see user not found error, but got: %v", err)
+
+	require.NoError(t, ims.LinkUserIdentity(id, "issuer", "subject"))
+	u, err := ims.GetUserByIdentity("issuer", "subject")
+	require.NoError(t, err)
+	require.Equal(t, id, u.ID)
+
+	err = ims.LinkUserIdentity(id, "issuer", "subject")
+	require.True(t, errors.Is(err, ErrUserExists), "expected to see user exists error, but got: %v", err)
+}
+
+func Test_WithTx(t *testing.T) {
+	errTx := errors.New("transaction failed")
+	tt := []struct {
+		name          string
+		fn            func(tx DB) error
+		expectedErr   error
+		expectedUsers int
+		expectedCodes int
+	}{
+		{
+			name: "Committed",
+			fn: func(tx DB) error {
+				id, err := tx.RegisterUser(types.User{Username: "new", Email: "new@example.com"})
+				if err != nil {
+					return err
+				}
+				if _, err := tx.Store("default data"); err != nil {
+					return err
+				}
+				return tx.StoreRecoveryCodes(id, []string{"code"})
+			},
+			expectedUsers: 2,
+			expectedCodes: 1,
+		},
+		{
+			name: "Rolled back",
+			fn: func(tx DB) error {
+				if _, err := tx.RegisterUser(types.User{Username: "new", Email: "new@example.com"}); err != nil {
+					return err
+				}
+				if err := tx.ConsumeRecoveryCode(1, "existing"); err != nil {
+					return err
+				}
+				return errTx
+			},
+			expectedErr:   errTx,
+			expectedUsers: 1,
+		},
+		{
+			name: "Nested transaction rolled back",
+			fn: func(tx DB) error {
+				if _, err := tx.RegisterUser(types.User{Username: "new", Email: "new@example.com"}); err != nil {
+					return err
+				}
+				nestedErr := tx.WithTx(func(nested DB) error {
+					if _, err := nested.RegisterUser(types.User{Username: "nested", Email: "nested@example.com"}); err != nil {
+						return err
+					}
+					return errTx
+				})
+				if nestedErr != errTx {
+					return fmt.Errorf("expected nested transaction error, but got: %v", nestedErr)
+				}
+				return nil
+			},
+			expectedUsers: 2,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ims := &InMemoryStorage{}
+			require.NoError(t, ims.Connect(), "failed to connect")
+			_, err := ims.RegisterUser(types.User{Username: "existing", Email: "existing@example.com"})
+			require.NoError(t, err, "failed to register user")
+			require.NoError(t, ims.StoreRecoveryCodes(1, []string{"existing"}), "failed to store recovery codes")
+
+			require.Equal(t, tc.expectedErr, ims.WithTx(tc.fn))
+			require.Len(t, ims.users, tc.expectedUsers, "unexpected users count")
+			_, err = ims.GetUserByUsername("nested")
+			require.True(t, errors.Is(err, ErrUserNotFound), "expected nested transaction to be rolled back")
+			if tc.expectedErr != nil {
+				require.NoError(t, ims.ConsumeRecoveryCode(1, "existing"), "expected recovery code to be kept")
+			}
+			require.Len(t, ims.recoveryCodes[2], tc.expectedCodes, "unexpected recovery codes count of the new user")
+		})
+	}
+}
+
+func Test_WithTx_Concurrent(t *testing.T) {
+	ims := &InMemoryStorage{}
+	require.NoError(t, ims.Connect(), "failed to connect")
+	_, err := ims.RegisterUser(types.User{Username: "existing", Email: "existing@example.com"})
+	require.NoError(t, err, "failed to register user")
+
+	// Storage must not be locked while fn runs
+	done := make(chan error, 1)
+	go func() {
+		done <- ims.WithTx(func(tx DB) error {
+			if _, err := tx.RegisterUser(types.User{Username: "new", Email: "new@example.com"}); err != nil {
+				return err
+			}
+			if _, err := ims.GetUserByUsername("new"); !errors.Is(err, ErrUserNotFound) {
+				return fmt.Errorf("expected uncommitted user to be hidden, but got: %v", err)
+			}
+			// Write to a table the transaction didn't read doesn't conflict
+			return ims.SaveTOTP(types.TOTP{UserID: 1, Secret: "secret"})
+		})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("transaction is blocked by the storage it was started on")
+	}
+	u, err := ims.GetUserByUsername("new")
+	require.NoError(t, err, "expected transaction to be committed")
+	require.Equal(t, 2, u.ID)
+
+	// Transaction decides on users it read, so it fails if they are changed before commit
+	err = ims.WithTx(func(tx DB) error {
+		if _, err := tx.GetUserByEmail("concurrent@example.com"); !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		if _, err := ims.RegisterUser(types.User{Username: "concurrent", Email: "concurrent@example.com"}); err != nil {
+			return err
+		}
+		_, err := tx.RegisterUser(types.User{Username: "conflict", Email: "conflict@example.com"})
+		return err
+	})
+	require.Equal(t, ErrTxConflict, err, "expected concurrent change of read users to be detected")
+	_, err = ims.GetUserByUsername("conflict")
+	require.True(t, errors.Is(err, ErrUserNotFound), "expected conflicting transaction to be rolled back")
+	require.Len(t, ims.users, 3, "unexpected users count")
+}
+
+func Test_UseTOTPStep(t *testing.T) {
+	ims := &InMemoryStorage{}
+	require.NoError(t, ims.Connect(), "failed to connect")
+	require.Equal(t, ErrInvalidToken, ims.UseTOTPStep(1, 10), "expected step of unknown TOTP to be rejected")
+	require.NoError(t, ims.SaveTOTP(types.TOTP{UserID: 1, Secret: "secret", Enabled: true, LastUsedStep: 5}))
+
+	// Concurrent requests with the same code must not both succeed
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- ims.UseTOTPStep(1, 10)
+		}()
+	}
+	wg.Wait()
+	close(results)
+	used := 0
+	for err := range results {
+		if err == nil {
+			used++
+		} else {
+			require.Equal(t, ErrInvalidToken, err)
+		}
+	}
+	require.Equal(t, 1, used, "expected step to be used once")
+	require.Equal(t, ErrInvalidToken, ims.UseTOTPStep(1, 9), "expected earlier step to be rejected")
+	require.NoError(t, ims.UseTOTPStep(1, 11), "expected later step to be accepted")
+}
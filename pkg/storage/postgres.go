@@ -2,42 +2,112 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/sergeikus/go-rest-template/pkg/types"
 )
 
+// pgUniqueViolation is a Postgres error code of unique constraint violation
+const pgUniqueViolation = "23505"
+
+// querier runs queries, it is either a connection pool or a transaction
+type querier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
 // PostgresStorage represents a Postgres database
 type PostgresStorage struct {
-	DSN     string
+	config  PostgresConfig
 	pgxPool *pgxpool.Pool
+	// Queries are run by the pool or, within WithTx, by the transaction
+	db querier
+	// Read replicas, nil if there are none
+	replicas *replicaSet
 }
 
 // DefinePostgresStorage PostgresStorage fields
-func DefinePostgresStorage(user, password, dbname, host string, port int) *PostgresStorage {
-	dsn := fmt.Sprintf("user=%s password=%s host=%s port=%d dbname=%s", user, password, host, port, dbname)
-	ps := &PostgresStorage{
-		DSN: dsn,
-	}
-	return ps
+func DefinePostgresStorage(config PostgresConfig) *PostgresStorage {
+	return &PostgresStorage{config: config}
 }
 
-// Connect performs connection to the database
+// Connect performs connection to the database, failed connection is retried
+// with exponential backoff if retries are configured
 func (ps *PostgresStorage) Connect() error {
-	//   # Example DSN
-	// user=jack password=secret host=pg.example.com port=5432 dbname=mydb sslmode=verify-ca pool_max_conns=10
-	dbPool, err := pgxpool.Connect(context.Background(), ps.DSN)
+	delay := ps.config.ConnectRetryInterval
+	if delay <= 0 {
+		delay = defaultConnectRetryInterval
+	}
+	for attempt := 1; ; attempt++ {
+		dbPool, err := ps.connect()
+		if err == nil {
+			ps.pgxPool, ps.db = dbPool, dbPool
+			if err := ps.connectReplicas(); err != nil {
+				dbPool.Close()
+				return fmt.Errorf("failed to initialize database replicas: %v", err)
+			}
+			return nil
+		}
+		if attempt > ps.config.ConnectRetries {
+			return fmt.Errorf("failed to perform database connection to '%s' (%d attempt(s)): %v", ps.config.Address(), attempt, err)
+		}
+		log.Printf("[Postgres] Connection attempt %d to '%s' failed, retrying in %s: %v", attempt, ps.config.Address(), delay, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxConnectRetryInterval {
+			delay = maxConnectRetryInterval
+		}
+	}
+}
+
+// connect creates a connection pool, password is redacted in returned error
+func (ps *PostgresStorage) connect() (*pgxpool.Pool, error) {
+	config, err := ps.config.poolConfig()
 	if err != nil {
-		return fmt.Errorf("failed to perform database connection to '%s': %v", ps.DSN, err)
+		return nil, ps.config.redact(err)
 	}
-	ps.pgxPool = dbPool
-	return nil
+	dbPool, err := pgxpool.ConnectConfig(context.Background(), config)
+	if err != nil {
+		return nil, ps.config.redact(err)
+	}
+	return dbPool, nil
 }
 
-// Close closes connection to the database
+// Close closes connection to the database and its replicas
 func (ps *PostgresStorage) Close() {
-	ps.pgxPool.Close()
+	if ps.replicas != nil {
+		ps.replicas.close()
+	}
+	// Transactions don't own the pool
+	if ps.pgxPool != nil {
+		ps.pgxPool.Close()
+	}
+}
+
+// WithTx runs fn in a transaction, nested calls use savepoints
+func (ps *PostgresStorage) WithTx(fn func(tx DB) error) error {
+	ctx := context.Background()
+	tx, err := ps.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	// Rollback is a no-op after commit, it also rolls back if fn panics
+	defer tx.Rollback(ctx)
+
+	if err := fn(&PostgresStorage{config: ps.config, db: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
 }
 
 // Store performs storage of data in database, returns stored data auto generated primary key
@@ -47,57 +117,72 @@ func (ps *PostgresStorage) Store(data string) (id int, err error) {
 	VALUES ($1)
 	RETURNING id
 	`
-	if err := ps.pgxPool.QueryRow(context.Background(), sql, data).Scan(&id); err != nil {
+	if err := ps.db.QueryRow(context.Background(), sql, data).Scan(&id); err != nil {
 		return id, fmt.Errorf("failed to store data: %v", err)
 	}
 	return id, nil
 }
 
-// GetAll returns all data (rows) from 'data_table'
+// GetAll returns all data (rows) from 'data_table', it may be served by a replica
 func (ps *PostgresStorage) GetAll() ([]types.Data, error) {
 	sql := `
 	SELECT * FROM data_table
 	`
-	rows, err := ps.pgxPool.Query(context.Background(), sql)
+	var result []types.Data
+	err := ps.read(func(db querier) error {
+		rows, err := db.Query(context.Background(), sql)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		result = nil
+		for rows.Next() {
+			var d types.Data
+			rows.Scan(&d.ID, &d.String)
+			result = append(result, d)
+		}
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all data from table: %v", err)
 	}
-	defer rows.Close()
-
-	var result []types.Data
-	for rows.Next() {
-		var d types.Data
-		rows.Scan(&d.ID, &d.String)
-		result = append(result, d)
-	}
-	if rows.Err() != nil {
-		return nil, fmt.Errorf("encountered an error while reading rows: %v", err)
-	}
 
 	return result, nil
 }
 
-// GetKey returns data for a particular key
+// GetKey returns data for a particular key, it may be served by a replica
 func (ps *PostgresStorage) GetKey(key int) (types.Data, error) {
 	sql := `
 	SELECT * FROM data_table 
 	WHERE id=$1
 	`
 	var d types.Data
-	if err := ps.pgxPool.QueryRow(context.Background(), sql, key).Scan(&d.ID, &d.String); err != nil {
+	err := ps.read(func(db querier) error {
+		return db.QueryRow(context.Background(), sql, key).Scan(&d.ID, &d.String)
+	})
+	if err != nil {
 		return d, fmt.Errorf("failed to query data for '%d' key: %v", key, err)
 	}
 	return d, nil
 }
 
+// userColumns lists users table columns in the order of userFields
+const userColumns = "id, username, fullname, password_salt, password_hash, email, is_disabled, is_verified, is_admin"
+
+// userFields returns scan destinations for userColumns
+func userFields(u *types.User) []interface{} {
+	return []interface{}{&u.ID, &u.Username, &u.Fullname, &u.PasswordSalt, &u.PasswordHash, &u.Email, &u.IsDisabled, &u.IsVerified, &u.IsAdmin}
+}
+
 // VerifyUserCredentials performs user log in verification
 func (ps *PostgresStorage) VerifyUserCredentials(username, passwordHash string) (types.User, error) {
 	sql := `
-	SELECT * FROM users 
+	SELECT ` + userColumns + ` FROM users 
 	WHERE username=$1 AND password_hash=$2
 	`
 	var u types.User
-	if err := ps.pgxPool.QueryRow(context.Background(), sql, username, passwordHash).Scan(&u.ID, &u.Username, &u.Fullname, &u.PasswordSalt, &u.PasswordHash, &u.Email, &u.IsDisabled); err != nil {
+	if err := ps.db.QueryRow(context.Background(), sql, username, passwordHash).Scan(userFields(&u)...); err != nil {
 		return u, fmt.Errorf("failed to get user from database: %v", err)
 	}
 
@@ -110,7 +195,7 @@ func (ps *PostgresStorage) GetUserSalt(username string) (salt string, err error)
 	SELECT password_salt FROM users
 	WHERE username=$1
 	`
-	if err := ps.pgxPool.QueryRow(context.Background(), sql, username).Scan(&salt); err != nil {
+	if err := ps.db.QueryRow(context.Background(), sql, username).Scan(&salt); err != nil {
 		return salt, err
 	}
 	return salt, nil
@@ -119,15 +204,443 @@ func (ps *PostgresStorage) GetUserSalt(username string) (salt string, err error)
 // RegisterUser registers user in postgres
 func (ps *PostgresStorage) RegisterUser(user types.User) (id int, err error) {
 	sql := `
-	INSERT INTO users (username, fullname, password_salt, password_hash, email, is_disabled)
-	VALUES ($1, $2, $3, $4, $5, $6)
+	INSERT INTO users (username, fullname, password_salt, password_hash, email, is_disabled, is_verified, is_admin)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	RETURNING id
 	`
-	if err := ps.pgxPool.QueryRow(
+	if err := ps.db.QueryRow(
 		context.Background(), sql,
 		user.Username, user.Fullname, user.PasswordSalt,
-		user.PasswordHash, user.Email, user.IsDisabled).Scan(&id); err != nil {
+		user.PasswordHash, user.Email, user.IsDisabled, user.IsVerified, user.IsAdmin).Scan(&id); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			return id, fmt.Errorf("%s: %w", pgErr.Detail, ErrUserExists)
+		}
 		return id, err
 	}
 	return id, nil
 }
+
+// GetUserByID returns user with a given ID
+func (ps *PostgresStorage) GetUserByID(userID int) (types.User, error) {
+	sql := `
+	SELECT ` + userColumns + ` FROM users
+	WHERE id=$1
+	`
+	var u types.User
+	if err := ps.db.QueryRow(context.Background(), sql, userID).Scan(userFields(&u)...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return u, fmt.Errorf("user with '%d' ID: %w", userID, ErrUserNotFound)
+		}
+		return u, fmt.Errorf("failed to get user from database: %v", err)
+	}
+	return u, nil
+}
+
+// GetUserByUsername returns user with a given username
+func (ps *PostgresStorage) GetUserByUsername(username string) (types.User, error) {
+	sql := `
+	SELECT ` + userColumns + ` FROM users
+	WHERE username=$1
+	`
+	var u types.User
+	if err := ps.db.QueryRow(context.Background(), sql, username).Scan(userFields(&u)...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return u, fmt.Errorf("user with '%s' username: %w", username, ErrUserNotFound)
+		}
+		return u, fmt.Errorf("failed to get user from database: %v", err)
+	}
+	return u, nil
+}
+
+// GetUserByEmail returns user with a given email
+func (ps *PostgresStorage) GetUserByEmail(email string) (types.User, error) {
+	sql := `
+	SELECT ` + userColumns + ` FROM users
+	WHERE lower(email)=lower($1)
+	`
+	var u types.User
+	if err := ps.db.QueryRow(context.Background(), sql, email).Scan(userFields(&u)...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return u, fmt.Errorf("user with '%s' email: %w", email, ErrUserNotFound)
+		}
+		return u, fmt.Errorf("failed to get user from database: %v", err)
+	}
+	return u, nil
+}
+
+// UpdateUserPassword sets new user password salt and hash
+func (ps *PostgresStorage) UpdateUserPassword(userID int, passwordSalt, passwordHash string) error {
+	sql := `
+	UPDATE users SET password_salt=$2, password_hash=$3
+	WHERE id=$1
+	`
+	tag, err := ps.db.Exec(context.Background(), sql, userID, passwordSalt, passwordHash)
+	if err != nil {
+		return fmt.Errorf("failed to update user password: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user with '%d' ID: %w", userID, ErrUserNotFound)
+	}
+	return nil
+}
+
+// VerifyUserEmail marks user email as verified, email must match
+// the current user email
+func (ps *PostgresStorage) VerifyUserEmail(userID int, email string) error {
+	sql := `
+	UPDATE users SET is_verified=true
+	WHERE id=$1 AND lower(email)=lower($2)
+	`
+	tag, err := ps.db.Exec(context.Background(), sql, userID, email)
+	if err != nil {
+		return fmt.Errorf("failed to verify user email: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user with '%d' ID and '%s' email: %w", userID, email, ErrUserNotFound)
+	}
+	return nil
+}
+
+// StorePasswordResetToken stores password reset token
+func (ps *PostgresStorage) StorePasswordResetToken(token types.PasswordResetToken) error {
+	sql := `
+	INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+	VALUES ($1, $2, $3)
+	`
+	if _, err := ps.db.Exec(context.Background(), sql, token.TokenHash, token.UserID, token.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to store password reset token: %v", err)
+	}
+	return nil
+}
+
+// ConsumePasswordResetToken marks password reset token as used and returns
+// its user ID, every other token of the user is invalidated as well
+func (ps *PostgresStorage) ConsumePasswordResetToken(tokenHash string) (userID int, err error) {
+	sql := `
+	UPDATE password_reset_tokens SET used_at=now()
+	WHERE user_id=(
+		SELECT user_id FROM password_reset_tokens
+		WHERE token_hash=$1 AND used_at IS NULL AND expires_at > now()
+	) AND used_at IS NULL
+	RETURNING user_id
+	`
+	rows, err := ps.db.Query(context.Background(), sql, tokenHash)
+	if err != nil {
+		return userID, fmt.Errorf("failed to consume password reset token: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&userID); err != nil {
+			return userID, fmt.Errorf("failed to read password reset token: %v", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return userID, fmt.Errorf("failed to consume password reset token: %v", err)
+	}
+	if userID == 0 {
+		return userID, ErrInvalidToken
+	}
+	return userID, nil
+}
+
+// GetTOTP returns TOTP second factor of the user
+func (ps *PostgresStorage) GetTOTP(userID int) (types.TOTP, error) {
+	sql := `
+	SELECT user_id, secret, enabled, last_used_step FROM user_totp
+	WHERE user_id=$1
+	`
+	var totp types.TOTP
+	if err := ps.db.QueryRow(context.Background(), sql, userID).Scan(&totp.UserID, &totp.Secret, &totp.Enabled, &totp.LastUsedStep); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return totp, fmt.Errorf("user with '%d' ID: %w", userID, ErrTOTPNotFound)
+		}
+		return totp, fmt.Errorf("failed to get TOTP: %v", err)
+	}
+	return totp, nil
+}
+
+// SaveTOTP creates or replaces TOTP second factor of the user
+func (ps *PostgresStorage) SaveTOTP(totp types.TOTP) error {
+	sql := `
+	INSERT INTO user_totp (user_id, secret, enabled, last_used_step)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (user_id) DO UPDATE
+	SET secret=EXCLUDED.secret, enabled=EXCLUDED.enabled, last_used_step=EXCLUDED.last_used_step
+	`
+	if _, err := ps.db.Exec(context.Background(), sql, totp.UserID, totp.Secret, totp.Enabled, totp.LastUsedStep); err != nil {
+		return fmt.Errorf("failed to save TOTP: %v", err)
+	}
+	return nil
+}
+
+// UseTOTPStep records a used TOTP time step if it is later than the last used one
+func (ps *PostgresStorage) UseTOTPStep(userID int, step int64) error {
+	sql := `
+	UPDATE user_totp SET last_used_step=$2
+	WHERE user_id=$1 AND last_used_step < $2
+	`
+	tag, err := ps.db.Exec(context.Background(), sql, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to use TOTP step: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// StoreRecoveryCodes replaces recovery codes of the user
+func (ps *PostgresStorage) StoreRecoveryCodes(userID int, codeHashes []string) error {
+	ctx := context.Background()
+	tx, err := ps.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.Exec(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, h); err != nil {
+			return fmt.Errorf("failed to store recovery code: %v", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit recovery codes: %v", err)
+	}
+	return nil
+}
+
+// ConsumeRecoveryCode removes recovery code of the user,
+// error is returned if the code does not exist
+func (ps *PostgresStorage) ConsumeRecoveryCode(userID int, codeHash string) error {
+	sql := `
+	DELETE FROM user_recovery_codes
+	WHERE user_id=$1 AND code_hash=$2
+	`
+	tag, err := ps.db.Exec(context.Background(), sql, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("failed to consume recovery code: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// CreateAPIKey stores API key
+func (ps *PostgresStorage) CreateAPIKey(key types.APIKey) (id int, err error) {
+	sql := `
+	INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id
+	`
+	if err := ps.db.QueryRow(
+		context.Background(), sql,
+		key.UserID, key.Name, key.Prefix, key.KeyHash, key.Scopes, key.CreatedAt, key.ExpiresAt).Scan(&id); err != nil {
+		return id, fmt.Errorf("failed to store API key: %v", err)
+	}
+	return id, nil
+}
+
+// ListAPIKeys returns API keys of the user which are not revoked
+func (ps *PostgresStorage) ListAPIKeys(userID int) ([]types.APIKey, error) {
+	sql := `
+	SELECT id, user_id, name, prefix, key_hash, scopes, created_at, expires_at, last_used_at, revoked_at IS NOT NULL
+	FROM api_keys
+	WHERE user_id=$1 AND revoked_at IS NULL
+	ORDER BY id
+	`
+	rows, err := ps.db.Query(context.Background(), sql, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys := []types.APIKey{}
+	for rows.Next() {
+		var k types.APIKey
+		if err := rows.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.Revoked); err != nil {
+			return nil, fmt.Errorf("failed to read API key: %v", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("encountered an error while reading rows: %v", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes API key of the user
+func (ps *PostgresStorage) RevokeAPIKey(userID, keyID int) error {
+	sql := `
+	UPDATE api_keys SET revoked_at=now()
+	WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL
+	`
+	tag, err := ps.db.Exec(context.Background(), sql, keyID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("API key with '%d' ID: %w", keyID, ErrAPIKeyNotFound)
+	}
+	return nil
+}
+
+// GetAPIKeyByPrefix returns API key with its owner username
+func (ps *PostgresStorage) GetAPIKeyByPrefix(prefix string) (types.APIKey, error) {
+	sql := `
+	SELECT k.id, k.user_id, u.username, k.name, k.prefix, k.key_hash, k.scopes, k.created_at, k.expires_at, k.last_used_at, k.revoked_at IS NOT NULL
+	FROM api_keys k JOIN users u ON u.id=k.user_id
+	WHERE k.prefix=$1
+	`
+	var k types.APIKey
+	if err := ps.db.QueryRow(context.Background(), sql, prefix).Scan(
+		&k.ID, &k.UserID, &k.Username, &k.Name, &k.Prefix, &k.KeyHash, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.Revoked); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return k, fmt.Errorf("API key with '%s' prefix: %w", prefix, ErrAPIKeyNotFound)
+		}
+		return k, fmt.Errorf("failed to get API key: %v", err)
+	}
+	return k, nil
+}
+
+// UpdateAPIKeyLastUsed sets API key last usage time
+func (ps *PostgresStorage) UpdateAPIKeyLastUsed(keyID int, t time.Time) error {
+	sql := `
+	UPDATE api_keys SET last_used_at=$2
+	WHERE id=$1
+	`
+	if _, err := ps.db.Exec(context.Background(), sql, keyID, t); err != nil {
+		return fmt.Errorf("failed to update API key last usage: %v", err)
+	}
+	return nil
+}
+
+// StoreRefreshToken stores refresh token, token family is created with the first token
+func (ps *PostgresStorage) StoreRefreshToken(token types.RefreshToken) error {
+	ctx := context.Background()
+	tx, err := ps.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	familySQL := `
+	INSERT INTO refresh_token_families (id, user_id, created_at)
+	VALUES ($1, $2, now())
+	ON CONFLICT (id) DO NOTHING
+	`
+	if _, err := tx.Exec(ctx, familySQL, token.FamilyID, token.UserID); err != nil {
+		return fmt.Errorf("failed to store refresh token family: %v", err)
+	}
+	tokenSQL := `
+	INSERT INTO refresh_tokens (token_hash, family_id, expires_at)
+	VALUES ($1, $2, $3)
+	`
+	if _, err := tx.Exec(ctx, tokenSQL, token.TokenHash, token.FamilyID, token.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to store refresh token: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit refresh token: %v", err)
+	}
+	return nil
+}
+
+// UseRefreshToken marks refresh token as used and returns it,
+// if the token was already used it is returned with ErrRefreshTokenReused
+func (ps *PostgresStorage) UseRefreshToken(tokenHash string) (types.RefreshToken, error) {
+	ctx := context.Background()
+	token := types.RefreshToken{TokenHash: tokenHash}
+	sql := `
+	UPDATE refresh_tokens t SET used_at=now()
+	FROM refresh_token_families f
+	WHERE t.token_hash=$1 AND f.id=t.family_id
+		AND t.used_at IS NULL AND t.expires_at > now() AND f.revoked_at IS NULL
+	RETURNING t.family_id, f.user_id, t.expires_at
+	`
+	err := ps.db.QueryRow(ctx, sql, tokenHash).Scan(&token.FamilyID, &token.UserID, &token.ExpiresAt)
+	if err == nil {
+		token.Used = true
+		return token, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return types.RefreshToken{}, fmt.Errorf("failed to use refresh token: %v", err)
+	}
+
+	// Token is not usable, find out whether it is a replay of a used token
+	sql = `
+	SELECT t.family_id, f.user_id, t.expires_at, t.used_at IS NOT NULL
+	FROM refresh_tokens t JOIN refresh_token_families f ON f.id=t.family_id
+	WHERE t.token_hash=$1
+	`
+	if err := ps.db.QueryRow(ctx, sql, tokenHash).Scan(&token.FamilyID, &token.UserID, &token.ExpiresAt, &token.Used); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return types.RefreshToken{}, ErrInvalidToken
+		}
+		return types.RefreshToken{}, fmt.Errorf("failed to get refresh token: %v", err)
+	}
+	if token.Used {
+		return token, ErrRefreshTokenReused
+	}
+	return types.RefreshToken{}, ErrInvalidToken
+}
+
+// RevokeRefreshTokenFamily invalidates every refresh token of the family
+func (ps *PostgresStorage) RevokeRefreshTokenFamily(familyID string) error {
+	sql := `
+	UPDATE refresh_token_families SET revoked_at=now()
+	WHERE id=$1 AND revoked_at IS NULL
+	`
+	if _, err := ps.db.Exec(context.Background(), sql, familyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %v", err)
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens invalidates every refresh token family of
+// the user except the kept one, empty family ID keeps none
+func (ps *PostgresStorage) RevokeUserRefreshTokens(userID int, keepFamilyID string) error {
+	sql := `
+	UPDATE refresh_token_families SET revoked_at=now()
+	WHERE user_id=$1 AND id<>$2 AND revoked_at IS NULL
+	`
+	if _, err := ps.db.Exec(context.Background(), sql, userID, keepFamilyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
+
+// GetUserByIdentity returns user linked to an external identity
+func (ps *PostgresStorage) GetUserByIdentity(issuer, subject string) (types.User, error) {
+	sql := `
+	SELECT ` + userColumns + ` FROM users
+	WHERE id=(SELECT user_id FROM user_identities WHERE issuer=$1 AND subject=$2)
+	`
+	var u types.User
+	if err := ps.db.QueryRow(context.Background(), sql, issuer, subject).Scan(userFields(&u)...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return u, fmt.Errorf("user with '%s' identity of '%s' issuer: %w", subject, issuer, ErrUserNotFound)
+		}
+		return u, fmt.Errorf("failed to get user from database: %v", err)
+	}
+	return u, nil
+}
+
+// LinkUserIdentity links an external identity to the user
+func (ps *PostgresStorage) LinkUserIdentity(userID int, issuer, subject string) error {
+	sql := `
+	INSERT INTO user_identities (issuer, subject, user_id, created_at)
+	VALUES ($1, $2, $3, now())
+	`
+	if _, err := ps.db.Exec(context.Background(), sql, issuer, subject, userID); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			return fmt.Errorf("identity '%s' of '%s' issuer is already linked: %w", subject, issuer, ErrUserExists)
+		}
+		return fmt.Errorf("failed to link user identity: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	// defaultConnectRetryInterval is a delay before the first connection retry if not configured
+	defaultConnectRetryInterval = time.Second
+	// maxConnectRetryInterval limits the delay between connection retries
+	maxConnectRetryInterval = 30 * time.Second
+	// redactedPassword replaces password in errors
+	redactedPassword = "xxxxx"
+)
+
+// PostgresSSLModes lists supported 'sslmode' values
+var PostgresSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// PostgresConfig represents Postgres connection and pool parameters,
+// zero values mean pgx defaults
+type PostgresConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Name     string
+	// SSL mode, one of PostgresSSLModes
+	SSLMode string
+	// Path to CA certificates the server certificate is verified with
+	SSLRootCert     string
+	ApplicationName string
+	ConnectTimeout  time.Duration
+	// Statement timeout of every connection, 0 means no timeout
+	StatementTimeout time.Duration
+	MaxConns         int32
+	MinConns         int32
+	MaxConnLifetime  time.Duration
+	MaxConnIdleTime  time.Duration
+	// Number of connection retries on Connect, the delay between retries
+	// starts at ConnectRetryInterval and doubles after each retry
+	ConnectRetries       int
+	ConnectRetryInterval time.Duration
+	// Read-only replicas serving data reads, unhealthy replicas are ejected
+	// until a health check every ReplicaHealthCheckInterval succeeds
+	Replicas                   []PostgresReplica
+	ReplicaHealthCheckInterval time.Duration
+}
+
+// Address returns database address without credentials, e.g. for logs
+func (c PostgresConfig) Address() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port)) + "/" + c.Name
+}
+
+// replica returns connection parameters of a replica
+func (c PostgresConfig) replica(r PostgresReplica) PostgresConfig {
+	c.Host = r.Host
+	if r.Port != 0 {
+		c.Port = r.Port
+	}
+	c.Replicas = nil
+	return c
+}
+
+// dsn returns connection string without password
+func (c PostgresConfig) dsn() string {
+	params := []struct {
+		key   string
+		value string
+	}{
+		{"host", c.Host},
+		{"port", strconv.Itoa(c.Port)},
+		{"user", c.Username},
+		{"dbname", c.Name},
+		{"sslmode", c.SSLMode},
+		{"sslrootcert", c.SSLRootCert},
+	}
+	var dsn []string
+	for _, p := range params {
+		if len(p.value) != 0 {
+			dsn = append(dsn, p.key+"="+dsnValue(p.value))
+		}
+	}
+	return strings.Join(dsn, " ")
+}
+
+// dsnValue quotes connection string value
+func dsnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// poolConfig returns pgx pool configuration
+func (c PostgresConfig) poolConfig() (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(c.dsn())
+	if err != nil {
+		return nil, err
+	}
+	// Password is set separately, so it never appears in the connection string
+	config.ConnConfig.Password = c.Password
+	if c.ConnectTimeout > 0 {
+		config.ConnConfig.ConnectTimeout = c.ConnectTimeout
+	}
+	if len(c.ApplicationName) != 0 {
+		config.ConnConfig.RuntimeParams["application_name"] = c.ApplicationName
+	}
+	if c.StatementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+	if c.MaxConns > 0 {
+		config.MaxConns = c.MaxConns
+	}
+	if c.MinConns > 0 {
+		config.MinConns = c.MinConns
+	}
+	if c.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = c.MaxConnLifetime
+	}
+	if c.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = c.MaxConnIdleTime
+	}
+	return config, nil
+}
+
+// redact replaces password in the error message
+func (c PostgresConfig) redact(err error) error {
+	if err == nil || len(c.Password) == 0 || !strings.Contains(err.Error(), c.Password) {
+		return err
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), c.Password, redactedPassword))
+}
+
+// String returns connection string with redacted password, so the configuration can be logged
+func (c PostgresConfig) String() string {
+	if len(c.Password) == 0 {
+		return c.dsn()
+	}
+	return fmt.Sprintf("%s password=%s", c.dsn(), redactedPassword)
+}
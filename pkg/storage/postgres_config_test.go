@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PostgresConfig_poolConfig(t *testing.T) {
+	pc := PostgresConfig{
+		Host:             "db.example.com",
+		Port:             5432,
+		Username:         "app",
+		Password:         `pa's s\word`,
+		Name:             "my db",
+		SSLMode:          "disable",
+		ApplicationName:  "go-rest-template",
+		ConnectTimeout:   5 * time.Second,
+		StatementTimeout: 30 * time.Second,
+		MaxConns:         8,
+		MinConns:         2,
+		MaxConnLifetime:  time.Hour,
+		MaxConnIdleTime:  10 * time.Minute,
+	}
+	require.NotContains(t, pc.dsn(), "password")
+	require.NotContains(t, pc.String(), pc.Password)
+	require.Contains(t, pc.String(), "password="+redactedPassword)
+
+	config, err := pc.poolConfig()
+	require.NoError(t, err, "expected to get no error, but got: %v", err)
+	require.Equal(t, "db.example.com", config.ConnConfig.Host)
+	require.Equal(t, uint16(5432), config.ConnConfig.Port)
+	require.Equal(t, "app", config.ConnConfig.User)
+	require.Equal(t, pc.Password, config.ConnConfig.Password)
+	require.Equal(t, "my db", config.ConnConfig.Database)
+	require.Nil(t, config.ConnConfig.TLSConfig, "TLS must be disabled")
+	require.Equal(t, 5*time.Second, config.ConnConfig.ConnectTimeout)
+	require.Equal(t, "go-rest-template", config.ConnConfig.RuntimeParams["application_name"])
+	require.Equal(t, "30000", config.ConnConfig.RuntimeParams["statement_timeout"])
+	require.Equal(t, int32(8), config.MaxConns)
+	require.Equal(t, int32(2), config.MinConns)
+	require.Equal(t, time.Hour, config.MaxConnLifetime)
+	require.Equal(t, 10*time.Minute, config.MaxConnIdleTime)
+
+	// CA verification
+	pc.SSLMode = "verify-ca"
+	pc.SSLRootCert = "missing-ca.crt"
+	_, err = pc.poolConfig()
+	require.Error(t, err, "expected to see an error, but got nil")
+}
+
+func Test_PostgresStorage_Connect_Retry(t *testing.T) {
+	// Nothing listens on the port once the listener is closed
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	ps := DefinePostgresStorage(PostgresConfig{
+		Host:                 "127.0.0.1",
+		Port:                 port,
+		Username:             "app",
+		Password:             "topsecret",
+		Name:                 "db",
+		SSLMode:              "disable",
+		ConnectTimeout:       time.Second,
+		ConnectRetries:       2,
+		ConnectRetryInterval: 10 * time.Millisecond,
+	})
+	start := time.Now()
+	err = ps.Connect()
+	require.Error(t, err, "expected to see an error, but got nil")
+	require.Contains(t, err.Error(), "(3 attempt(s))")
+	require.NotContains(t, err.Error(), "topsecret")
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(30*time.Millisecond), "expected backoff between retries")
+}
+
+func Test_PostgresConfig_redact(t *testing.T) {
+	pc := PostgresConfig{Password: "topsecret"}
+	err := pc.redact(errors.New("failed to connect with password=topsecret"))
+	require.EqualError(t, err, "failed to connect with password="+redactedPassword)
+	require.Nil(t, pc.redact(nil))
+}
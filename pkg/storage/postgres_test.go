@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTx records statements and transaction control, nested transactions share the log
+type fakeTx struct {
+	pgx.Tx
+	log       *[]string
+	commitErr error
+	done      bool
+}
+
+func (tx *fakeTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	*tx.log = append(*tx.log, "begin")
+	return &fakeTx{log: tx.log, commitErr: tx.commitErr}, nil
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	*tx.log = append(*tx.log, "exec")
+	return pgconn.CommandTag("UPDATE 1"), nil
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	tx.done = true
+	if tx.commitErr != nil {
+		return tx.commitErr
+	}
+	*tx.log = append(*tx.log, "commit")
+	return nil
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	if !tx.done {
+		tx.done = true
+		*tx.log = append(*tx.log, "rollback")
+	}
+	return nil
+}
+
+func Test_PostgresStorage_WithTx(t *testing.T) {
+	errTx := errors.New("transaction failed")
+	tt := []struct {
+		name        string
+		fn          func(tx DB) error
+		commitErr   error
+		expectedErr string
+		expectedLog []string
+	}{
+		{
+			name:        "Committed",
+			fn:          func(tx DB) error { return tx.UpdateAPIKeyLastUsed(1, time.Now()) },
+			expectedLog: []string{"begin", "exec", "commit"},
+		},
+		{
+			name: "Rolled back",
+			fn: func(tx DB) error {
+				if err := tx.UpdateAPIKeyLastUsed(1, time.Now()); err != nil {
+					return err
+				}
+				return errTx
+			},
+			expectedErr: errTx.Error(),
+			expectedLog: []string{"begin", "exec", "rollback"},
+		},
+		{
+			name:        "Commit failed",
+			fn:          func(tx DB) error { return tx.UpdateAPIKeyLastUsed(1, time.Now()) },
+			commitErr:   errors.New("connection lost"),
+			expectedErr: "failed to commit transaction: connection lost",
+			expectedLog: []string{"begin", "exec"},
+		},
+		{
+			name: "Nested transaction",
+			fn: func(tx DB) error {
+				return tx.WithTx(func(nested DB) error { return nested.UpdateAPIKeyLastUsed(1, time.Now()) })
+			},
+			expectedLog: []string{"begin", "begin", "exec", "commit", "commit"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var log []string
+			ps := &PostgresStorage{db: &fakeTx{log: &log, commitErr: tc.commitErr}}
+			err := ps.WithTx(tc.fn)
+			if len(tc.expectedErr) != 0 {
+				require.EqualError(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err, "expected transaction to succeed")
+			}
+			require.Equal(t, tc.expectedLog, log)
+		})
+	}
+
+	t.Run("Panic", func(t *testing.T) {
+		var log []string
+		ps := &PostgresStorage{db: &fakeTx{log: &log}}
+		require.Panics(t, func() {
+			ps.WithTx(func(tx DB) error { panic("unexpected") })
+		})
+		require.Equal(t, []string{"begin", "rollback"}, log, "expected transaction to be rolled back on panic")
+	})
+}
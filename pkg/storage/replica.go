@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// defaultReplicaHealthCheckInterval is used if replica health check interval is not configured
+const defaultReplicaHealthCheckInterval = 10 * time.Second
+
+// PrimaryReader is implemented by storages which serve reads from replicas,
+// Primary returns a view of the storage which reads only from the primary
+// database (e.g. to read own writes), the view must not be closed
+type PrimaryReader interface {
+	Primary() DB
+}
+
+// PostgresReplica represents a read-only replica, other connection
+// parameters are the same as the primary ones
+type PostgresReplica struct {
+	Host string
+	// Primary port is used if 0
+	Port int
+}
+
+// replica is a connection pool of a read-only replica
+type replica struct {
+	config PostgresConfig
+	pool   *pgxpool.Pool
+	// 1 if replica serves reads, 0 if it is ejected
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// setHealthy returns replica to rotation or ejects it, change is logged
+func (r *replica) setHealthy(healthy bool, reason error) {
+	if healthy {
+		if atomic.CompareAndSwapInt32(&r.healthy, 0, 1) {
+			log.Printf("[Postgres] Replica '%s' is healthy, it serves reads", r.config.Address())
+		}
+		return
+	}
+	if atomic.CompareAndSwapInt32(&r.healthy, 1, 0) {
+		log.Printf("[Postgres] Replica '%s' is ejected, reads are served by other databases: %v", r.config.Address(), r.config.redact(reason))
+	}
+}
+
+// replicaSet balances reads across healthy replicas
+type replicaSet struct {
+	replicas []*replica
+	next     uint32
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// pick returns the next healthy replica in round-robin order, nil if there is none
+func (rs *replicaSet) pick() *replica {
+	if rs == nil {
+		return nil
+	}
+	healthy := make([]*replica, 0, len(rs.replicas))
+	for _, r := range rs.replicas {
+		if r.isHealthy() {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	return healthy[atomic.AddUint32(&rs.next, 1)%uint32(len(healthy))]
+}
+
+// check pings every replica and updates its health
+func (rs *replicaSet) check(timeout time.Duration) {
+	for _, r := range rs.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		conn, err := r.pool.Acquire(ctx)
+		if err == nil {
+			err = conn.Conn().Ping(ctx)
+			conn.Release()
+		}
+		cancel()
+		r.setHealthy(err == nil, err)
+	}
+}
+
+// watch checks replicas health on each interval until the set is closed
+func (rs *replicaSet) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rs.check(interval)
+		case <-rs.stop:
+			return
+		}
+	}
+}
+
+func (rs *replicaSet) close() {
+	rs.stopOnce.Do(func() {
+		close(rs.stop)
+		for _, r := range rs.replicas {
+			r.pool.Close()
+		}
+	})
+}
+
+// connectReplicas creates replica pools, connections are established lazily,
+// so unavailable replicas don't block startup and are ejected until they recover
+func (ps *PostgresStorage) connectReplicas() error {
+	if len(ps.config.Replicas) == 0 {
+		return nil
+	}
+	rs := &replicaSet{stop: make(chan struct{})}
+	for _, rc := range ps.config.Replicas {
+		config := ps.config.replica(rc)
+		poolConfig, err := config.poolConfig()
+		if err != nil {
+			rs.close()
+			return config.redact(err)
+		}
+		poolConfig.LazyConnect = true
+		pool, err := pgxpool.ConnectConfig(context.Background(), poolConfig)
+		if err != nil {
+			rs.close()
+			return config.redact(err)
+		}
+		// Replicas start ejected and join after the first successful check
+		rs.replicas = append(rs.replicas, &replica{config: config, pool: pool})
+	}
+	interval := ps.config.ReplicaHealthCheckInterval
+	if interval <= 0 {
+		interval = defaultReplicaHealthCheckInterval
+	}
+	rs.check(interval)
+	go rs.watch(interval)
+	ps.replicas = rs
+	return nil
+}
+
+// read runs a query which may be served by a replica, if the replica can't be
+// reached it is ejected and the query is run on the primary
+func (ps *PostgresStorage) read(query func(db querier) error) error {
+	r := ps.replicas.pick()
+	if r == nil {
+		return query(ps.db)
+	}
+	err := query(r.pool)
+	if err == nil || !isConnectionError(err) {
+		return err
+	}
+	r.setHealthy(false, err)
+	return query(ps.db)
+}
+
+// Primary returns a view of the storage which reads only from the primary database
+func (ps *PostgresStorage) Primary() DB {
+	return &PostgresStorage{config: ps.config, db: ps.db}
+}
+
+// isConnectionError reports whether the query failed because the database
+// can't be reached or doesn't accept connections, errors of the query itself
+// (no rows, scan, statement timeout etc.) don't make the replica unhealthy
+func isConnectionError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		// Admin shutdown, crash shutdown and cannot connect now
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+		return false
+	}
+	if pgconn.SafeToRetry(err) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
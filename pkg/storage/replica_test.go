@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReplicaSet_Pick(t *testing.T) {
+	var rs *replicaSet
+	require.Nil(t, rs.pick(), "expected no replica without replica set")
+
+	a := &replica{config: PostgresConfig{Host: "a"}, healthy: 1}
+	b := &replica{config: PostgresConfig{Host: "b"}, healthy: 1}
+	c := &replica{config: PostgresConfig{Host: "c"}}
+	rs = &replicaSet{replicas: []*replica{a, b, c}}
+
+	picked := map[*replica]int{}
+	for i := 0; i < 10; i++ {
+		picked[rs.pick()]++
+	}
+	require.Equal(t, map[*replica]int{a: 5, b: 5}, picked, "expected reads to be balanced across healthy replicas")
+
+	a.setHealthy(false, errors.New("connection refused"))
+	for i := 0; i < 3; i++ {
+		require.Equal(t, b, rs.pick(), "expected ejected replica to be skipped")
+	}
+
+	b.setHealthy(false, errors.New("connection refused"))
+	require.Nil(t, rs.pick(), "expected no replica if every replica is ejected")
+
+	c.setHealthy(true, nil)
+	require.Equal(t, c, rs.pick(), "expected recovered replica to serve reads")
+}
+
+func Test_PostgresStorage_Read(t *testing.T) {
+	primary, replicaPool := &pgxpool.Pool{}, &pgxpool.Pool{}
+	tt := []struct {
+		name          string
+		replicaErr    error
+		expectedPools []querier
+		expectedErr   error
+		healthy       bool
+	}{
+		{
+			name:          "Replica serves read",
+			expectedPools: []querier{replicaPool},
+			healthy:       true,
+		},
+		{
+			name:          "Query error is returned",
+			replicaErr:    pgx.ErrNoRows,
+			expectedPools: []querier{replicaPool},
+			expectedErr:   pgx.ErrNoRows,
+			healthy:       true,
+		},
+		{
+			name:          "Scan error doesn't eject replica",
+			replicaErr:    errors.New("can't scan into dest[0]"),
+			expectedPools: []querier{replicaPool},
+			expectedErr:   errors.New("can't scan into dest[0]"),
+			healthy:       true,
+		},
+		{
+			name:          "Unreachable replica is ejected",
+			replicaErr:    &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")},
+			expectedPools: []querier{replicaPool, primary},
+			healthy:       false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &replica{pool: replicaPool, healthy: 1}
+			ps := &PostgresStorage{pgxPool: primary, db: primary, replicas: &replicaSet{replicas: []*replica{r}}}
+			var pools []querier
+			err := ps.read(func(db querier) error {
+				pools = append(pools, db)
+				if db == replicaPool {
+					return tc.replicaErr
+				}
+				return nil
+			})
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expectedPools, pools)
+			require.Equal(t, tc.healthy, r.isHealthy())
+
+			var primaryPools []querier
+			err = ps.Primary().(*PostgresStorage).read(func(db querier) error {
+				primaryPools = append(primaryPools, db)
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, []querier{primary}, primaryPools, "expected primary view to read from primary")
+		})
+	}
+}
+
+func Test_IsConnectionError(t *testing.T) {
+	tt := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "No rows", err: pgx.ErrNoRows, expected: false},
+		{name: "Wrapped no rows", err: fmt.Errorf("query failed: %w", pgx.ErrNoRows), expected: false},
+		{name: "Canceled", err: context.Canceled, expected: false},
+		{name: "Syntax error", err: &pgconn.PgError{Code: "42601"}, expected: false},
+		{name: "Statement timeout", err: &pgconn.PgError{Code: "57014"}, expected: false},
+		{name: "Scan error", err: errors.New("can't scan into dest[0]"), expected: false},
+		{name: "Admin shutdown", err: &pgconn.PgError{Code: "57P01"}, expected: true},
+		{name: "Cannot connect now", err: &pgconn.PgError{Code: "57P03"}, expected: true},
+		{name: "Network error", err: fmt.Errorf("query failed: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}), expected: true},
+		{name: "Connection closed", err: io.ErrUnexpectedEOF, expected: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, isConnectionError(tc.err))
+		})
+	}
+}
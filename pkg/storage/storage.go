@@ -1,6 +1,11 @@
 package storage
 
-import "github.com/sergeikus/go-rest-template/pkg/types"
+import (
+	"errors"
+	"time"
+
+	"github.com/sergeikus/go-rest-template/pkg/types"
+)
 
 // DB represents a storage interface which can be
 // in memory or an external database
@@ -18,6 +23,48 @@ type DB interface {
 
 	// User management
 	RegisterUser(types.User) (int, error)
+	GetUserByID(userID int) (types.User, error)
+	GetUserByUsername(username string) (types.User, error)
+	GetUserByEmail(email string) (types.User, error)
+	UpdateUserPassword(userID int, passwordSalt, passwordHash string) error
+	VerifyUserEmail(userID int, email string) error
+
+	// Password reset
+	StorePasswordResetToken(token types.PasswordResetToken) error
+	ConsumePasswordResetToken(tokenHash string) (userID int, err error)
+
+	// Two-factor authentication
+	GetTOTP(userID int) (types.TOTP, error)
+	SaveTOTP(totp types.TOTP) error
+	// UseTOTPStep atomically records a used TOTP time step, ErrInvalidToken
+	// is returned if the same or a later step was already used
+	UseTOTPStep(userID int, step int64) error
+	StoreRecoveryCodes(userID int, codeHashes []string) error
+	ConsumeRecoveryCode(userID int, codeHash string) error
+
+	// API keys
+	CreateAPIKey(key types.APIKey) (int, error)
+	ListAPIKeys(userID int) ([]types.APIKey, error)
+	RevokeAPIKey(userID, keyID int) error
+	GetAPIKeyByPrefix(prefix string) (types.APIKey, error)
+	UpdateAPIKeyLastUsed(keyID int, t time.Time) error
+
+	// Refresh tokens
+	StoreRefreshToken(token types.RefreshToken) error
+	UseRefreshToken(tokenHash string) (types.RefreshToken, error)
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int, keepFamilyID string) error
+
+	// External identities (e.g. OpenID Connect)
+	GetUserByIdentity(issuer, subject string) (types.User, error)
+	LinkUserIdentity(userID int, issuer, subject string) error
+
+	// Transactions
+	// WithTx runs fn in a transaction, every action of tx is committed if fn
+	// returns nil and rolled back otherwise, the error of fn is returned as is.
+	// Nested calls create nested transactions, tx must not be used after fn
+	// returns and must not be connected or closed
+	WithTx(fn func(tx DB) error) error
 }
 
 const (
@@ -26,3 +73,27 @@ const (
 	// DatabaseTypePostgre defines a postgre database
 	DatabaseTypePostgre = "postgres"
 )
+
+// ErrUserExists is returned when a user with the same username
+// or email is already registered
+var ErrUserExists = errors.New("user already exists")
+
+// ErrUserNotFound is returned when requested user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrInvalidToken is returned when token does not exist,
+// has expired or was already used
+var ErrInvalidToken = errors.New("token is invalid or expired")
+
+// ErrTOTPNotFound is returned when user has not started TOTP enrollment
+var ErrTOTPNotFound = errors.New("TOTP is not configured")
+
+// ErrAPIKeyNotFound is returned when API key does not exist
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// ErrRefreshTokenReused is returned when already used refresh token is presented again
+var ErrRefreshTokenReused = errors.New("refresh token was already used")
+
+// ErrTxConflict is returned when a transaction can't be committed because
+// data it read was changed by another transaction
+var ErrTxConflict = errors.New("transaction conflicts with a concurrent change")
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // Data represents data from data_table
 type Data struct {
 	ID     int    `json:"id"`
@@ -15,4 +17,51 @@ type User struct {
 	PasswordHash string `json:"passwordHash"`
 	Email        string `json:"email"`
 	IsDisabled   bool   `json:"isDisabled"`
+	IsVerified   bool   `json:"isVerified"`
+	IsAdmin      bool   `json:"isAdmin"`
+}
+
+// TOTP is a user time-based one-time password second factor
+type TOTP struct {
+	UserID int    `json:"userId"`
+	Secret string `json:"-"`
+	// Enabled is set once enrollment is confirmed with a valid code
+	Enabled bool `json:"enabled"`
+	// Last accepted time step, used to reject code reuse
+	LastUsedStep int64 `json:"-"`
+}
+
+// PasswordResetToken is a single-use token which allows
+// user to set a new password, only token hash is stored
+type PasswordResetToken struct {
+	TokenHash string    `json:"-"`
+	UserID    int       `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Used      bool      `json:"used"`
+}
+
+// RefreshToken is a single-use token which is exchanged for a new access token,
+// tokens issued one after another since log in share a family, only token hash is stored
+type RefreshToken struct {
+	TokenHash string    `json:"-"`
+	FamilyID  string    `json:"familyId"`
+	UserID    int       `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Used      bool      `json:"used"`
+}
+
+// APIKey is a named long-lived credential for service-to-service calls,
+// only key hash is stored, prefix is kept to identify the key
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"userId"`
+	Username   string     `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	Revoked    bool       `json:"revoked"`
 }